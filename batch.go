@@ -5,6 +5,7 @@ import (
 	"go-bitcask/data"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // nonTransactionSeqNo 非事务操作序列号
@@ -32,6 +33,19 @@ func (db *DB) NewWriteBtach(opts WriteBatchOptions) *WriteBatch {
 
 // Put 批量写数据
 func (wb *WriteBatch) Put(key, value []byte) error {
+	return wb.put(key, value, 0)
+}
+
+// PutWithTTL 批量写数据，数据在 ttl 之后自动过期
+// 过期时间从调用时开始计算，而不是从 Commit 时开始计算
+func (wb *WriteBatch) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return wb.put(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+func (wb *WriteBatch) put(key, value []byte, expire int64) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
@@ -39,7 +53,7 @@ func (wb *WriteBatch) Put(key, value []byte) error {
 	defer wb.mu.Unlock()
 
 	// 暂存LogRecord
-	logRecord := &data.LogRecord{Key: key, Value: value}
+	logRecord := &data.LogRecord{Key: key, Value: value, Expire: expire}
 	wb.pendingWrites[string(key)] = logRecord
 	return nil
 }
@@ -80,19 +94,33 @@ func (wb *WriteBatch) Commit() error {
 	}
 
 	// 加锁保证事务串行化
-	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
+	wb.db.lock()
+	defer wb.db.unlock()
+
+	if err := wb.db.commitRecords(wb.pendingWrites, wb.options.SyncWrites); err != nil {
+		return err
+	}
+
+	// 清空暂存数据
+	wb.pendingWrites = make(map[string]*data.LogRecord)
 
+	return nil
+}
+
+// commitRecords 以事务的方式将暂存的数据写到数据文件，并更新内存索引
+// 在使用此方法前必须持有互斥锁
+func (db *DB) commitRecords(pendingWrites map[string]*data.LogRecord, syncWrites bool) error {
 	// 获取当前最新的事务序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 
 	// 开始写数据到数据文件中
 	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+	for _, record := range pendingWrites {
+		logRecordPos, err := db.appendLogRecord(&data.LogRecord{
+			Key:    logRecordKeyWithSeq(record.Key, seqNo),
+			Value:  record.Value,
+			Type:   record.Type,
+			Expire: record.Expire,
 		})
 		if err != nil {
 			return err
@@ -105,32 +133,35 @@ func (wb *WriteBatch) Commit() error {
 		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
 		Type: data.LogRecordTxnFinished,
 	}
-	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
+	finishedPos, err := db.appendLogRecord(finishedRecord)
+	if err != nil {
 		return err
 	}
+	// 事务完成标记只在加载时使用，可以直接被回收
+	db.addReclaimable(finishedPos)
 
 	// 根据配置决定是否持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+	if syncWrites && db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
 			return err
 		}
 	}
 
-	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
-		if record.Type == data.LogRecordNormal {
-			wb.db.index.Put(record.Key, pos)
+	// 更新内存索引，B+ 树索引中整个事务和水位在同一个 bbolt 事务中提交
+	return db.updateIndex(func(idx indexWriter) {
+		for _, record := range pendingWrites {
+			pos := positions[string(record.Key)]
+			db.markModified(record.Key)
+			if record.Type == data.LogRecordNormal {
+				db.addStaleReclaimable(idx.Put(record.Key, pos))
+			}
+			if record.Type == data.LogRecordDelete {
+				db.addStaleReclaimable(idx.Get(record.Key))
+				idx.Delete(record.Key)
+				db.addReclaimable(pos)
+			}
 		}
-		if record.Type == data.LogRecordDelete {
-			wb.db.index.Delete(record.Key)
-		}
-	}
-
-	// 清空暂存数据
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-
-	return nil
+	})
 }
 
 // logRecordKeyWithSeq 将 key + seq 编码
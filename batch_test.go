@@ -4,6 +4,7 @@ import (
 	"go-bitcask/utils"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -86,3 +87,41 @@ func TestDB_WriteBatch2(t *testing.T) {
 	// 校验事务序列号
 	assert.Equal(t, uint64(2), db.seqNo)
 }
+
+func TestDB_WriteBatch_PutWithTTL(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	wb := db.NewWriteBtach(DefaultWriteBatchOptions)
+	err = wb.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(10), -time.Second)
+	assert.Equal(t, ErrInvalidTTL, err)
+	err = wb.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(10), 50*time.Millisecond)
+	assert.Nil(t, err)
+	err = wb.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+
+	// 迭代器跳过已过期的 key
+	it := db.NewIterator(DefaultIteratorOption)
+	defer it.Close()
+	var keys [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.Equal(t, [][]byte{utils.GetTestKey(2)}, keys)
+}
@@ -5,6 +5,7 @@ import (
 	"go-bitcask/utils"
 	"math/rand"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -36,6 +37,42 @@ func Benchmark_Put(b *testing.B) {
 	}
 }
 
+// Benchmark_PutParallel 并发写入不同的 key，分片索引只有追加数据文件时需要持有互斥锁
+func Benchmark_PutParallel(b *testing.B) {
+	for _, indexType := range []gobitcask.IndexerType{gobitcask.BTree, gobitcask.ShardedBTree} {
+		name := "BTree"
+		if indexType == gobitcask.ShardedBTree {
+			name = "ShardedBTree"
+		}
+		b.Run(name, func(b *testing.B) {
+			options := gobitcask.DefaultOption
+			dir, _ := os.MkdirTemp("", "go-bitcask-bench-parallel")
+			defer os.RemoveAll(dir)
+			options.DirPath = dir
+			options.IndexType = indexType
+			parallelDB, err := gobitcask.Open(options)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer parallelDB.Close()
+
+			// RandomValue 不是并发安全的，所有写入使用同一个 value
+			value := utils.RandomValue(1024) // value size: 1KB
+			var counter int64
+			b.ResetTimer()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					key := utils.GetTestKey(int(atomic.AddInt64(&counter, 1)))
+					if err := parallelDB.Put(key, value); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
 func Benchmark_Get(b *testing.B) {
 	for i := 0; i < 10000; i++ {
 		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024)) // value size: 1KB
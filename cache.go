@@ -0,0 +1,89 @@
+package gobitcask
+
+import (
+	"container/list"
+	"go-bitcask/data"
+	"sync"
+	"sync/atomic"
+)
+
+// cacheKey 缓存的 key，数据写入之后位置不会再改变，只有 merge 会复用文件 id
+type cacheKey struct {
+	fid    uint32
+	offset int64
+}
+
+// cacheEntry 缓存中的一条数据
+type cacheEntry struct {
+	key   cacheKey
+	value []byte
+}
+
+// valueCache 按照字节数限制大小的 LRU 缓存，缓存数据记录中解码之后的 value
+type valueCache struct {
+	mu       sync.Mutex
+	capacity int64 // 缓存的最大字节数
+	size     int64 // 当前缓存的字节数
+	items    map[cacheKey]*list.Element
+	lru      *list.List // 最近访问的数据在前面
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+}
+
+func newValueCache(capacity int64) *valueCache {
+	return &valueCache{
+		capacity: capacity,
+		items:    make(map[cacheKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get 获取 pos 位置的 value，返回的是缓存数据的拷贝
+func (c *valueCache) get(pos *data.LogRecordPos) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[cacheKey{fid: pos.Fid, offset: pos.Offset}]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.lru.MoveToFront(elem)
+	value := elem.Value.(*cacheEntry).value
+	return append(make([]byte, 0, len(value)), value...), true
+}
+
+// put 缓存 pos 位置的 value，超过容量时淘汰最久没有访问的数据
+func (c *valueCache) put(pos *data.LogRecordPos, value []byte) {
+	// 超过容量的 value 不缓存
+	if int64(len(value)) > c.capacity {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey{fid: pos.Fid, offset: pos.Offset}
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	entry := &cacheEntry{key: key, value: append(make([]byte, 0, len(value)), value...)}
+	c.items[key] = c.lru.PushFront(entry)
+	c.size += int64(len(value))
+	for c.size > c.capacity {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// purge 清空缓存
+func (c *valueCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[cacheKey]*list.Element)
+	c.lru.Init()
+	c.size = 0
+}
+
+func (c *valueCache) removeElement(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.value))
+}
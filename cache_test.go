@@ -0,0 +1,99 @@
+package gobitcask
+
+import (
+	"go-bitcask/data"
+	"go-bitcask/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueCache_Evict(t *testing.T) {
+	cache := newValueCache(10)
+	pos1 := &data.LogRecordPos{Fid: 1, Offset: 0}
+	pos2 := &data.LogRecordPos{Fid: 1, Offset: 100}
+	pos3 := &data.LogRecordPos{Fid: 2, Offset: 0}
+
+	cache.put(pos1, []byte("aaaa"))
+	cache.put(pos2, []byte("bbbb"))
+	// 访问 pos1 之后 pos2 成为最久没有访问的数据
+	value, ok := cache.get(pos1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("aaaa"), value)
+
+	cache.put(pos3, []byte("cccc"))
+	_, ok = cache.get(pos2)
+	assert.False(t, ok)
+	_, ok = cache.get(pos1)
+	assert.True(t, ok)
+	_, ok = cache.get(pos3)
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), cache.size)
+
+	// 超过容量的 value 不缓存
+	cache.put(&data.LogRecordPos{Fid: 3}, make([]byte, 11))
+	_, ok = cache.get(&data.LogRecordPos{Fid: 3})
+	assert.False(t, ok)
+
+	// 修改返回的 value 不影响缓存
+	value[0] = 'x'
+	value, _ = cache.get(pos1)
+	assert.Equal(t, []byte("aaaa"), value)
+
+	assert.Equal(t, uint64(4), cache.hits.Load())
+	assert.Equal(t, uint64(2), cache.misses.Load())
+
+	cache.purge()
+	_, ok = cache.get(pos1)
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), cache.size)
+}
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-cache")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.ValueCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	value, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	value2, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, value2)
+
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), stat.CacheHits)
+	assert.Equal(t, uint64(1), stat.CacheMisses)
+
+	// 覆盖写入之后 key 指向新的位置，不会读到缓存中的旧值
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("new value")))
+	value, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), value)
+
+	// merge 之后的数据文件复用了旧的文件 id，读取的仍然是正确的值
+	values := make(map[int][]byte)
+	for i := 0; i < 1000; i++ {
+		if i%2 == 0 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			continue
+		}
+		values[i], err = db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, db.Merge())
+	for i, v := range values {
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, v, value)
+	}
+}
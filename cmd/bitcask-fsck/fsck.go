@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go-bitcask/data"
+	"go-bitcask/fio"
+	"go-bitcask/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 与存储引擎中的事务标记保持一致
+var txnFinKey = []byte("txn-fin")
+
+// problem 检查过程中发现的问题
+type problem struct {
+	file   string // 出现问题的文件名
+	offset int64  // 出现问题的位置，-1 表示整个文件
+	reason string // 问题描述
+}
+
+func (p *problem) String() string {
+	if p.offset < 0 {
+		return fmt.Sprintf("%s: %s", p.file, p.reason)
+	}
+	return fmt.Sprintf("%s@%d: %s", p.file, p.offset, p.reason)
+}
+
+// txnState 事务记录的统计信息
+type txnState struct {
+	records  int    // 事务中数据记录的数量
+	finished bool   // 是否有事务完成的标记
+	file     string // 第一条记录所在的文件
+	offset   int64  // 第一条记录所在的位置
+}
+
+// checker 只读地检查一个数据目录，不会获取目录的文件锁
+type checker struct {
+	dirPath   string
+	cipher    *data.Cipher // 解密数据使用的 Cipher，数据没有加密时为空
+	fileIds   []uint32
+	dataFiles map[uint32]*data.DataFile
+	txns      map[uint64]*txnState
+	problems  []*problem
+	records   int // 有效记录的数量
+}
+
+func newChecker(dirPath string, cipher *data.Cipher) (*checker, error) {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{
+		dirPath:   dirPath,
+		cipher:    cipher,
+		dataFiles: make(map[uint32]*data.DataFile),
+		txns:      make(map[uint64]*txnState),
+	}
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+		if err != nil {
+			c.report(entry.Name(), -1, "invalid data file name")
+			continue
+		}
+		c.fileIds = append(c.fileIds, uint32(fileId))
+	}
+	sort.Slice(c.fileIds, func(i, j int) bool {
+		return c.fileIds[i] < c.fileIds[j]
+	})
+
+	// 使用内存映射只读地打开数据文件，文件头无效的文件不再继续检查
+	var fileIds []uint32
+	for _, fid := range c.fileIds {
+		dataFile, err := data.OpenDataFile(dirPath, fid, fio.MemoryMap)
+		if errors.Is(err, data.ErrInvalidFileHeader) || errors.Is(err, data.ErrUnsupportedFileVersion) {
+			c.report(filepath.Base(data.GetDatafleName(dirPath, fid)), 0, "%v", err)
+			continue
+		}
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+		dataFile.Cipher = cipher
+		c.dataFiles[fid] = dataFile
+		fileIds = append(fileIds, fid)
+	}
+	c.fileIds = fileIds
+	return c, nil
+}
+
+func (c *checker) close() {
+	for _, dataFile := range c.dataFiles {
+		_ = dataFile.Close()
+	}
+}
+
+func (c *checker) report(file string, offset int64, format string, args ...interface{}) {
+	c.problems = append(c.problems, &problem{file: file, offset: offset, reason: fmt.Sprintf(format, args...)})
+}
+
+// scanDataFile 遍历数据文件中的所有记录，遇到损坏的数据时跳过，继续查找下一条有效记录
+// 每读到一条有效记录都会调用 fn
+func (c *checker) scanDataFile(dataFile *data.DataFile, fn func(record *data.LogRecord, offset, size int64) error) error {
+	fileName := filepath.Base(data.GetDatafleName(c.dirPath, dataFile.FileId))
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+
+	var offset, corruptedAt = dataFile.HeaderSize(), int64(-1)
+	for offset < fileSize {
+		record, size, err := dataFile.ReadLogRecord(offset)
+		if err == nil {
+			if corruptedAt >= 0 {
+				c.report(fileName, corruptedAt, "%d corrupted bytes skipped", offset-corruptedAt)
+				corruptedAt = -1
+			}
+			if err := fn(record, offset, size); err != nil {
+				return err
+			}
+			offset += size
+			continue
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF && !errors.Is(err, data.ErrInvalidCRC) {
+			return err
+		}
+		// 从损坏位置的下一个字节开始重新查找有效的记录
+		if corruptedAt < 0 {
+			corruptedAt = offset
+		}
+		offset++
+	}
+	if corruptedAt >= 0 {
+		c.report(fileName, corruptedAt, "%d corrupted bytes at the end of file", fileSize-corruptedAt)
+	}
+	return nil
+}
+
+// checkDataFiles 检查所有数据文件的 CRC，并统计事务记录
+func (c *checker) checkDataFiles() error {
+	for _, fid := range c.fileIds {
+		fileName := filepath.Base(data.GetDatafleName(c.dirPath, fid))
+		err := c.scanDataFile(c.dataFiles[fid], func(record *data.LogRecord, offset, size int64) error {
+			c.records++
+			seqNo, n := binary.Uvarint(record.Key)
+			if n <= 0 {
+				c.report(fileName, offset, "invalid key encoding")
+				return nil
+			}
+			if seqNo == 0 {
+				return nil
+			}
+			txn := c.txns[seqNo]
+			if txn == nil {
+				txn = &txnState{file: fileName, offset: offset}
+				c.txns[seqNo] = txn
+			}
+			if record.Type == data.LogRecordTxnFinished && bytes.Equal(record.Key[n:], txnFinKey) {
+				txn.finished = true
+			} else {
+				txn.records++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// 没有事务完成标记的记录不会生效
+	seqNos := make([]uint64, 0, len(c.txns))
+	for seqNo := range c.txns {
+		seqNos = append(seqNos, seqNo)
+	}
+	sort.Slice(seqNos, func(i, j int) bool {
+		return seqNos[i] < seqNos[j]
+	})
+	for _, seqNo := range seqNos {
+		if txn := c.txns[seqNo]; !txn.finished {
+			c.report(txn.file, txn.offset, "%d orphaned records of transaction %d", txn.records, seqNo)
+		}
+	}
+	return nil
+}
+
+// checkPos 检查索引位置上是否是对应 key 的记录
+func (c *checker) checkPos(hintName string, hintOffset int64, key []byte, withSeq bool, pos *data.LogRecordPos) {
+	dataFile := c.dataFiles[pos.Fid]
+	if dataFile == nil {
+		c.report(hintName, hintOffset, "points to missing data file %d", pos.Fid)
+		return
+	}
+	record, size, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		c.report(hintName, hintOffset, "points to unreadable record %d@%d: %v", pos.Fid, pos.Offset, err)
+		return
+	}
+	recordKey := record.Key
+	if !withSeq {
+		_, n := binary.Uvarint(recordKey)
+		recordKey = recordKey[n:]
+	}
+	if !bytes.Equal(recordKey, key) {
+		c.report(hintName, hintOffset, "key mismatch with record %d@%d", pos.Fid, pos.Offset)
+		return
+	}
+	if pos.Size > 0 && int64(pos.Size) != size {
+		c.report(hintName, hintOffset, "size %d mismatch with record %d@%d of size %d", pos.Size, pos.Fid, pos.Offset, size)
+	}
+	if pos.Expire != record.Expire {
+		c.report(hintName, hintOffset, "expire mismatch with record %d@%d", pos.Fid, pos.Offset)
+	}
+}
+
+// checkMergeFiles 检查 merge 完成的标识文件以及 merge 生成的 hint 文件
+func (c *checker) checkMergeFiles() error {
+	finName := filepath.Join(c.dirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(finName); os.IsNotExist(err) {
+		return nil
+	}
+	finFile, err := data.OpenMergeFinishedFile(c.dirPath, fio.MemoryMap)
+	if errors.Is(err, data.ErrInvalidFileHeader) || errors.Is(err, data.ErrUnsupportedFileVersion) {
+		c.report(data.MergeFinishedFileName, 0, "%v", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer finFile.Close()
+	record, _, err := finFile.ReadLogRecord(finFile.HeaderSize())
+	if err != nil {
+		c.report(data.MergeFinishedFileName, finFile.HeaderSize(), "unreadable: %v", err)
+		return nil
+	}
+	nonMergeFileId, err := strconv.Atoi(string(record.Value))
+	if err != nil {
+		c.report(data.MergeFinishedFileName, finFile.HeaderSize(), "invalid non-merge file id %q", record.Value)
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(c.dirPath, data.HintFileName)); os.IsNotExist(err) {
+		c.report(data.HintFileName, -1, "missing while %s exists", data.MergeFinishedFileName)
+		return nil
+	}
+	hintFile, err := data.OpenHintFile(c.dirPath, fio.MemoryMap)
+	if errors.Is(err, data.ErrInvalidFileHeader) || errors.Is(err, data.ErrUnsupportedFileVersion) {
+		c.report(data.HintFileName, 0, "%v", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+	hintFile.Cipher = c.cipher
+	var offset = hintFile.HeaderSize()
+	for {
+		record, size, err := hintFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.report(data.HintFileName, offset, "unreadable: %v", err)
+			break
+		}
+		pos := data.DecodeLogRecordPos(record.Value)
+		if pos.Fid >= uint32(nonMergeFileId) {
+			c.report(data.HintFileName, offset, "points to file %d not merged before %d", pos.Fid, nonMergeFileId)
+		} else {
+			c.checkPos(data.HintFileName, offset, record.Key, false, pos)
+		}
+		offset += size
+	}
+	return nil
+}
+
+// checkHintFiles 检查每个数据文件对应的 hint 文件
+func (c *checker) checkHintFiles() error {
+	for _, fid := range c.fileIds {
+		hintName := filepath.Base(data.GetHintFileName(c.dirPath, fid))
+		if _, err := os.Stat(filepath.Join(c.dirPath, hintName)); os.IsNotExist(err) {
+			continue
+		}
+		hintFile, err := data.OpenDataHintFile(c.dirPath, fid, fio.MemoryMap)
+		if errors.Is(err, data.ErrInvalidFileHeader) || errors.Is(err, data.ErrUnsupportedFileVersion) {
+			c.report(hintName, 0, "%v", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		hintFile.Cipher = c.cipher
+		dataSize, err := c.dataFiles[fid].IoManager.Size()
+		if err != nil {
+			_ = hintFile.Close()
+			return err
+		}
+
+		var offset = hintFile.HeaderSize()
+		for {
+			record, size, err := hintFile.ReadLogRecord(offset)
+			if err != nil {
+				c.report(hintName, offset, "incomplete hint file: %v", err)
+				break
+			}
+			// key 为空的是最后一条记录，保存了数据文件的大小
+			if len(record.Key) == 0 {
+				if string(record.Value) != strconv.FormatInt(dataSize, 10) {
+					c.report(hintName, offset, "data file size %s mismatch with actual size %d", record.Value, dataSize)
+				}
+				break
+			}
+			pos := data.DecodeLogRecordPos(record.Value)
+			if pos.Fid != fid {
+				c.report(hintName, offset, "points to another data file %d", pos.Fid)
+			} else {
+				c.checkPos(hintName, offset, record.Key, true, pos)
+			}
+			offset += size
+		}
+		_ = hintFile.Close()
+	}
+	return nil
+}
+
+// salvage 将所有有效的记录原样写入到新的目录中，损坏的数据以及 hint 文件都会被丢弃，
+// 新目录中的数据在打开时会重新构建索引，加密的数据使用同一个密钥重新加密，
+// 数据文件中的记录可能引用 value log 文件，value log 文件直接拷贝
+func (c *checker) salvage(outDir string) (int, error) {
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return 0, err
+	}
+	dirEntries, err := os.ReadDir(outDir)
+	if err != nil {
+		return 0, err
+	}
+	if len(dirEntries) > 0 {
+		return 0, fmt.Errorf("the output directory %s is not empty", outDir)
+	}
+
+	// 检查阶段已经报告过损坏的数据，这里不再重复记录
+	problems := c.problems
+	defer func() {
+		c.problems = problems
+	}()
+
+	var salvaged int
+	for _, fid := range c.fileIds {
+		outFile, err := data.OpenDataFile(outDir, fid, fio.StandardFIO)
+		if err != nil {
+			return salvaged, err
+		}
+		err = c.scanDataFile(c.dataFiles[fid], func(record *data.LogRecord, _, _ int64) error {
+			if c.cipher != nil {
+				record = c.cipher.Seal(record)
+			}
+			encRecord, _ := data.EncodeLogRecord(record)
+			salvaged++
+			return outFile.Write(encRecord)
+		})
+		if err == nil {
+			err = outFile.Sync()
+		}
+		_ = outFile.Close()
+		if err != nil {
+			return salvaged, err
+		}
+	}
+
+	dirEntries, err = os.ReadDir(c.dirPath)
+	if err != nil {
+		return salvaged, err
+	}
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), data.ValueLogFileSuffix) {
+			if err := utils.CopyFile(filepath.Join(c.dirPath, entry.Name()), filepath.Join(outDir, entry.Name())); err != nil {
+				return salvaged, err
+			}
+		}
+	}
+	return salvaged, nil
+}
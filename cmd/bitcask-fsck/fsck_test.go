@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	gobitcask "go-bitcask"
+	"go-bitcask/data"
+	"go-bitcask/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readDir 读取目录中所有文件的内容
+func readDir(t *testing.T, dirPath string) map[string][]byte {
+	dirEntries, err := os.ReadDir(dirPath)
+	assert.Nil(t, err)
+	files := make(map[string][]byte)
+	for _, entry := range dirEntries {
+		content, err := os.ReadFile(filepath.Join(dirPath, entry.Name()))
+		assert.Nil(t, err)
+		files[entry.Name()] = content
+	}
+	return files
+}
+
+func TestChecker(t *testing.T) {
+	opts := gobitcask.DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-fsck")
+	outDir, _ := os.MkdirTemp("", "bitcask-go-fsck-out")
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll(outDir)
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.MergeRatio = 0
+
+	// merge 之后留下 merge-finished 和 hint-index 文件，之后的数据写入新的数据文件
+	db, err := gobitcask.Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = gobitcask.Open(opts)
+	assert.Nil(t, err)
+	for i := 200; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 破坏最后一个数据文件中间的数据
+	lastFile := data.GetDatafleName(dir, 0)
+	for fid := uint32(0); ; fid++ {
+		if _, err := os.Stat(data.GetDatafleName(dir, fid)); err != nil {
+			break
+		}
+		lastFile = data.GetDatafleName(dir, fid)
+	}
+	content, err := os.ReadFile(lastFile)
+	assert.Nil(t, err)
+	for i := len(content) / 2; i < len(content)/2+8; i++ {
+		content[i] ^= 0xff
+	}
+	assert.Nil(t, os.WriteFile(lastFile, content, 0644))
+
+	before := readDir(t, dir)
+	assert.Contains(t, before, data.MergeFinishedFileName)
+	assert.Contains(t, before, data.HintFileName)
+
+	c, err := newChecker(dir, nil)
+	assert.Nil(t, err)
+	defer c.close()
+	for _, check := range []func() error{c.checkDataFiles, c.checkMergeFiles, c.checkHintFiles} {
+		assert.Nil(t, check())
+	}
+	var problems []string
+	for _, p := range c.problems {
+		problems = append(problems, p.String())
+	}
+	assert.Equal(t, 1, len(problems), problems)
+	assert.True(t, strings.HasPrefix(problems[0], filepath.Base(lastFile)+"@"), problems)
+	assert.Contains(t, problems[0], "corrupted bytes skipped")
+
+	// 检查过程不会修改或者创建任何文件
+	assert.Equal(t, before, readDir(t, dir))
+
+	// 有效的记录全部写入新的目录，损坏的记录被丢弃
+	salvaged, err := c.salvage(outDir)
+	assert.Nil(t, err)
+	assert.Equal(t, c.records, salvaged)
+	assert.Equal(t, before, readDir(t, dir))
+
+	opts.DirPath = outDir
+	salvagedDB, err := gobitcask.Open(opts)
+	assert.Nil(t, err)
+	defer salvagedDB.Close()
+	keys := salvagedDB.ListKeys()
+	assert.True(t, len(keys) >= 200 && len(keys) < 300, len(keys))
+	for i := 0; i < 200; i++ {
+		_, err := salvagedDB.Get(utils.GetTestKey(i))
+		assert.Nil(t, err, fmt.Sprint(i))
+	}
+}
+
+func TestChecker_EmptyMergeFiles(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-fsck-empty")
+	defer os.RemoveAll(dir)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, data.MergeFinishedFileName), nil, 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, data.HintFileName), nil, 0644))
+
+	c, err := newChecker(dir, nil)
+	assert.Nil(t, err)
+	defer c.close()
+	assert.Nil(t, c.checkMergeFiles())
+	assert.Equal(t, 1, len(c.problems))
+	assert.Equal(t, data.MergeFinishedFileName, c.problems[0].file)
+
+	// 空文件不会被写入文件头
+	for _, name := range []string{data.MergeFinishedFileName, data.HintFileName} {
+		stat, err := os.Stat(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), stat.Size())
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"go-bitcask/data"
+	"os"
+)
+
+var (
+	dir    = flag.String("dir", "", "the directory of the database to check")
+	repair = flag.Bool("repair", false, "salvage all valid records into the output directory")
+	out    = flag.String("out", "", "the output directory used in repair mode, must be empty")
+	key    = flag.String("key", "", "the hex encoded encryption key of the database")
+)
+
+func main() {
+	flag.Parse()
+	if *dir == "" || *repair && *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var cipher *data.Cipher
+	if *key != "" {
+		encryptionKey, err := hex.DecodeString(*key)
+		if err == nil {
+			cipher, err = data.NewCipher(encryptionKey)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid encryption key: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	c, err := newChecker(*dir, cipher)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", *dir, err)
+		os.Exit(2)
+	}
+	defer c.close()
+
+	// 依次检查数据文件、merge 生成的文件以及每个数据文件的 hint 文件
+	for _, check := range []func() error{c.checkDataFiles, c.checkMergeFiles, c.checkHintFiles} {
+		if err := check(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to check %s: %v\n", *dir, err)
+			os.Exit(2)
+		}
+	}
+
+	fmt.Printf("checked %d data files, %d valid records\n", len(c.fileIds), c.records)
+	for _, p := range c.problems {
+		fmt.Println(p)
+	}
+	if len(c.problems) == 0 {
+		fmt.Println("no problems found")
+	} else {
+		fmt.Printf("%d problems found\n", len(c.problems))
+	}
+
+	if *repair {
+		salvaged, err := c.salvage(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to salvage into %s: %v\n", *out, err)
+			os.Exit(2)
+		}
+		fmt.Printf("salvaged %d records into %s\n", salvaged, *out)
+		return
+	}
+	if len(c.problems) > 0 {
+		os.Exit(1)
+	}
+}
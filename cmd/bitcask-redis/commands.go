@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	gobitcask "go-bitcask"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 冲突时最多重试的次数
+const maxTxnRetries = 16
+
+// 客户端的 key 在存储引擎中都带有这个前缀，KEYS 和 SCAN 只遍历这个前缀下的 key，
+// 同一个数据目录中的其他数据（例如 redis 包中数据结构的子 key）不会出现在结果中
+var keyPrefix = []byte("redis:")
+
+// dbKey 返回客户端的 key 在存储引擎中实际使用的 key
+func dbKey(key []byte) []byte {
+	buf := make([]byte, len(keyPrefix)+len(key))
+	copy(buf, keyPrefix)
+	copy(buf[len(keyPrefix):], key)
+	return buf
+}
+
+// execCtx 命令的执行上下文
+// 普通命令直接作用于 DB，MULTI/EXEC 中的命令作用于同一个 Txn
+type execCtx struct {
+	db  *gobitcask.DB
+	txn *gobitcask.Txn
+}
+
+// get 读取数据，事务中能读到事务自身的写入
+func (ctx *execCtx) get(key []byte) ([]byte, error) {
+	if ctx.txn != nil {
+		return ctx.txn.Get(key)
+	}
+	return ctx.db.Get(key)
+}
+
+// update 在事务中执行读-改-写操作
+// 不在 MULTI 中时开启一个新的事务，发生冲突后自动重试
+func (ctx *execCtx) update(fn func(txn *gobitcask.Txn) error) error {
+	if ctx.txn != nil {
+		return fn(ctx.txn)
+	}
+	for i := 0; ; i++ {
+		txn := ctx.db.Begin()
+		if err := fn(txn); err != nil {
+			txn.Rollback()
+			return err
+		}
+		err := txn.Commit()
+		if err != gobitcask.ErrTxnConflict || i >= maxTxnRetries {
+			return err
+		}
+	}
+}
+
+type cmdHandler func(ctx *execCtx, w *respWriter, args [][]byte)
+
+type cmdSpec struct {
+	handler cmdHandler
+	arity   int // 参数个数（包含命令名），负数表示至少 -arity 个
+}
+
+var supportedCommands = map[string]cmdSpec{
+	"ping":    {ping, -1},
+	"echo":    {echo, 2},
+	"get":     {get, 2},
+	"set":     {set, -3},
+	"del":     {del, -2},
+	"exists":  {exists, -2},
+	"keys":    {keys, 2},
+	"scan":    {scan, -2},
+	"expire":  {expire, 3},
+	"command": {command, -1},
+}
+
+// checkArity 校验参数个数
+func (spec cmdSpec) checkArity(args [][]byte) bool {
+	if spec.arity > 0 {
+		return len(args) == spec.arity
+	}
+	return len(args) >= -spec.arity
+}
+
+func wrongArgsError(name string) string {
+	return "ERR wrong number of arguments for '" + name + "' command"
+}
+
+func ping(ctx *execCtx, w *respWriter, args [][]byte) {
+	if len(args) > 1 {
+		w.writeBulkString(args[1])
+		return
+	}
+	w.writeSimpleString("PONG")
+}
+
+func echo(ctx *execCtx, w *respWriter, args [][]byte) {
+	w.writeBulkString(args[1])
+}
+
+// command redis-cli 连接时会发送 COMMAND DOCS，返回空数组即可
+func command(ctx *execCtx, w *respWriter, args [][]byte) {
+	w.writeArrayHeader(0)
+}
+
+func get(ctx *execCtx, w *respWriter, args [][]byte) {
+	value, err := ctx.get(dbKey(args[1]))
+	if err == gobitcask.ErrKeyNotFound {
+		w.writeNull()
+		return
+	}
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeBulkString(value)
+}
+
+// set SET key value [EX seconds | PX milliseconds] [NX | XX]
+func set(ctx *execCtx, w *respWriter, args [][]byte) {
+	key, value := dbKey(args[1]), args[2]
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); opt {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ex", "px":
+			if i+1 >= len(args) || ttl > 0 {
+				w.writeError("ERR syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				w.writeError("ERR value is not an integer or out of range")
+				return
+			}
+			if n <= 0 {
+				w.writeError("ERR invalid expire time in 'set' command")
+				return
+			}
+			if opt == "ex" {
+				ttl = time.Duration(n) * time.Second
+			} else {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+			i++
+		default:
+			w.writeError("ERR syntax error")
+			return
+		}
+	}
+	if nx && xx {
+		w.writeError("ERR syntax error")
+		return
+	}
+
+	// 无条件写入不需要读取旧的数据
+	if ctx.txn == nil && !nx && !xx {
+		if err := putValue(ctx.db, key, value, ttl); err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		w.writeSimpleString("OK")
+		return
+	}
+
+	var skipped bool
+	err := ctx.update(func(txn *gobitcask.Txn) error {
+		skipped = false
+		if nx || xx {
+			_, err := txn.Get(key)
+			if err != nil && err != gobitcask.ErrKeyNotFound {
+				return err
+			}
+			exist := err == nil
+			if nx && exist || xx && !exist {
+				skipped = true
+				return nil
+			}
+		}
+		return putValue(txn, key, value, ttl)
+	})
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	if skipped {
+		w.writeNull()
+		return
+	}
+	w.writeSimpleString("OK")
+}
+
+// del 删除多个 key，不在 MULTI 中时通过 WriteBatch 原子删除
+func del(ctx *execCtx, w *respWriter, args [][]byte) {
+	var deleted int64
+	if ctx.txn != nil {
+		for _, arg := range args[1:] {
+			key := dbKey(arg)
+			if _, err := ctx.txn.Get(key); err == nil {
+				deleted++
+			}
+			if err := ctx.txn.Delete(key); err != nil {
+				w.writeError("ERR " + err.Error())
+				return
+			}
+		}
+		w.writeInteger(deleted)
+		return
+	}
+
+	// 按照请求中 key 的数量设置 batch 的大小，所有的 key 在一次提交中原子删除
+	opts := gobitcask.DefaultWriteBatchOptions
+	opts.MaxBatchNum = max(opts.MaxBatchNum, uint(len(args)-1))
+	wb := ctx.db.NewWriteBtach(opts)
+	for _, arg := range args[1:] {
+		key := dbKey(arg)
+		if _, err := ctx.db.Get(key); err == nil {
+			deleted++
+		}
+		if err := wb.Delete(key); err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeInteger(deleted)
+}
+
+func exists(ctx *execCtx, w *respWriter, args [][]byte) {
+	var count int64
+	for _, key := range args[1:] {
+		if _, err := ctx.get(dbKey(key)); err == nil {
+			count++
+		}
+	}
+	w.writeInteger(count)
+}
+
+// keys KEYS pattern，使用 glob 风格的匹配
+func keys(ctx *execCtx, w *respWriter, args [][]byte) {
+	pattern := string(args[1])
+	if _, err := path.Match(pattern, ""); err != nil {
+		w.writeError("ERR invalid pattern")
+		return
+	}
+
+	var result [][]byte
+	iter := ctx.db.NewIterator(iteratorOptions(pattern))
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		if key := iter.Key()[len(keyPrefix):]; matchKey(pattern, key) {
+			result = append(result, key)
+		}
+	}
+	w.writeBulkStrings(result)
+}
+
+// scan SCAN cursor [MATCH pattern] [COUNT count]
+// cursor 为上次遍历到的最后一个 key 的十六进制编码，从这个 key 之后继续遍历，遍历结束时返回 0
+func scan(ctx *execCtx, w *respWriter, args [][]byte) {
+	var lastKey []byte
+	if cursor := string(args[1]); cursor != "0" {
+		var err error
+		if lastKey, err = hex.DecodeString(cursor); err != nil || len(lastKey) == 0 {
+			w.writeError("ERR invalid cursor")
+			return
+		}
+	}
+	pattern, count := "*", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.writeError("ERR syntax error")
+			return
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = string(args[i+1])
+			if _, err := path.Match(pattern, ""); err != nil {
+				w.writeError("ERR invalid pattern")
+				return
+			}
+		case "count":
+			var err error
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil || count <= 0 {
+				w.writeError("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			w.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	var result [][]byte
+	iter := ctx.db.NewIterator(iteratorOptions(pattern))
+	defer iter.Close()
+	if lastKey == nil {
+		iter.Rewind()
+	} else {
+		iter.Seek(dbKey(lastKey))
+		if iter.Valid() && bytes.Equal(iter.Key()[len(keyPrefix):], lastKey) {
+			iter.Next()
+		}
+	}
+	for visited := 0; iter.Valid() && visited < count; iter.Next() {
+		visited++
+		lastKey = iter.Key()[len(keyPrefix):]
+		if matchKey(pattern, lastKey) {
+			result = append(result, lastKey)
+		}
+	}
+
+	next := "0"
+	if iter.Valid() {
+		next = hex.EncodeToString(lastKey)
+	}
+	w.writeArrayHeader(2)
+	w.writeBulkString([]byte(next))
+	w.writeBulkStrings(result)
+}
+
+// matchKey 判断 key 是否匹配 glob 风格的 pattern
+func matchKey(pattern string, key []byte) bool {
+	matched, _ := path.Match(pattern, string(key))
+	return matched
+}
+
+// expire EXPIRE key seconds，重新写入数据并设置过期时间
+func expire(ctx *execCtx, w *respWriter, args [][]byte) {
+	seconds, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		w.writeError("ERR value is not an integer or out of range")
+		return
+	}
+
+	key := dbKey(args[1])
+	var exist bool
+	err = ctx.update(func(txn *gobitcask.Txn) error {
+		value, err := txn.Get(key)
+		if err == gobitcask.ErrKeyNotFound {
+			exist = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		exist = true
+		// 过期时间不是正数时直接删除
+		if seconds <= 0 {
+			return txn.Delete(key)
+		}
+		return txn.PutWithTTL(key, value, time.Duration(seconds)*time.Second)
+	})
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	if exist {
+		w.writeInteger(1)
+	} else {
+		w.writeInteger(0)
+	}
+}
+
+// kvWriter DB 和 Txn 共有的写接口
+type kvWriter interface {
+	Put(key, value []byte) error
+	PutWithTTL(key, value []byte, ttl time.Duration) error
+}
+
+// putValue 写入数据，ttl 为 0 表示永不过期
+func putValue(w kvWriter, key, value []byte, ttl time.Duration) error {
+	if ttl > 0 {
+		return w.PutWithTTL(key, value, ttl)
+	}
+	return w.Put(key, value)
+}
+
+// iteratorOptions 只遍历 keyPrefix 下的 key，并根据 glob 模式中不含通配符的前缀缩小遍历范围
+func iteratorOptions(pattern string) gobitcask.IteratorOptions {
+	opts := gobitcask.DefaultIteratorOption
+	if idx := strings.IndexAny(pattern, `*?[\`); idx >= 0 {
+		pattern = pattern[:idx]
+	}
+	opts.Prefix = dbKey([]byte(pattern))
+	return opts
+}
+
+// isMultiCommand 是否是控制事务的命令
+func isMultiCommand(name string) bool {
+	switch name {
+	case "multi", "exec", "discard":
+		return true
+	}
+	return false
+}
+
+// lowerName 返回小写的命令名
+func lowerName(arg []byte) string {
+	return string(bytes.ToLower(arg))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	gobitcask "go-bitcask"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestServer(t *testing.T) *server {
+	opts := gobitcask.DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-redis-server")
+	opts.DirPath = dir
+	db, err := gobitcask.Open(opts)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return newServer(db, nil)
+}
+
+// execute 执行一条命令，返回编码后的响应
+func execute(svr *server, cli *client, args ...string) string {
+	var buf bytes.Buffer
+	writer := newRespWriter(&buf)
+	cmdArgs := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		cmdArgs = append(cmdArgs, []byte(arg))
+	}
+	svr.dispatch(cli, writer, cmdArgs)
+	_ = writer.flush()
+	return buf.String()
+}
+
+// bulkStrings 编码 RESP 数组
+func bulkStrings(items ...string) string {
+	result := fmt.Sprintf("*%d\r\n", len(items))
+	for _, item := range items {
+		result += fmt.Sprintf("$%d\r\n%s\r\n", len(item), item)
+	}
+	return result
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		replies  []string
+	}{
+		{"ping", []string{"PING", "ping hi"}, []string{"+PONG\r\n", "$2\r\nhi\r\n"}},
+		{"echo", []string{"ECHO hello"}, []string{"$5\r\nhello\r\n"}},
+		{"command", []string{"COMMAND DOCS"}, []string{"*0\r\n"}},
+		{"get missing", []string{"GET k"}, []string{"$-1\r\n"}},
+		{"set and get", []string{"SET k v", "GET k"}, []string{"+OK\r\n", "$1\r\nv\r\n"}},
+		{
+			"set nx and xx",
+			[]string{"SET k v1 NX", "SET k v2 NX", "SET k v3 XX", "GET k", "SET other v XX", "EXISTS other"},
+			[]string{"+OK\r\n", "$-1\r\n", "+OK\r\n", "$2\r\nv3\r\n", "$-1\r\n", ":0\r\n"},
+		},
+		{"set with ttl", []string{"SET k v EX 100", "SET k2 v PX 100000", "GET k"}, []string{"+OK\r\n", "+OK\r\n", "$1\r\nv\r\n"}},
+		{
+			"set syntax errors",
+			[]string{"SET k v NX XX", "SET k v EX", "SET k v EX 0", "SET k v PX abc", "SET k v EX 1 PX 1", "SET k v FOO"},
+			[]string{
+				"-ERR syntax error\r\n",
+				"-ERR syntax error\r\n",
+				"-ERR invalid expire time in 'set' command\r\n",
+				"-ERR value is not an integer or out of range\r\n",
+				"-ERR syntax error\r\n",
+				"-ERR syntax error\r\n",
+			},
+		},
+		{
+			"del and exists",
+			[]string{"SET a 1", "SET b 2", "EXISTS a b c a", "DEL a b c", "EXISTS a b"},
+			[]string{"+OK\r\n", "+OK\r\n", ":3\r\n", ":2\r\n", ":0\r\n"},
+		},
+		{
+			"expire",
+			[]string{"SET k v", "EXPIRE k 100", "GET k", "EXPIRE missing 100", "EXPIRE k abc", "EXPIRE k 0", "GET k"},
+			[]string{"+OK\r\n", ":1\r\n", "$1\r\nv\r\n", ":0\r\n", "-ERR value is not an integer or out of range\r\n", ":1\r\n", "$-1\r\n"},
+		},
+		{
+			"keys",
+			[]string{"SET user:1 a", "SET user:2 b", "SET order:1 c", "KEYS user:*", "KEYS *:1", "KEYS user:2", "KEYS ["},
+			[]string{
+				"+OK\r\n", "+OK\r\n", "+OK\r\n",
+				bulkStrings("user:1", "user:2"),
+				bulkStrings("order:1", "user:1"),
+				bulkStrings("user:2"),
+				"-ERR invalid pattern\r\n",
+			},
+		},
+		{"unknown command", []string{"FOO bar"}, []string{"-ERR unknown command 'FOO'\r\n"}},
+		{
+			"wrong number of arguments",
+			[]string{"GET", "SET k", "EXPIRE k"},
+			[]string{
+				"-ERR wrong number of arguments for 'get' command\r\n",
+				"-ERR wrong number of arguments for 'set' command\r\n",
+				"-ERR wrong number of arguments for 'expire' command\r\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := openTestServer(t)
+			cli := &client{}
+			for i, command := range tt.commands {
+				assert.Equal(t, tt.replies[i], execute(svr, cli, strings.Fields(command)...), command)
+			}
+		})
+	}
+}
+
+func TestCommands_Scan(t *testing.T) {
+	svr := openTestServer(t)
+	cli := &client{}
+	var expected []string
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		expected = append(expected, key)
+		assert.Equal(t, "+OK\r\n", execute(svr, cli, "SET", key, "v"))
+	}
+	assert.Equal(t, "+OK\r\n", execute(svr, cli, "SET", "other", "v"))
+
+	// 按照 COUNT 分批遍历，直到返回的 cursor 为 0
+	var keys []string
+	cursor := "0"
+	for i := 0; ; i++ {
+		assert.True(t, i < 10)
+		reply := execute(svr, cli, "SCAN", cursor, "MATCH", "key-*", "COUNT", "10")
+
+		// 响应为 [cursor, [key...]]
+		reader := newRespReader(strings.NewReader(reply))
+		header, err := reader.readLine()
+		assert.Nil(t, err)
+		assert.Equal(t, "*2", string(header))
+		next, err := reader.readBulkString()
+		assert.Nil(t, err)
+		cursor = string(next)
+		items, err := reader.readCommand()
+		assert.Nil(t, err)
+		keys = append(keys, argStrings(items)...)
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, expected, keys)
+
+	assert.Equal(t, "-ERR invalid cursor\r\n", execute(svr, cli, "SCAN", "zz"))
+	assert.Equal(t, "-ERR syntax error\r\n", execute(svr, cli, "SCAN", "0", "COUNT"))
+	assert.Equal(t, "-ERR value is not an integer or out of range\r\n", execute(svr, cli, "SCAN", "0", "COUNT", "0"))
+}
+
+func TestCommands_KeyNamespace(t *testing.T) {
+	svr := openTestServer(t)
+	cli := &client{}
+
+	// 数据目录中不是通过服务端写入的数据不会出现在 KEYS 和 SCAN 中
+	assert.Nil(t, svr.db.Put([]byte("raw"), []byte("v")))
+	assert.Nil(t, svr.db.Put([]byte("\x03raw\x00\x00\x00\x00\x00\x00\x00\x01m"), nil))
+	// 看起来像 redis 包子 key 的普通 key 仍然可见
+	subKeyLike := "\x03key\x00\x00\x00\x00\x00\x00\x00\x01m"
+	assert.Equal(t, "+OK\r\n", execute(svr, cli, "SET", subKeyLike, "v"))
+	assert.Equal(t, "+OK\r\n", execute(svr, cli, "SET", "key", "v"))
+
+	assert.Equal(t, bulkStrings(subKeyLike, "key"), execute(svr, cli, "KEYS", "*"))
+	assert.Equal(t, "*2\r\n$1\r\n0\r\n"+bulkStrings(subKeyLike, "key"), execute(svr, cli, "SCAN", "0"))
+	assert.Equal(t, "$-1\r\n", execute(svr, cli, "GET", "raw"))
+	assert.Equal(t, ":0\r\n", execute(svr, cli, "DEL", "raw"))
+	_, err := svr.db.Get([]byte("raw"))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	gobitcask "go-bitcask"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	addr = flag.String("addr", "127.0.0.1:6380", "the address to listen on")
+	dir  = flag.String("dir", "", "the directory of the database")
+)
+
+func main() {
+	flag.Parse()
+
+	options := gobitcask.DefaultOption
+	if *dir != "" {
+		options.DirPath = *dir
+	} else {
+		dirPath, _ := os.MkdirTemp("", "go-bitcask-redis")
+		options.DirPath = dirPath
+	}
+	db, err := gobitcask.Open(options)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open bitcask: %v", err))
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		_ = db.Close()
+		panic(fmt.Sprintf("failed to listen on %s: %v", *addr, err))
+	}
+	svr := newServer(db, listener)
+
+	// 收到退出信号后关闭服务和数据库
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		if err := svr.close(); err != nil {
+			log.Printf("failed to close server: %v\n", err)
+		}
+	}()
+
+	log.Printf("go-bitcask redis server is listening on %s, data dir: %s\n", *addr, options.DirPath)
+	if err := svr.serve(); err != nil {
+		log.Printf("server stopped: %v\n", err)
+	}
+	if err := db.Close(); err != nil {
+		log.Printf("failed to close bitcask: %v\n", err)
+	}
+}
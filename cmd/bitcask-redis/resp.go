@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errProtocol = errors.New("Protocol error")
+
+// 根据客户端声明的长度预先分配的最大容量，实际的数据读取到多少再分配多少
+const respMaxPrealloc = 4096
+
+// inline 命令以及数组、字符串长度所在行的最大长度，与 Redis 一致
+const respMaxLineSize = 64 * 1024
+
+// respReader 解析客户端发送的 RESP2 请求
+type respReader struct {
+	r *bufio.Reader
+}
+
+func newRespReader(r io.Reader) *respReader {
+	return &respReader{r: bufio.NewReader(r)}
+}
+
+// readCommand 读取一条命令，支持 RESP 数组格式和 inline 格式
+func (rr *respReader) readCommand() ([][]byte, error) {
+	line, err := rr.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	// inline 命令，例如通过 telnet 发送的 PING
+	if line[0] != '*' {
+		var args [][]byte
+		for _, field := range strings.Fields(string(line)) {
+			args = append(args, []byte(field))
+		}
+		return args, nil
+	}
+
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count < 0 || count > 1024*1024 {
+		return nil, errProtocol
+	}
+	args := make([][]byte, 0, min(count, respMaxPrealloc))
+	for i := 0; i < count; i++ {
+		arg, err := rr.readBulkString()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulkString 读取 $<len>\r\n<data>\r\n 格式的数据
+func (rr *respReader) readBulkString() ([]byte, error) {
+	line, err := rr.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, errProtocol
+	}
+	size, err := strconv.Atoi(string(line[1:]))
+	if err != nil || size < 0 || size > 512*1024*1024 {
+		return nil, errProtocol
+	}
+
+	// 分段读取，避免客户端声明很大的长度却不发送数据时占用过多内存
+	var buf bytes.Buffer
+	buf.Grow(min(size, respMaxPrealloc))
+	if _, err := io.CopyN(&buf, rr.r, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	var crlf [2]byte
+	if _, err := io.ReadFull(rr.r, crlf[:]); err != nil {
+		return nil, err
+	}
+	if crlf[0] != '\r' || crlf[1] != '\n' {
+		return nil, errProtocol
+	}
+	return buf.Bytes(), nil
+}
+
+// readLine 读取以 \r\n 结尾的一行，返回的数据不包含换行符
+// 超过 respMaxLineSize 还没有读到换行符时返回 errProtocol，避免客户端占用过多内存
+func (rr *respReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		frag, err := rr.r.ReadSlice('\n')
+		if len(line)+len(frag) > respMaxLineSize {
+			return nil, errProtocol
+		}
+		line = append(line, frag...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// respWriter 按照 RESP2 协议编码响应
+type respWriter struct {
+	w *bufio.Writer
+}
+
+func newRespWriter(w io.Writer) *respWriter {
+	return &respWriter{w: bufio.NewWriter(w)}
+}
+
+func (rw *respWriter) writeSimpleString(s string) {
+	rw.w.WriteString("+" + s + "\r\n")
+}
+
+func (rw *respWriter) writeError(msg string) {
+	rw.w.WriteString("-" + msg + "\r\n")
+}
+
+func (rw *respWriter) writeInteger(n int64) {
+	rw.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (rw *respWriter) writeBulkString(b []byte) {
+	rw.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	rw.w.Write(b)
+	rw.w.WriteString("\r\n")
+}
+
+func (rw *respWriter) writeNull() {
+	rw.w.WriteString("$-1\r\n")
+}
+
+func (rw *respWriter) writeNullArray() {
+	rw.w.WriteString("*-1\r\n")
+}
+
+func (rw *respWriter) writeArrayHeader(n int) {
+	rw.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func (rw *respWriter) writeBulkStrings(items [][]byte) {
+	rw.writeArrayHeader(len(items))
+	for _, item := range items {
+		rw.writeBulkString(item)
+	}
+}
+
+// writeRaw 写入已经编码好的响应
+func (rw *respWriter) writeRaw(b []byte) {
+	rw.w.Write(b)
+}
+
+func (rw *respWriter) flush() error {
+	return rw.w.Flush()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// argStrings 将命令参数转换为字符串，便于比较
+func argStrings(args [][]byte) []string {
+	if args == nil {
+		return nil
+	}
+	result := make([]string, 0, len(args))
+	for _, arg := range args {
+		result = append(result, string(arg))
+	}
+	return result
+}
+
+func TestRespReader_ReadCommand(t *testing.T) {
+	bigValue := strings.Repeat("v", 100*1024)
+	tests := []struct {
+		name  string
+		input string
+		args  []string
+		err   error
+	}{
+		{"array", "*2\r\n$4\r\nECHO\r\n$5\r\nhello\r\n", []string{"ECHO", "hello"}, nil},
+		{"empty bulk string", "*2\r\n$3\r\nGET\r\n$0\r\n\r\n", []string{"GET", ""}, nil},
+		{"bulk string larger than the line limit", "*2\r\n$3\r\nSET\r\n$102400\r\n" + bigValue + "\r\n", []string{"SET", bigValue}, nil},
+		{"empty array", "*0\r\n", []string{}, nil},
+		{"inline", "PING hello\r\n", []string{"PING", "hello"}, nil},
+		{"inline without cr", "PING\n", []string{"PING"}, nil},
+		{"inline at the line limit", strings.Repeat("a", respMaxLineSize-2) + "\r\n", []string{strings.Repeat("a", respMaxLineSize-2)}, nil},
+		{"empty line", "\r\n", nil, nil},
+		{"eof", "", nil, io.EOF},
+		{"negative array length", "*-1\r\n", nil, errProtocol},
+		{"invalid array length", "*abc\r\n", nil, errProtocol},
+		{"missing bulk string header", "*1\r\n+OK\r\n", nil, errProtocol},
+		{"negative bulk string length", "*1\r\n$-1\r\n", nil, errProtocol},
+		{"bulk string without crlf", "*1\r\n$3\r\nGETX\r\n", nil, errProtocol},
+		{"truncated bulk string", "*1\r\n$10\r\nabc", nil, io.ErrUnexpectedEOF},
+		{"inline over the line limit", strings.Repeat("a", respMaxLineSize+1), nil, errProtocol},
+		{"length line over the line limit", "*1\r\n$" + strings.Repeat("1", respMaxLineSize) + "\r\n", nil, errProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newRespReader(strings.NewReader(tt.input))
+			args, err := reader.readCommand()
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.args, argStrings(args))
+		})
+	}
+}
+
+func TestRespReader_Pipeline(t *testing.T) {
+	reader := newRespReader(strings.NewReader("*1\r\n$4\r\nPING\r\nECHO hi\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"))
+	for _, expected := range [][]string{{"PING"}, {"ECHO", "hi"}, {"GET", "k"}} {
+		args, err := reader.readCommand()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, argStrings(args))
+	}
+	_, err := reader.readCommand()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRespWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer := newRespWriter(&buf)
+	writer.writeSimpleString("OK")
+	writer.writeError("ERR oops")
+	writer.writeInteger(-3)
+	writer.writeBulkString([]byte("hello"))
+	writer.writeNull()
+	writer.writeNullArray()
+	writer.writeBulkStrings([][]byte{[]byte("a"), {}})
+	assert.Nil(t, writer.flush())
+	assert.Equal(t, "+OK\r\n-ERR oops\r\n:-3\r\n$5\r\nhello\r\n$-1\r\n*-1\r\n*2\r\n$1\r\na\r\n$0\r\n\r\n", buf.String())
+}
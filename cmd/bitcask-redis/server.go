@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	gobitcask "go-bitcask"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// server 兼容 Redis RESP2 协议的服务端
+type server struct {
+	db       *gobitcask.DB
+	listener net.Listener
+	mu       *sync.Mutex
+	conns    map[net.Conn]struct{}
+	wg       *sync.WaitGroup
+	closed   bool
+}
+
+func newServer(db *gobitcask.DB, listener net.Listener) *server {
+	return &server{
+		db:       db,
+		listener: listener,
+		mu:       new(sync.Mutex),
+		conns:    make(map[net.Conn]struct{}),
+		wg:       new(sync.WaitGroup),
+	}
+}
+
+// serve 接收客户端连接，每个连接使用单独的 goroutine 处理
+func (svr *server) serve() error {
+	for {
+		conn, err := svr.listener.Accept()
+		if err != nil {
+			svr.mu.Lock()
+			closed := svr.closed
+			svr.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		svr.mu.Lock()
+		svr.conns[conn] = struct{}{}
+		svr.mu.Unlock()
+
+		svr.wg.Add(1)
+		go func() {
+			defer svr.wg.Done()
+			svr.handleConn(conn)
+		}()
+	}
+}
+
+// close 停止接收新连接，关闭已有连接并等待处理结束
+func (svr *server) close() error {
+	svr.mu.Lock()
+	svr.closed = true
+	err := svr.listener.Close()
+	for conn := range svr.conns {
+		_ = conn.Close()
+	}
+	svr.mu.Unlock()
+
+	svr.wg.Wait()
+	return err
+}
+
+// client 客户端连接的状态
+type client struct {
+	inMulti bool       // 是否处于 MULTI 中
+	dirty   bool       // MULTI 中是否有命令入队失败
+	queued  [][][]byte // MULTI 中排队的命令
+}
+
+func (svr *server) handleConn(conn net.Conn) {
+	defer func() {
+		svr.mu.Lock()
+		delete(svr.conns, conn)
+		svr.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	reader := newRespReader(conn)
+	writer := newRespWriter(conn)
+	cli := &client{}
+	for {
+		args, err := reader.readCommand()
+		if err != nil {
+			if err == errProtocol {
+				writer.writeError("ERR " + err.Error())
+				_ = writer.flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("failed to read command: %v\n", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if lowerName(args[0]) == "quit" {
+			writer.writeSimpleString("OK")
+			_ = writer.flush()
+			return
+		}
+		svr.dispatch(cli, writer, args)
+		if err := writer.flush(); err != nil {
+			return
+		}
+	}
+}
+
+// dispatch 执行一条命令，MULTI 中的命令会先排队，EXEC 时在同一个事务中执行
+func (svr *server) dispatch(cli *client, w *respWriter, args [][]byte) {
+	name := lowerName(args[0])
+	switch name {
+	case "multi":
+		if cli.inMulti {
+			w.writeError("ERR MULTI calls can not be nested")
+			return
+		}
+		cli.inMulti, cli.dirty, cli.queued = true, false, nil
+		w.writeSimpleString("OK")
+		return
+	case "discard":
+		if !cli.inMulti {
+			w.writeError("ERR DISCARD without MULTI")
+			return
+		}
+		cli.inMulti, cli.queued = false, nil
+		w.writeSimpleString("OK")
+		return
+	case "exec":
+		if !cli.inMulti {
+			w.writeError("ERR EXEC without MULTI")
+			return
+		}
+		queued, dirty := cli.queued, cli.dirty
+		cli.inMulti, cli.queued = false, nil
+		if dirty {
+			w.writeError("EXECABORT Transaction discarded because of previous errors.")
+			return
+		}
+		svr.exec(w, queued)
+		return
+	}
+
+	spec, ok := supportedCommands[name]
+	if !ok {
+		cli.dirty = cli.inMulti
+		w.writeError("ERR unknown command '" + string(args[0]) + "'")
+		return
+	}
+	if !spec.checkArity(args) {
+		cli.dirty = cli.inMulti
+		w.writeError(wrongArgsError(name))
+		return
+	}
+
+	if cli.inMulti {
+		cli.queued = append(cli.queued, args)
+		w.writeSimpleString("QUEUED")
+		return
+	}
+	spec.handler(&execCtx{db: svr.db}, w, args)
+}
+
+// exec 在同一个事务中依次执行排队的命令，全部成功后原子提交
+func (svr *server) exec(w *respWriter, queued [][][]byte) {
+	txn := svr.db.Begin()
+	ctx := &execCtx{db: svr.db, txn: txn}
+
+	// 先将结果写到缓冲区，事务提交成功之后再返回给客户端
+	var buf bytes.Buffer
+	bufWriter := newRespWriter(&buf)
+	for _, args := range queued {
+		supportedCommands[lowerName(args[0])].handler(ctx, bufWriter, args)
+	}
+	_ = bufWriter.flush()
+
+	if err := txn.Commit(); err != nil {
+		// 与 Redis 中 WATCH 的 key 被修改时一样，返回 nil 数组，客户端可以重试
+		if err == gobitcask.ErrTxnConflict {
+			w.writeNullArray()
+			return
+		}
+		w.writeError("ERR " + strings.TrimSpace(err.Error()))
+		return
+	}
+	w.writeArrayHeader(len(queued))
+	w.writeRaw(buf.Bytes())
+}
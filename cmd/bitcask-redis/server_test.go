@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_MultiExec(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		replies  []string
+	}{
+		{
+			"queue commands",
+			[]string{"MULTI", "SET k v", "GET k", "INCR_MISSING", "DEL", "SET k v2 NX"},
+			[]string{"+OK\r\n", "+QUEUED\r\n", "+QUEUED\r\n", "-ERR unknown command 'INCR_MISSING'\r\n", "-ERR wrong number of arguments for 'del' command\r\n", "+QUEUED\r\n"},
+		},
+		{
+			"exec returns every reply",
+			[]string{"SET a 1", "MULTI", "GET a", "SET a 2", "GET a", "DEL a", "EXISTS a", "EXEC", "GET a"},
+			[]string{
+				"+OK\r\n", "+OK\r\n", "+QUEUED\r\n", "+QUEUED\r\n", "+QUEUED\r\n", "+QUEUED\r\n", "+QUEUED\r\n",
+				"*5\r\n$1\r\n1\r\n+OK\r\n$1\r\n2\r\n:1\r\n:0\r\n",
+				"$-1\r\n",
+			},
+		},
+		{"empty exec", []string{"MULTI", "EXEC"}, []string{"+OK\r\n", "*0\r\n"}},
+		{
+			"queued command errors abort exec",
+			[]string{"MULTI", "SET k v", "GET", "EXEC", "GET k"},
+			[]string{"+OK\r\n", "+QUEUED\r\n", "-ERR wrong number of arguments for 'get' command\r\n", "-EXECABORT Transaction discarded because of previous errors.\r\n", "$-1\r\n"},
+		},
+		{
+			"discard",
+			[]string{"MULTI", "SET k v", "DISCARD", "GET k", "DISCARD"},
+			[]string{"+OK\r\n", "+QUEUED\r\n", "+OK\r\n", "$-1\r\n", "-ERR DISCARD without MULTI\r\n"},
+		},
+		{"exec without multi", []string{"EXEC"}, []string{"-ERR EXEC without MULTI\r\n"}},
+		{"nested multi", []string{"MULTI", "MULTI", "EXEC"}, []string{"+OK\r\n", "-ERR MULTI calls can not be nested\r\n", "*0\r\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := openTestServer(t)
+			cli := &client{}
+			for i, command := range tt.commands {
+				assert.Equal(t, tt.replies[i], execute(svr, cli, strings.Fields(command)...), command)
+			}
+		})
+	}
+}
+
+func TestServer_ExecConflict(t *testing.T) {
+	svr := openTestServer(t)
+
+	// 模拟事务执行期间其他客户端修改了事务读取过的 key
+	supportedCommands["conflict"] = cmdSpec{func(ctx *execCtx, w *respWriter, args [][]byte) {
+		_ = ctx.db.Put(dbKey(args[1]), []byte("other"))
+		w.writeSimpleString("OK")
+	}, 2}
+	defer delete(supportedCommands, "conflict")
+
+	cli := &client{}
+	assert.Equal(t, "+OK\r\n", execute(svr, cli, "SET", "k", "v"))
+	assert.Equal(t, "+OK\r\n", execute(svr, cli, "MULTI"))
+	assert.Equal(t, "+QUEUED\r\n", execute(svr, cli, "GET", "k"))
+	assert.Equal(t, "+QUEUED\r\n", execute(svr, cli, "SET", "k", "mine"))
+	assert.Equal(t, "+QUEUED\r\n", execute(svr, cli, "CONFLICT", "k"))
+	assert.Equal(t, "*-1\r\n", execute(svr, cli, "EXEC"))
+
+	// 事务中的写入没有生效，客户端不再处于 MULTI 中
+	assert.Equal(t, "$5\r\nother\r\n", execute(svr, cli, "GET", "k"))
+}
+
+func TestServer_Serve(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	svr := openTestServer(t)
+	svr.listener = listener
+	done := make(chan error, 1)
+	go func() { done <- svr.serve() }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	readReply := func(n int) string {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(reader, buf)
+		assert.Nil(t, err)
+		return string(buf)
+	}
+
+	_, err = conn.Write([]byte("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\nGET k\r\n\r\nQUIT\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "+OK\r\n$1\r\nv\r\n+OK\r\n", readReply(len("+OK\r\n$1\r\nv\r\n+OK\r\n")))
+	_, err = reader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// 协议错误时返回错误并断开连接
+	conn2, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn2.Close()
+	_, err = conn2.Write([]byte("*-1\r\n"))
+	assert.Nil(t, err)
+	data, err := io.ReadAll(conn2)
+	assert.Nil(t, err)
+	assert.Equal(t, "-ERR Protocol error\r\n", string(data))
+
+	assert.Nil(t, svr.close())
+	assert.Nil(t, <-done)
+}
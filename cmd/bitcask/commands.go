@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	gobitcask "go-bitcask"
+	"time"
+)
+
+// parseArgs 解析子命令的参数，并检查剩余参数的数量
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	rest := fs.Args()
+	if len(rest) < min || max >= 0 && len(rest) > max {
+		return nil, fmt.Errorf("wrong number of arguments")
+	}
+	return rest, nil
+}
+
+func (c *cli) get(args []string) error {
+	rest, err := parseArgs(flag.NewFlagSet("get", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	key, err := c.input.decode(rest[0])
+	if err != nil {
+		return err
+	}
+	value, err := c.db.Get(key)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, c.output.encode(value))
+	return nil
+}
+
+func (c *cli) put(args []string) error {
+	fs := flag.NewFlagSet("put", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", 0, "expire the key after the duration, 0 means never")
+	rest, err := parseArgs(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	key, err := c.input.decode(rest[0])
+	if err != nil {
+		return err
+	}
+	value, err := c.input.decode(rest[1])
+	if err != nil {
+		return err
+	}
+	if *ttl > 0 {
+		return c.db.PutWithTTL(key, value, *ttl)
+	}
+	return c.db.Put(key, value)
+}
+
+func (c *cli) delete(args []string) error {
+	rest, err := parseArgs(flag.NewFlagSet("delete", flag.ContinueOnError), args, 1, -1)
+	if err != nil {
+		return err
+	}
+	for _, arg := range rest {
+		key, err := c.input.decode(arg)
+		if err != nil {
+			return err
+		}
+		if err := c.db.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanOptions 遍历 key 的范围，start 包含在内，end 不包含在内
+type scanOptions struct {
+	prefix  []byte
+	start   []byte
+	end     []byte
+	reverse bool
+	limit   int
+}
+
+// each 按照 scanOptions 遍历数据，fn 返回 false 时停止遍历
+func (c *cli) each(opts *scanOptions, withValue bool, fn func(key, value []byte) bool) error {
+	iterOpts := gobitcask.DefaultIteratorOption
+	iterOpts.Prefix = opts.prefix
+	iterOpts.Reverse = opts.reverse
+	iter := c.db.NewIterator(iterOpts)
+	defer iter.Close()
+
+	// 正向遍历从 start 开始，反向遍历从 end 开始
+	iter.Rewind()
+	if !opts.reverse && len(opts.start) > 0 {
+		iter.Seek(opts.start)
+	}
+	if opts.reverse && len(opts.end) > 0 {
+		iter.Seek(opts.end)
+	}
+
+	var n int
+	for ; iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if len(opts.end) > 0 && bytes.Compare(key, opts.end) >= 0 {
+			if opts.reverse {
+				continue
+			}
+			break
+		}
+		if len(opts.start) > 0 && bytes.Compare(key, opts.start) < 0 {
+			if opts.reverse {
+				break
+			}
+			continue
+		}
+		if opts.limit > 0 && n >= opts.limit {
+			break
+		}
+		var value []byte
+		if withValue {
+			var err error
+			if value, err = iter.Value(); err != nil {
+				// 遍历期间过期或被删除的 key 直接跳过
+				if errors.Is(err, gobitcask.ErrKeyNotFound) {
+					continue
+				}
+				return err
+			}
+		}
+		n++
+		if !fn(key, value) {
+			break
+		}
+	}
+	return nil
+}
+
+// parseScanArgs 注册并解析 scan 和 count 共用的参数
+func (c *cli) parseScanArgs(fs *flag.FlagSet, args []string) (*scanOptions, error) {
+	prefix := fs.String("prefix", "", "only keys with the prefix")
+	start := fs.String("start", "", "the first key of the range, inclusive")
+	end := fs.String("end", "", "the last key of the range, exclusive")
+	reverse := fs.Bool("reverse", false, "iterate in descending order")
+	limit := fs.Int("limit", 0, "the max number of keys, 0 means no limit")
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return nil, err
+	}
+
+	opts := &scanOptions{reverse: *reverse, limit: *limit}
+	var err error
+	if opts.prefix, err = c.input.decode(*prefix); err != nil {
+		return nil, err
+	}
+	if opts.start, err = c.input.decode(*start); err != nil {
+		return nil, err
+	}
+	if opts.end, err = c.input.decode(*end); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+func (c *cli) scan(args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	keysOnly := fs.Bool("keys-only", false, "only print keys")
+	opts, err := c.parseScanArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	return c.each(opts, !*keysOnly, func(key, value []byte) bool {
+		if *keysOnly {
+			fmt.Fprintln(c.out, c.output.encode(key))
+		} else {
+			fmt.Fprintf(c.out, "%s\t%s\n", c.output.encode(key), c.output.encode(value))
+		}
+		return true
+	})
+}
+
+func (c *cli) count(args []string) error {
+	opts, err := c.parseScanArgs(flag.NewFlagSet("count", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	var n int
+	err = c.each(opts, false, func(_, _ []byte) bool {
+		n++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, n)
+	return nil
+}
+
+func (c *cli) stat(args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("stat", flag.ContinueOnError), args, 0, 0); err != nil {
+		return err
+	}
+	stat, err := c.db.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "keys:             %d\n", stat.KeyNum)
+	fmt.Fprintf(c.out, "data files:       %d\n", stat.DataFileNum)
+	fmt.Fprintf(c.out, "active file id:   %d\n", stat.ActiveFileId)
+	fmt.Fprintf(c.out, "disk size:        %d\n", stat.DiskSize)
+	fmt.Fprintf(c.out, "reclaimable size: %d\n", stat.ReclaimableSize)
+	fmt.Fprintf(c.out, "value log files:  %d\n", stat.ValueLogFileNum)
+	return nil
+}
+
+func (c *cli) merge(args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("merge", flag.ContinueOnError), args, 0, 0); err != nil {
+		return err
+	}
+	begin := time.Now()
+	if err := c.db.Merge(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "merged in %v\n", time.Since(begin))
+	return nil
+}
+
+func (c *cli) gc(args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("gc", flag.ContinueOnError), args, 0, 0); err != nil {
+		return err
+	}
+	begin := time.Now()
+	if err := c.db.ValueLogGC(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "value log collected in %v\n", time.Since(begin))
+	return nil
+}
+
+func (c *cli) backup(args []string) error {
+	rest, err := parseArgs(flag.NewFlagSet("backup", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	return c.db.Backup(rest[0])
+}
+
+func (c *cli) dump(args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("dump", flag.ContinueOnError), args, 0, 0); err != nil {
+		return err
+	}
+	return c.db.Fold(func(key, value []byte) bool {
+		fmt.Fprintf(c.out, "%s\t%s\n", c.output.encode(key), c.output.encode(value))
+		return true
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	gobitcask "go-bitcask"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// openTestCli 打开一个包含测试数据的数据库
+func openTestCli(t *testing.T) *cli {
+	opts := gobitcask.DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-cli")
+	opts.DirPath = dir
+	db, err := gobitcask.Open(opts)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	})
+	for _, key := range []string{"a", "b", "c", "d", "e", "user:1", "user:2"} {
+		assert.Nil(t, db.Put([]byte(key), []byte("v-"+key)))
+	}
+	return &cli{db: db, output: codecRaw, input: codecRaw}
+}
+
+// run 执行一个子命令，返回命令的输出
+func run(c *cli, name string, args ...string) (string, error) {
+	var buf bytes.Buffer
+	c.out = &buf
+	err := commands[name].run(c, args)
+	return buf.String(), err
+}
+
+// lines 将多行的输出拼接起来，便于比较
+func lines(items ...string) string {
+	if len(items) == 0 {
+		return ""
+	}
+	return strings.Join(items, "\n") + "\n"
+}
+
+func TestCli_Scan(t *testing.T) {
+	c := openTestCli(t)
+	tests := []struct {
+		name   string
+		args   []string
+		output string
+	}{
+		{"all", []string{"-keys-only"}, lines("a", "b", "c", "d", "e", "user:1", "user:2")},
+		{"with values", []string{"-end", "c"}, lines("a\tv-a", "b\tv-b")},
+		{"range", []string{"-start", "b", "-end", "e", "-keys-only"}, lines("b", "c", "d")},
+		{"range reverse", []string{"-start", "b", "-end", "e", "-reverse", "-keys-only"}, lines("d", "c", "b")},
+		{"start not a key", []string{"-start", "bb", "-end", "d", "-keys-only"}, lines("c")},
+		{"end after the last key reverse", []string{"-start", "e", "-end", "z", "-reverse", "-keys-only"}, lines("user:2", "user:1", "e")},
+		{"prefix", []string{"-prefix", "user:", "-keys-only"}, lines("user:1", "user:2")},
+		{"prefix reverse", []string{"-prefix", "user:", "-reverse", "-keys-only"}, lines("user:2", "user:1")},
+		{"prefix and range", []string{"-prefix", "user:", "-start", "user:2", "-keys-only"}, lines("user:2")},
+		{"limit", []string{"-limit", "2", "-keys-only"}, lines("a", "b")},
+		{"limit reverse", []string{"-reverse", "-limit", "2", "-keys-only"}, lines("user:2", "user:1")},
+		{"empty range", []string{"-start", "c", "-end", "c", "-keys-only"}, lines()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := run(c, "scan", tt.args...)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.output, output)
+		})
+	}
+}
+
+func TestCli_Count(t *testing.T) {
+	c := openTestCli(t)
+	output, err := run(c, "count")
+	assert.Nil(t, err)
+	assert.Equal(t, "7\n", output)
+	output, err = run(c, "count", "-prefix", "user:")
+	assert.Nil(t, err)
+	assert.Equal(t, "2\n", output)
+	output, err = run(c, "count", "-start", "c", "-reverse")
+	assert.Nil(t, err)
+	assert.Equal(t, "5\n", output)
+}
+
+func TestCli_InvalidArgs(t *testing.T) {
+	c := openTestCli(t)
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"get", nil},
+		{"get", []string{"a", "b"}},
+		{"put", []string{"a"}},
+		{"put", []string{"-ttl", "abc", "a", "b"}},
+		{"delete", nil},
+		{"scan", []string{"a"}},
+		{"scan", []string{"-limit", "abc"}},
+		{"scan", []string{"-unknown"}},
+		{"count", []string{"-keys-only"}},
+		{"backup", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" "+strings.Join(tt.args, " "), func(t *testing.T) {
+			_, err := run(c, tt.name, tt.args...)
+			assert.NotNil(t, err)
+		})
+	}
+
+	// 按照 -input 解析参数失败
+	c.input = codecHex
+	_, err := run(c, "scan", "-prefix", "zz")
+	assert.NotNil(t, err)
+	_, err = run(c, "get", "a")
+	assert.NotNil(t, err)
+}
+
+func TestCli_Format(t *testing.T) {
+	c := openTestCli(t)
+
+	// 按照 -input 解析参数，按照 -format 输出
+	c.input = codecHex
+	_, err := run(c, "put", "00ff", "0102")
+	assert.Nil(t, err)
+	c.output = codecHex
+	output, err := run(c, "get", "00ff")
+	assert.Nil(t, err)
+	assert.Equal(t, "0102\n", output)
+	output, err = run(c, "scan", "-prefix", "00")
+	assert.Nil(t, err)
+	assert.Equal(t, "00ff\t0102\n", output)
+
+	c.input = codecBase64
+	c.output = codecBase64
+	output, err = run(c, "get", "AP8=")
+	assert.Nil(t, err)
+	assert.Equal(t, "AQI=\n", output)
+	output, err = run(c, "scan", "-prefix", "dXNlcjox", "-keys-only")
+	assert.Nil(t, err)
+	assert.Equal(t, "dXNlcjox\n", output)
+
+	c.input = codecRaw
+	c.output = codecRaw
+	output, err = run(c, "get", "user:1")
+	assert.Nil(t, err)
+	assert.Equal(t, "v-user:1\n", output)
+	output, err = run(c, "dump")
+	assert.Nil(t, err)
+	assert.Equal(t, 8, strings.Count(output, "\n"))
+	assert.Contains(t, output, "user:2\tv-user:2\n")
+
+	_, err = run(c, "get", "missing")
+	assert.Equal(t, gobitcask.ErrKeyNotFound, err)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// codec 命令行中 key 和 value 的编码方式
+type codec string
+
+const (
+	codecRaw    codec = "raw"
+	codecHex    codec = "hex"
+	codecBase64 codec = "base64"
+)
+
+func parseCodec(name string) (codec, error) {
+	switch c := codec(name); c {
+	case codecRaw, codecHex, codecBase64:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, must be one of raw, hex, base64", name)
+	}
+}
+
+// encode 将二进制数据编码后输出
+func (c codec) encode(b []byte) string {
+	switch c {
+	case codecHex:
+		return hex.EncodeToString(b)
+	case codecBase64:
+		return base64.StdEncoding.EncodeToString(b)
+	default:
+		return string(b)
+	}
+}
+
+// decode 解析命令行参数中编码过的数据
+func (c codec) decode(s string) ([]byte, error) {
+	switch c {
+	case codecHex:
+		return hex.DecodeString(s)
+	case codecBase64:
+		return base64.StdEncoding.DecodeString(s)
+	default:
+		return []byte(s), nil
+	}
+}
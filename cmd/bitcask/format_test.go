@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCodec(t *testing.T) {
+	for _, name := range []string{"raw", "hex", "base64"} {
+		c, err := parseCodec(name)
+		assert.Nil(t, err)
+		assert.Equal(t, codec(name), c)
+	}
+	_, err := parseCodec("HEX")
+	assert.NotNil(t, err)
+	_, err = parseCodec("")
+	assert.NotNil(t, err)
+}
+
+func TestCodec(t *testing.T) {
+	tests := []struct {
+		codec   codec
+		data    []byte
+		encoded string
+	}{
+		{codecRaw, []byte("key-1"), "key-1"},
+		{codecRaw, []byte{}, ""},
+		{codecHex, []byte{0x00, 0x01, 0xab, 0xff}, "0001abff"},
+		{codecHex, []byte("key"), "6b6579"},
+		{codecBase64, []byte{0x00, 0x01, 0xab, 0xff}, "AAGr/w=="},
+		{codecBase64, []byte("key"), "a2V5"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.codec)+"/"+tt.encoded, func(t *testing.T) {
+			assert.Equal(t, tt.encoded, tt.codec.encode(tt.data))
+			decoded, err := tt.codec.decode(tt.encoded)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.data, decoded)
+		})
+	}
+
+	_, err := codecHex.decode("zz")
+	assert.NotNil(t, err)
+	_, err = codecBase64.decode("a2V5!")
+	assert.NotNil(t, err)
+}
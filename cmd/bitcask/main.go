@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	gobitcask "go-bitcask"
+	"io"
+	"os"
+	"sort"
+)
+
+var (
+	dir    = flag.String("dir", "", "the directory of the database")
+	format = flag.String("format", "raw", "output format of keys and values: raw, hex or base64")
+	input  = flag.String("input", "raw", "input format of keys and values in arguments: raw, hex or base64")
+	key    = flag.String("key", "", "the hex encoded encryption key of the database")
+)
+
+// cli 一次命令行调用的上下文
+type cli struct {
+	db     *gobitcask.DB
+	output codec
+	input  codec
+	out    io.Writer // 命令的输出
+}
+
+// command 子命令，args 为子命令之后的参数
+type command struct {
+	usage string
+	run   func(c *cli, args []string) error
+}
+
+var commands = map[string]*command{
+	"get":    {usage: "get <key>", run: (*cli).get},
+	"put":    {usage: "put [-ttl duration] <key> <value>", run: (*cli).put},
+	"delete": {usage: "delete <key>...", run: (*cli).delete},
+	"scan":   {usage: "scan [-prefix p] [-start k] [-end k] [-reverse] [-limit n] [-keys-only]", run: (*cli).scan},
+	"count":  {usage: "count [-prefix p]", run: (*cli).count},
+	"stat":   {usage: "stat", run: (*cli).stat},
+	"merge":  {usage: "merge", run: (*cli).merge},
+	"gc":     {usage: "gc", run: (*cli).gc},
+	"backup": {usage: "backup <dir>", run: (*cli).backup},
+	"dump":   {usage: "dump", run: (*cli).dump},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s -dir <dir> [-format raw|hex|base64] [-input raw|hex|base64] [-key hex] <command> [args]\n\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Commands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *dir == "" || flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd := commands[flag.Arg(0)]
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	output, err := parseCodec(*format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	inputCodec, err := parseCodec(*input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// 只操作已经存在的数据目录，避免因为路径写错而创建新的数据库
+	if _, err := os.Stat(*dir); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", *dir, err)
+		os.Exit(2)
+	}
+	options := gobitcask.DefaultOption
+	options.DirPath = *dir
+	if options.EncryptionKey, err = hex.DecodeString(*key); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid encryption key: %v\n", err)
+		os.Exit(2)
+	}
+	db, err := gobitcask.Open(options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open %s: %v\n", *dir, err)
+		os.Exit(2)
+	}
+
+	c := &cli{db: db, output: output, input: inputCodec, out: os.Stdout}
+	err = cmd.run(c, flag.Args()[1:])
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,120 @@
+package gobitcask
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"go-bitcask/data"
+	"io"
+)
+
+// Compressor 压缩 value 的接口，可以通过 Options.Compressor 使用自定义的实现
+type Compressor interface {
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+// flateCompressor 使用 DEFLATE 算法压缩
+type flateCompressor struct{}
+
+func (flateCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCompressor) Decompress(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// gzipCompressor 使用 gzip 格式压缩
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// getCompressor 获取压缩方式对应的实现
+func (db *DB) getCompressor(compression CompressionType) (Compressor, error) {
+	switch compression {
+	case FlateCompression:
+		return flateCompressor{}, nil
+	case GzipCompression:
+		return gzipCompressor{}, nil
+	case CustomCompression:
+		if db.options.Compressor != nil {
+			return db.options.Compressor, nil
+		}
+	}
+	return nil, ErrUnknownCompression
+}
+
+// compressLogRecord 按照配置的压缩方式压缩记录的 value，压缩之后没有变小时保持原样
+func (db *DB) compressLogRecord(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	if db.options.Compression == NoCompression || logRecord.Compression != NoCompression ||
+		logRecord.Type != data.LogRecordNormal && logRecord.Type != data.LogRecordChunk ||
+		logRecord.ValuePointer || len(logRecord.Value) == 0 {
+		return logRecord, nil
+	}
+	compressor, err := db.getCompressor(db.options.Compression)
+	if err != nil {
+		return nil, err
+	}
+	value, err := compressor.Compress(logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) >= len(logRecord.Value) {
+		return logRecord, nil
+	}
+	compressed := *logRecord
+	compressed.Value = value
+	compressed.Compression = db.options.Compression
+	return &compressed, nil
+}
+
+// decompressLogRecord 解压记录的 value
+func (db *DB) decompressLogRecord(logRecord *data.LogRecord) error {
+	if logRecord.Compression == NoCompression {
+		return nil
+	}
+	compressor, err := db.getCompressor(logRecord.Compression)
+	if err != nil {
+		return err
+	}
+	value, err := compressor.Decompress(logRecord.Value)
+	if err != nil {
+		return err
+	}
+	logRecord.Value = value
+	logRecord.Compression = NoCompression
+	return nil
+}
@@ -0,0 +1,124 @@
+package gobitcask
+
+import (
+	"bytes"
+	"go-bitcask/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// repeatCompressor 用于测试的自定义压缩实现，只保留重复 value 的一份
+type repeatCompressor struct{}
+
+func (repeatCompressor) Compress(src []byte) ([]byte, error) {
+	return src[:len(src)/2], nil
+}
+
+func (repeatCompressor) Decompress(src []byte) ([]byte, error) {
+	return bytes.Repeat(src, 2), nil
+}
+
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := bytes.Repeat([]byte("bitcask"), 100)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	assert.Nil(t, db.Close())
+
+	// 使用不同的压缩方式写入，同一个文件中的数据都可以读取
+	for _, compression := range []CompressionType{FlateCompression, GzipCompression} {
+		opts.Compression = compression
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		for i := 100; i < 200; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		}
+		assert.Nil(t, db.Close())
+	}
+	// 不能压缩的 value 保持原样
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(utils.GetTestKey(200), []byte("a")))
+
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	val, err := db.Get(utils.GetTestKey(200))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+}
+
+func TestDB_Compression_Custom(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression-custom")
+	opts.DirPath = dir
+	opts.Compression = CustomCompression
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+
+	opts.Compressor = repeatCompressor{}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	value := bytes.Repeat([]byte("ab"), 10)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), value))
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	assert.Nil(t, db.Close())
+
+	// 没有设置自定义压缩实现时无法读取
+	opts.Compression = NoCompression
+	opts.Compressor = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrUnknownCompression, err)
+}
+
+func TestDB_Merge_Recompress(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression-merge")
+	opts.DirPath = dir
+	opts.MergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := bytes.Repeat([]byte("bitcask"), 100)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	assert.Nil(t, db.Close())
+	plainSize, err := utils.DirSize(dir)
+	assert.Nil(t, err)
+
+	// 修改压缩方式后 merge，旧数据使用新的压缩方式重写
+	opts.Compression = FlateCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	compressedSize, err := utils.DirSize(dir)
+	assert.Nil(t, err)
+	assert.True(t, compressedSize < plainSize/2)
+	for i := 0; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+var (
+	ErrEncryptionKeyRequired = errors.New("the data is encrypted, encryption key is required")
+	ErrInvalidEncryptionKey  = errors.New("failed to decrypt the data, encryption key is wrong")
+)
+
+// Cipher 使用 AES-GCM 加密记录中的 key 和 value
+// 写入时使用当前的密钥，读取时依次尝试当前的密钥和轮换之前的旧密钥
+type Cipher struct {
+	aeads []cipher.AEAD
+}
+
+// NewCipher 创建 Cipher，key 为当前的密钥，oldKeys 为轮换之前使用的密钥
+// 密钥长度必须是 16、24 或 32 字节，分别对应 AES-128、AES-192 和 AES-256
+func NewCipher(key []byte, oldKeys ...[]byte) (*Cipher, error) {
+	c := &Cipher{}
+	for _, k := range append([][]byte{key}, oldKeys...) {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		c.aeads = append(c.aeads, aead)
+	}
+	return c, nil
+}
+
+// Seal 使用当前的密钥加密记录，返回加密之后的记录
+//
+//	+-------------+---------------------------+
+//	|   nonce     |  密文(key + value) + tag   |
+//	+-------------+---------------------------+
+//	    12字节                变长
+//
+// 加密后的数据按照原始 key 的长度拆分为 key 和 value 两部分写入，
+// 编码格式与普通记录一致，读取时再拼接起来解密
+func (c *Cipher) Seal(logRecord *LogRecord) *LogRecord {
+	aead := c.aeads[0]
+	plaintext := make([]byte, len(logRecord.Key)+len(logRecord.Value))
+	copy(plaintext, logRecord.Key)
+	copy(plaintext[len(logRecord.Key):], logRecord.Value)
+
+	sealed := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(sealed); err != nil {
+		panic(err)
+	}
+	sealed = aead.Seal(sealed, sealed, plaintext, nil)
+
+	encrypted := *logRecord
+	encrypted.Key = sealed[:len(logRecord.Key)]
+	encrypted.Value = sealed[len(logRecord.Key):]
+	encrypted.Encrypted = true
+	return &encrypted
+}
+
+// open 解密记录中的 key 和 value
+func (c *Cipher) open(logRecord *LogRecord) error {
+	keySize := len(logRecord.Key)
+	sealed := make([]byte, keySize+len(logRecord.Value))
+	copy(sealed, logRecord.Key)
+	copy(sealed[keySize:], logRecord.Value)
+
+	for _, aead := range c.aeads {
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+		plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+		if err != nil || len(plaintext) < keySize {
+			continue
+		}
+		logRecord.Key = plaintext[:keySize]
+		logRecord.Value = plaintext[keySize:]
+		logRecord.Encrypted = false
+		return nil
+	}
+	return ErrInvalidEncryptionKey
+}
@@ -0,0 +1,51 @@
+package data
+
+import (
+	"bytes"
+	"go-bitcask/fio"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCipher_SealAndRead(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cipher")
+	defer os.RemoveAll(dir)
+	key := bytes.Repeat([]byte("k"), 32)
+	c, err := NewCipher(key)
+	assert.Nil(t, err)
+
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	record := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go"), Expire: 1, Compression: 1}
+	encRecord, size := EncodeLogRecord(c.Seal(record))
+	assert.False(t, bytes.Contains(encRecord, record.Value))
+	assert.Nil(t, dataFile.Write(encRecord))
+
+	// 没有密钥时无法读取
+	_, _, err = dataFile.ReadLogRecord(FileHeaderSize)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+
+	// 使用错误的密钥
+	dataFile.Cipher, err = NewCipher(bytes.Repeat([]byte("x"), 32))
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(FileHeaderSize)
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+
+	// 旧密钥也可以用于解密
+	dataFile.Cipher, err = NewCipher(bytes.Repeat([]byte("x"), 16), key)
+	assert.Nil(t, err)
+	readRecord, readSize, err := dataFile.ReadLogRecord(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, size, readSize)
+	assert.Equal(t, record.Key, readRecord.Key)
+	assert.Equal(t, record.Value, readRecord.Value)
+	assert.Equal(t, record.Expire, readRecord.Expire)
+	assert.Equal(t, record.Compression, readRecord.Compression)
+	assert.False(t, readRecord.Encrypted)
+
+	_, err = NewCipher([]byte("short"))
+	assert.NotNil(t, err)
+}
@@ -15,89 +15,231 @@ var (
 
 const (
 	DataFileNameSuffix    = ".data"
+	HintFileNameSuffix    = ".hint"
+	ValueLogFileSuffix    = ".vlog"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
+	IndexSnapshotFileName = "index-snapshot"
+	KeyCheckFileName      = "encryption-check"
 )
 
+// VerifyLogRecord 分段校验记录时每次读取的字节数
+const verifyBufferSize = 64 * 1024
+
 // DataFile 磁盘中数据文件的结构体
 // 会有多个磁盘文件
 type DataFile struct {
 	FileId    uint32        // 文件 id
 	WriteOff  int64         // 文件写到哪个位置
 	IoManager fio.IOManager // io 读写管理
+	Cipher    *Cipher       // 加密记录使用的 Cipher，为空时不加密
+	Header    *FileHeader   // 文件头，没有文件头的旧文件为空
+	kind      FileKind      // 文件类型
 }
 
 // OpenDataFile 打开新的数据文件
 // 返回 一个数据文件实例和 error
 func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := GetDatafleName(dirPath, fileId)
-	return newDataFile(fileName, fileId, ioType)
+	return newDataFile(fileName, fileId, ioType, FileKindData)
 }
 
 // OpenHintFile 打开 Hint 索引文件
-func OpenHintFile(dirPath string) (*DataFile, error) {
-	fileName := filepath.Join(dirPath, DataFileNameSuffix)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+func OpenHintFile(dirPath string, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, HintFileName)
+	return newDataFile(fileName, 0, ioType, FileKindHint)
+}
+
+// OpenDataHintFile 打开数据文件对应的 hint 文件
+func OpenDataHintFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := GetHintFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId, ioType, FileKindHint)
+}
+
+// OpenValueLogFile 打开存储较大 value 的 value log 文件
+func OpenValueLogFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := GetValueLogFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId, ioType, FileKindValueLog)
 }
 
 // OpenMergeFinishedFile 打开标识 merge 完成的文件
-func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
+func OpenMergeFinishedFile(dirPath string, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fileName, 0, ioType, FileKindMergeFinished)
+}
+
+// OpenIndexSnapshotFile 打开内存索引的快照文件，fileName 为完整的文件路径
+func OpenIndexSnapshotFile(fileName string, ioType fio.FileIOType) (*DataFile, error) {
+	return newDataFile(fileName, 0, ioType, FileKindIndexSnapshot)
+}
+
+// OpenKeyCheckFile 打开校验加密密钥的文件，fileName 为完整的文件路径
+func OpenKeyCheckFile(fileName string, ioType fio.FileIOType) (*DataFile, error) {
+	return newDataFile(fileName, 0, ioType, FileKindKeyCheck)
 }
 
 func GetDatafleName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
-func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+func GetHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileNameSuffix)
+}
+
+func GetValueLogFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+ValueLogFileSuffix)
+}
+
+func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType, kind FileKind) (*DataFile, error) {
 	// 初始化 IOManager 接口
 	ioManager, err := fio.NewIOManager(fileName, ioType)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DataFile{
+	dataFile := &DataFile{
 		FileId:    fileId,
 		WriteOff:  0,
 		IoManager: ioManager,
-	}, nil
+		kind:      kind,
+	}
+	// 写入或者校验文件头
+	if err := dataFile.initHeader(ioType); err != nil {
+		_ = ioManager.Close()
+		return nil, err
+	}
+	return dataFile, nil
 }
 
 // ReadLogRecord 根据 offset 从 Datafile 读取 LogRecord
 // 返回对应的 logRecord 和它的 长度以及 error
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
-	fileSize, err := df.IoManager.Size()
+	logRecord, recordSize, err := df.readLogRecord(offset)
 	if err != nil {
 		return nil, 0, err
 	}
 
+	// 解密 key 和 value
+	if logRecord.Encrypted {
+		if df.Cipher == nil {
+			return nil, 0, ErrEncryptionKeyRequired
+		}
+		if err := df.Cipher.open(logRecord); err != nil {
+			return nil, 0, err
+		}
+	}
+	return logRecord, recordSize, nil
+}
+
+// LogRecordValue 描述 LogRecord 的 value 在数据文件中的位置，不需要将整条记录读入内存
+type LogRecordValue struct {
+	Type         LogRecordType
+	Compression  uint8
+	Encrypted    bool
+	ValuePointer bool
+	Offset       int64 // value 在数据文件中的位置
+	Size         int64 // value 的长度
+}
+
+// ReadLogRecordValue 只读取 offset 位置的 LogRecord 的 header，返回 value 在数据文件中的位置
+func (df *DataFile) ReadLogRecordValue(offset int64) (*LogRecordValue, error) {
+	header, _, headerSize, err := df.readLogRecordHeader(offset)
+	if err != nil {
+		return nil, err
+	}
+	return &LogRecordValue{
+		Type:         header.recordType,
+		Compression:  header.compress,
+		Encrypted:    header.encrypted,
+		ValuePointer: header.valuePtr,
+		Offset:       offset + headerSize + int64(header.keySize),
+		Size:         int64(header.valueSize),
+	}, nil
+}
+
+// VerifyLogRecord 分段读取 offset 位置的 LogRecord 并校验 crc，不将整条记录读入内存
+func (df *DataFile) VerifyLogRecord(offset int64) error {
+	header, headerBuf, headerSize, err := df.readLogRecordHeader(offset)
+	if err != nil {
+		return err
+	}
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write(headerBuf[crc32.Size:headerSize])
+	buf := make([]byte, verifyBufferSize)
+	start := offset + headerSize
+	for remaining := int64(header.keySize) + int64(header.valueSize); remaining > 0; {
+		n := int64(len(buf))
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := df.IoManager.Read(buf[:n], start); err != nil {
+			return err
+		}
+		_, _ = crc.Write(buf[:n])
+		start += n
+		remaining -= n
+	}
+	if crc.Sum32() != header.crc {
+		return ErrInvalidCRC
+	}
+	return nil
+}
+
+// readLogRecordHeader 读取 offset 位置的 LogRecord 的 header，返回 header、读取的字节以及 header 的长度
+func (df *DataFile) readLogRecordHeader(offset int64) (*logRecordHeader, []byte, int64, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
 	//  如果读取的最大 header 长度 已经超过了文件的长, 只需要读取到文件的末尾即可
 	var headerBytes int64 = maxLogRecordHeaderSize
 	if offset+maxLogRecordHeaderSize > fileSize {
 		headerBytes = fileSize - offset
 	}
+	if headerBytes <= 0 {
+		return nil, nil, 0, io.EOF
+	}
 
 	// 读取 Header 信息
 	headerBuf, err := df.readNBytes(headerBytes, offset)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, 0, err
 	}
 
 	header, headerSize := decodeLogRecordHeader(headerBuf)
 	// 处理读到了文件末尾的逻辑
 	if header == nil {
-		return nil, 0, io.EOF
+		return nil, nil, 0, io.EOF
 	}
 	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
-		return nil, 0, io.EOF
+		return nil, nil, 0, io.EOF
+	}
+	// 记录只写入了一部分
+	if offset+headerSize+int64(header.keySize)+int64(header.valueSize) > fileSize {
+		return nil, nil, 0, io.ErrUnexpectedEOF
+	}
+	return header, headerBuf, headerSize, nil
+}
+
+// readLogRecord 读取并校验 offset 位置的 LogRecord，不对数据进行解密
+func (df *DataFile) readLogRecord(offset int64) (*LogRecord, int64, error) {
+	header, headerBuf, headerSize, err := df.readLogRecordHeader(offset)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	// 取出 key 和 value的长度
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
 
-	logRecord := &LogRecord{Type: header.recordType}
+	logRecord := &LogRecord{
+		Type:         header.recordType,
+		Expire:       header.expire,
+		Compression:  header.compress,
+		Encrypted:    header.encrypted,
+		ValuePointer: header.valuePtr,
+	}
 	//  读取用户实际存储的 key 和 value
 	if keySize > 0 || valueSize > 0 {
 		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
@@ -135,6 +277,24 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 		Key:   key,
 		Value: EncodeLogRecordPos(pos),
 	}
+	return df.writeRecord(record)
+}
+
+// WriteTypedHintRecord 写入带有记录类型的索引信息到 hint file
+func (df *DataFile) WriteTypedHintRecord(key []byte, typ LogRecordType, pos *LogRecordPos) error {
+	record := &LogRecord{
+		Key:   key,
+		Value: EncodeLogRecordPos(pos),
+		Type:  typ,
+	}
+	return df.writeRecord(record)
+}
+
+// writeRecord 编码记录并写入，设置了 Cipher 时先加密
+func (df *DataFile) writeRecord(record *LogRecord) error {
+	if df.Cipher != nil {
+		record = df.Cipher.Seal(record)
+	}
 	encRecord, _ := EncodeLogRecord(record)
 	return df.Write(encRecord)
 }
@@ -166,5 +326,9 @@ func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
 		return err
 	}
 	df.IoManager = ioManager
+	// 使用内存映射打开的空文件在这里补上文件头
+	if df.Header == nil && df.WriteOff == 0 {
+		return df.initHeader(ioType)
+	}
 	return nil
 }
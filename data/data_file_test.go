@@ -2,6 +2,7 @@ package data
 
 import (
 	"go-bitcask/fio"
+	"io"
 	"os"
 	"testing"
 
@@ -76,7 +77,9 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	err = dataFile.Write(res1)
 	assert.Nil(t, err)
 
-	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	// 第一条记录在文件头之后
+	offset := dataFile.HeaderSize()
+	readRec1, readSize1, err := dataFile.ReadLogRecord(offset)
 	assert.Nil(t, err)
 	assert.Equal(t, rec1, readRec1)
 	assert.Equal(t, size1, readSize1)
@@ -92,7 +95,7 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Nil(t, err)
 
 	// t.Log(size2) // size2: 22
-	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	readRec2, readSize2, err := dataFile.ReadLogRecord(offset + size1)
 	assert.Nil(t, err)
 	assert.Equal(t, rec2, readRec2)
 	assert.Equal(t, size2, readSize2)
@@ -108,8 +111,63 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Nil(t, err)
 	t.Log(size3)
 
-	readRec3, readSize3, err := dataFile.ReadLogRecord(size1 + size2)
+	readRec3, readSize3, err := dataFile.ReadLogRecord(offset + size1 + size2)
 	assert.Nil(t, err)
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)
 }
+
+func TestDataFile_ReadLogRecord_Truncated(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-truncated")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	rec := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("go bitcask kv"),
+	}
+	buf, size := EncodeLogRecord(rec)
+	assert.Nil(t, dataFile.Write(buf))
+	// 只写入了一部分的记录
+	assert.Nil(t, dataFile.Write(buf[:size-3]))
+
+	_, readSize, err := dataFile.ReadLogRecord(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, size, readSize)
+	_, _, err = dataFile.ReadLogRecord(FileHeaderSize + size)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestDataFile_ReadLogRecordValue(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-value")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	value := make([]byte, verifyBufferSize*2+10)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	rec := &LogRecord{Key: []byte("name"), Value: value}
+	buf, _ := EncodeLogRecord(rec)
+	assert.Nil(t, dataFile.Write(buf))
+
+	// 只读取 header 就可以找到 value 的位置
+	info, err := dataFile.ReadLogRecordValue(FileHeaderSize)
+	assert.Nil(t, err)
+	assert.Equal(t, LogRecordNormal, info.Type)
+	assert.Equal(t, int64(len(value)), info.Size)
+	part := make([]byte, 10)
+	_, err = dataFile.IoManager.Read(part, info.Offset+100)
+	assert.Nil(t, err)
+	assert.Equal(t, value[100:110], part)
+	assert.Nil(t, dataFile.VerifyLogRecord(FileHeaderSize))
+
+	// 分段校验时可以发现损坏的数据
+	buf[len(buf)-1]++
+	assert.Nil(t, dataFile.Write(buf))
+	assert.Equal(t, ErrInvalidCRC, dataFile.VerifyLogRecord(FileHeaderSize+int64(len(buf))))
+}
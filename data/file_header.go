@@ -0,0 +1,131 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"go-bitcask/fio"
+	"time"
+)
+
+var (
+	ErrInvalidFileHeader      = errors.New("invalid file header, not a bitcask file")
+	ErrUnsupportedFileVersion = errors.New("unsupported file format version")
+)
+
+// FileKind 文件的类型，记录在文件头中
+type FileKind = byte
+
+const (
+	FileKindData          FileKind = iota + 1 // 数据文件
+	FileKindHint                              // hint 文件，包括 merge 生成的 hint 文件以及每个数据文件的 hint 文件
+	FileKindMergeFinished                     // 标识 merge 完成的文件
+	FileKindValueLog                          // 存储较大 value 的 value log 文件
+	FileKindIndexSnapshot                     // 内存索引的快照文件
+	FileKindKeyCheck                          // 校验加密密钥的文件
+)
+
+const (
+	// FileHeaderSize 文件头的长度，文件中的第一条记录从这个位置开始
+	FileHeaderSize = 16
+	// FileFormatVersion 当前的文件格式版本
+	FileFormatVersion uint16 = 1
+)
+
+// 文件头中的魔数，用于识别 bitcask 文件
+var fileMagic = []byte("BTCK")
+
+// FileHeader 每个文件开头的文件头
+//
+//	+-------------+-------------+-------------+-------------+----------------+
+//	|    magic    |   version   |    kind     |   reserved  |   create time  |
+//	+-------------+-------------+-------------+-------------+----------------+
+//	    4字节          2字节         1字节          1字节          8字节
+//
+// 没有文件头的旧文件从 0 开始存储记录，读取时仍然兼容，merge 之后会被替换为带有文件头的新文件
+type FileHeader struct {
+	Version   uint16    // 文件格式版本
+	Kind      FileKind  // 文件类型
+	CreatedAt time.Time // 文件创建时间
+}
+
+// encodeFileHeader 编码文件头
+func encodeFileHeader(header *FileHeader) []byte {
+	buf := make([]byte, FileHeaderSize)
+	copy(buf, fileMagic)
+	binary.LittleEndian.PutUint16(buf[4:], header.Version)
+	buf[6] = header.Kind
+	binary.LittleEndian.PutUint64(buf[8:], uint64(header.CreatedAt.UnixNano()))
+	return buf
+}
+
+// decodeFileHeader 解码文件头，buf 不是以魔数开头时返回 nil
+func decodeFileHeader(buf []byte) (*FileHeader, error) {
+	if len(buf) < len(fileMagic) || !bytes.Equal(buf[:len(fileMagic)], fileMagic) {
+		return nil, nil
+	}
+	if len(buf) < FileHeaderSize {
+		return nil, ErrInvalidFileHeader
+	}
+	return &FileHeader{
+		Version:   binary.LittleEndian.Uint16(buf[4:]),
+		Kind:      buf[6],
+		CreatedAt: time.Unix(0, int64(binary.LittleEndian.Uint64(buf[8:]))),
+	}, nil
+}
+
+// HeaderSize 返回文件头的长度，即第一条记录的位置，没有文件头的旧文件返回 0
+func (df *DataFile) HeaderSize() int64 {
+	if df.Header == nil {
+		return 0
+	}
+	return FileHeaderSize
+}
+
+// initHeader 为新文件写入文件头，已经存在的文件则读取并校验文件头
+// 使用内存映射打开的空文件无法写入，会在切换为标准文件 IO 时写入文件头
+func (df *DataFile) initHeader(ioType fio.FileIOType) error {
+	size, err := df.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		if ioType != fio.StandardFIO {
+			return nil
+		}
+		header := &FileHeader{Version: FileFormatVersion, Kind: df.kind, CreatedAt: time.Now()}
+		if err := df.Write(encodeFileHeader(header)); err != nil {
+			return err
+		}
+		df.Header = header
+		return nil
+	}
+
+	headerBytes := int64(FileHeaderSize)
+	if size < headerBytes {
+		headerBytes = size
+	}
+	buf, err := df.readNBytes(headerBytes, 0)
+	if err != nil {
+		return err
+	}
+	header, err := decodeFileHeader(buf)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		// 没有文件头的旧文件，从 0 开始必须是一条完整的记录
+		if _, _, err := df.readLogRecord(0); err != nil {
+			return ErrInvalidFileHeader
+		}
+		return nil
+	}
+	if header.Version > FileFormatVersion {
+		return ErrUnsupportedFileVersion
+	}
+	if header.Kind != df.kind {
+		return ErrInvalidFileHeader
+	}
+	df.Header = header
+	return nil
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"encoding/binary"
+	"go-bitcask/fio"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataFile_Header(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-header")
+	defer os.RemoveAll(dir)
+
+	// 新文件写入文件头
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile.Header)
+	assert.Equal(t, FileFormatVersion, dataFile.Header.Version)
+	assert.Equal(t, FileKindData, dataFile.Header.Kind)
+	assert.Equal(t, int64(FileHeaderSize), dataFile.WriteOff)
+	createdAt := dataFile.Header.CreatedAt
+	assert.Nil(t, dataFile.Close())
+
+	// 重新打开时读取文件头
+	dataFile, err = OpenDataFile(dir, 0, fio.MemoryMap)
+	assert.Nil(t, err)
+	assert.Equal(t, createdAt.UnixNano(), dataFile.Header.CreatedAt.UnixNano())
+	assert.Equal(t, int64(FileHeaderSize), dataFile.HeaderSize())
+	assert.Nil(t, dataFile.Close())
+
+	// 文件类型不匹配
+	assert.Nil(t, os.Rename(GetDatafleName(dir, 0), GetHintFileName(dir, 0)))
+	_, err = OpenDataHintFile(dir, 0, fio.MemoryMap)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+
+	// 更新版本的文件
+	buf, err := os.ReadFile(GetHintFileName(dir, 0))
+	assert.Nil(t, err)
+	binary.LittleEndian.PutUint16(buf[4:], FileFormatVersion+1)
+	assert.Nil(t, os.WriteFile(GetDatafleName(dir, 1), buf, 0644))
+	_, err = OpenDataFile(dir, 1, fio.MemoryMap)
+	assert.Equal(t, ErrUnsupportedFileVersion, err)
+
+	// 不是 bitcask 的文件
+	assert.Nil(t, os.WriteFile(GetDatafleName(dir, 2), []byte("not a bitcask file"), 0644))
+	_, err = OpenDataFile(dir, 2, fio.StandardFIO)
+	assert.Equal(t, ErrInvalidFileHeader, err)
+
+	// 没有文件头的旧文件仍然可以读取
+	encRecord, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask")})
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, MergeFinishedFileName), encRecord, 0644))
+	legacyFile, err := OpenMergeFinishedFile(dir, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer legacyFile.Close()
+	assert.Nil(t, legacyFile.Header)
+	assert.Equal(t, int64(0), legacyFile.HeaderSize())
+	record, readSize, err := legacyFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, size, readSize)
+	assert.Equal(t, []byte("bitcask"), record.Value)
+}
@@ -13,17 +13,42 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDelete
 	LogRecordTxnFinished
+	// 流式写入的 value 中的一段数据，不会出现在索引中
+	LogRecordChunk
+	// 流式写入的 value 的清单，记录了每段数据的位置
+	LogRecordManifest
 )
 
-// crc type key_size value_size
-// 4 +  1  +   5   +    5 = 15
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// type 字节的低 3 位存储 LogRecord 类型，高位作为标识位使用
+const (
+	logRecordTypeMask byte = 0x07
+	// 标识 header 中带有过期时间
+	logRecordExpireFlag byte = 0x80
+	// 第 6、7 位存储 value 的压缩方式
+	logRecordCompressionShift      = 5
+	logRecordCompressionMask  byte = 0x03
+	// 标识 key 和 value 已经加密
+	logRecordEncryptedFlag byte = 0x10
+	// 标识 value 存储在 value log 文件中，记录中的 value 是编码后的位置
+	logRecordValuePointerFlag byte = 0x08
+)
+
+// crc type key_size value_size expire
+// 4 +  1  +   5   +    5     +  10 = 25
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64 + 5
 
 // LogRecord 磁盘文件中数据记录的结构体
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType
+	Expire int64 // 过期时间（UnixNano），0 表示永不过期
+	// value 的压缩方式，0 表示没有压缩，取值范围为 0-3
+	Compression uint8
+	// key 和 value 是否是加密之后的数据
+	Encrypted bool
+	// value 是否是指向 value log 文件的位置
+	ValuePointer bool
 }
 
 // LogRecord 的头部信息
@@ -32,13 +57,23 @@ type logRecordHeader struct {
 	recordType LogRecordType // LogRecord 类型
 	keySize    uint32        // key 长度
 	valueSize  uint32        // value 长度
+	expire     int64         // 过期时间
+	compress   uint8         // value 的压缩方式
+	encrypted  bool          // key 和 value 是否加密
+	valuePtr   bool          // value 是否存储在 value log 文件中
 }
 
 // LogRecordPos 描述数据在磁盘上的位置，内存中的数据索引，
 type LogRecordPos struct {
 	Fid    uint32 // 文件id， 标识数据在哪个文件
 	Offset int64  // 偏移量，数据在数据文件中的位置
-	// Size   int32 // 标识数据在磁盘上的大小
+	Size   uint32 // 标识数据在磁盘上的大小
+	Expire int64  // 过期时间（UnixNano），0 表示永不过期
+}
+
+// IsExpired 判断数据在 now 时刻是否已经过期
+func (pos *LogRecordPos) IsExpired(now int64) bool {
+	return pos.Expire > 0 && pos.Expire <= now
 }
 
 // TranscationRecord 暂存事务的相关数据
@@ -49,21 +84,37 @@ type TranscationRecord struct {
 
 // EncodeLogRecord 对 LogRecord 进行编码，返回编码后的数据和对应长度
 //
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	| crc 校验值  |  type 类型   |    key size |   value size |      key    |      value   |
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	    4字节          1字节        变长（最大5）   变长（最大5）     变长           变长
+//	+-------------+-------------+-------------+--------------+--------------+-------------+--------------+
+//	| crc 校验值  |  type 类型   |    key size |   value size |  expire 过期  |      key    |      value   |
+//	+-------------+-------------+-------------+--------------+--------------+-------------+--------------+
+//	    4字节          1字节        变长（最大5）   变长（最大5）  变长（最大10）      变长           变长
+//
+// expire 只有在设置了过期时间时才会写入，并在 type 字节中打上 logRecordExpireFlag 标识，
+// value 的压缩方式、是否加密以及 value 是否存储在 value log 中同样记录在 type 字节中
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	// 初始化 header 部分的字节数组
 	header := make([]byte, maxLogRecordHeaderSize)
 
 	// 从第五个字节存储 Type
 	header[4] = logRecord.Type
+	if logRecord.Expire > 0 {
+		header[4] |= logRecordExpireFlag
+	}
+	header[4] |= (logRecord.Compression & logRecordCompressionMask) << logRecordCompressionShift
+	if logRecord.Encrypted {
+		header[4] |= logRecordEncryptedFlag
+	}
+	if logRecord.ValuePointer {
+		header[4] |= logRecordValuePointerFlag
+	}
 	var index = 5
 	// 5 字节后存储 key 和 value 的长度信息
 	// 使用变长类型
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	if logRecord.Expire > 0 {
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
 
 	var size = index + len(logRecord.Key) + len(logRecord.Value)
 	encBytes := make([]byte, size)
@@ -83,21 +134,37 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 }
 
 // EncodeLogRecordPos 对 LogRecordPos 进行编码
+// 没有过期时间时不写入 expire
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
+	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	if pos.Expire > 0 {
+		index += binary.PutVarint(buf[index:], pos.Expire)
+	}
 	return buf[:index]
 }
 
 // DecodeLogRecordPos 对 LogRecordPos 进行解码
+// 兼容只包含 fid 和 offset 的旧编码格式
 func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	var index = 0
 	fileId, n := binary.Varint(buf[index:])
 	index += n
-	offset, _ := binary.Varint(buf[index:])
-	return &LogRecordPos{Fid: uint32(fileId), Offset: offset}
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	pos := &LogRecordPos{Fid: uint32(fileId), Offset: offset}
+	if index < len(buf) {
+		size, n := binary.Varint(buf[index:])
+		pos.Size = uint32(size)
+		index += n
+	}
+	if index < len(buf) {
+		pos.Expire, _ = binary.Varint(buf[index:])
+	}
+	return pos
 }
 
 // decodeLogRecordHeader 解码 logRecoredHeader 字节数组，
@@ -109,7 +176,10 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 
 	header := &logRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] & logRecordTypeMask,
+		compress:   buf[4] >> logRecordCompressionShift & logRecordCompressionMask,
+		encrypted:  buf[4]&logRecordEncryptedFlag != 0,
+		valuePtr:   buf[4]&logRecordValuePointerFlag != 0,
 	}
 
 	var index = 5
@@ -121,6 +191,12 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	valueSize, n := binary.Varint(buf[index:])
 	header.valueSize = uint32(valueSize)
 	index += n
+	// 取出过期时间
+	if buf[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		header.expire = expire
+		index += n
+	}
 
 	return header, int64(index)
 }
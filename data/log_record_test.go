@@ -110,3 +110,48 @@ func TestLogRecord_GetLogRecordCRC(t *testing.T) {
 	// t.Log(crc3)
 	assert.Equal(t, uint32(2751453638), crc3)
 }
+
+func TestLogRecord_EncodeWithExpire(t *testing.T) {
+	rec := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("go-bitcask"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+	res, n := EncodeLogRecord(rec)
+	assert.Equal(t, int64(len(res)), n)
+	assert.Equal(t, logRecordExpireFlag, res[4]&logRecordExpireFlag)
+
+	header, headerSize := decodeLogRecordHeader(res)
+	assert.NotNil(t, header)
+	assert.Equal(t, LogRecordNormal, header.recordType)
+	assert.Equal(t, rec.Expire, header.expire)
+	assert.Equal(t, n, headerSize+int64(header.keySize)+int64(header.valueSize))
+	assert.Equal(t, header.crc, getLogRecordCRC(rec, res[crc32.Size:headerSize]))
+
+	// LogRecordPos 带上过期时间的编解码
+	pos := &LogRecordPos{Fid: 3, Offset: 100, Size: uint32(n), Expire: rec.Expire}
+	assert.Equal(t, pos, DecodeLogRecordPos(EncodeLogRecordPos(pos)))
+	assert.Equal(t, &LogRecordPos{Fid: 3, Offset: 100, Size: 20}, DecodeLogRecordPos(EncodeLogRecordPos(&LogRecordPos{Fid: 3, Offset: 100, Size: 20})))
+}
+
+func TestLogRecord_EncodeWithCompression(t *testing.T) {
+	rec := &LogRecord{
+		Key:         []byte("name"),
+		Value:       []byte("compressed"),
+		Type:        LogRecordDelete,
+		Expire:      1700000000000000000,
+		Compression: 2,
+	}
+	res, _ := EncodeLogRecord(rec)
+	header, _ := decodeLogRecordHeader(res)
+	assert.NotNil(t, header)
+	assert.Equal(t, LogRecordDelete, header.recordType)
+	assert.Equal(t, rec.Expire, header.expire)
+	assert.Equal(t, uint8(2), header.compress)
+
+	// 没有压缩的记录编码结果保持不变
+	rec.Compression = 0
+	res, _ = EncodeLogRecord(rec)
+	assert.Equal(t, LogRecordDelete|logRecordExpireFlag, res[4])
+}
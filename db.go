@@ -7,25 +7,29 @@ import (
 	"go-bitcask/fio"
 	"go-bitcask/index"
 	"go-bitcask/utils"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofrs/flock"
 )
 
 const (
 	fileLockName = "flock"
+	// 分片索引写入时使用的 key 锁的数量
+	keyLockNum = 256
 )
 
 // DB bitcask 存储引擎实例
 type DB struct {
 	options     Options
 	mu          *sync.RWMutex
+	indexMu     *sync.RWMutex             // 在 db.mu 之外更新分片索引时持有读锁，持有 db.mu 写锁的操作同时持有写锁
+	keyLocks    []sync.Mutex              // 按照 key 的哈希值分配的锁，保证同一个 key 的写入按照顺序更新分片索引
 	fileIds     []int                     // 文件id只能在加载索引的时候使用
 	activeFile  *data.DataFile            // 当前唯一的活跃数据文件
 	oldFiles    map[uint32]*data.DataFile // 旧的数据文件
@@ -34,6 +38,39 @@ type DB struct {
 	isMerging   bool                      // 是否正在 merge
 	fileLock    *flock.Flock              // 文件锁, 保证多进程之间的互斥
 	bytesWrites uint                      // 累计写了多少个字节 用于持久化策略
+	snapshots   map[*Snapshot]struct{}    // 当前仍然存活的快照
+	txns        map[*Txn]struct{}         // 正在进行中的读写事务
+	version     uint64                    // 有进行中的事务时，每次用户写入递增的版本号
+	keyVersions map[string]uint64         // 进行中的事务开始之后被修改的 key 以及最后一次修改的版本号
+	reclaimable map[uint32]int64          // 每个数据文件中可以被 merge 回收的字节数
+	dirtyFids   map[uint32]struct{}       // 上次记录水位之后可回收字节数发生变化的数据文件，只在使用 B+ 树索引时记录
+	iterators   int                       // 未关闭的迭代器数量
+	itersClosed *sync.Cond                // 未关闭的迭代器全部关闭时通知等待替换 merge 结果的 goroutine
+	recovery    *RecoveryReport           // 启动时修复数据文件的报告
+	corrupted   map[uint32]int64          // 启动时发现损坏、没有截断的数据文件中有效数据的大小，merge 只读取有效的部分
+	closeCh     chan struct{}             // 通知后台任务退出
+	bgWg        *sync.WaitGroup           // 等待后台任务退出
+	hintWg      *sync.WaitGroup           // 等待后台生成 hint 文件的任务完成
+	noHintFiles bool                      // 是否不为写满的数据文件生成 hint 文件
+	cipher      *data.Cipher              // 加密记录使用的 Cipher，没有配置密钥时为空
+	activeVlog  *data.DataFile            // 当前写入的 value log 文件，启动后第一次写入时创建
+	oldVlogs    map[uint32]*data.DataFile // 不再写入的 value log 文件
+	streamMu    *sync.RWMutex             // 流式写入期间持有读锁，merge 期间持有写锁
+	cache       *valueCache               // 读取 value 的缓存，没有配置缓存大小时为空
+	indexLoaded bool                      // 内存索引是否已经加载完成，加载失败时不能写入索引快照
+	snapshotMu  *sync.Mutex               // 写入索引快照期间持有，merge 开始前等待正在写入的快照完成
+}
+
+// Stat 存储引擎的统计信息
+type Stat struct {
+	KeyNum          uint   // key 的总数量
+	DataFileNum     uint   // 数据文件的数量
+	ReclaimableSize int64  // 可以进行 merge 回收的数据量，字节为单位
+	DiskSize        int64  // 数据目录所占磁盘空间大小
+	ActiveFileId    uint32 // 当前活跃文件的 id
+	ValueLogFileNum uint   // value log 文件的数量
+	CacheHits       uint64 // 读取 value 时命中缓存的次数
+	CacheMisses     uint64 // 读取 value 时没有命中缓存的次数
 }
 
 // Open 打开bitcask存储引擎实例并返回
@@ -62,44 +99,135 @@ func Open(options Options) (*DB, error) {
 
 	// 初始化DB实例结构体
 	db := &DB{
-		options:  options,
-		mu:       new(sync.RWMutex),
-		oldFiles: make(map[uint32]*data.DataFile),
-		index:    index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrite),
-		fileLock: fileLock,
+		options:     options,
+		mu:          new(sync.RWMutex),
+		indexMu:     new(sync.RWMutex),
+		oldFiles:    make(map[uint32]*data.DataFile),
+		index:       index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrite),
+		fileLock:    fileLock,
+		snapshots:   make(map[*Snapshot]struct{}),
+		txns:        make(map[*Txn]struct{}),
+		keyVersions: make(map[string]uint64),
+		reclaimable: make(map[uint32]int64),
+		oldVlogs:    make(map[uint32]*data.DataFile),
+		recovery:    &RecoveryReport{},
+		corrupted:   make(map[uint32]int64),
+		closeCh:     make(chan struct{}),
+		bgWg:        new(sync.WaitGroup),
+		hintWg:      new(sync.WaitGroup),
+		streamMu:    new(sync.RWMutex),
+		snapshotMu:  new(sync.Mutex),
+	}
+	db.itersClosed = sync.NewCond(db.mu)
+
+	// 初始化加密记录使用的 Cipher
+	if len(options.EncryptionKey) > 0 {
+		if db.cipher, err = data.NewCipher(options.EncryptionKey, options.OldEncryptionKeys...); err != nil {
+			_ = db.index.Close()
+			_ = fileLock.Unlock()
+			return nil, err
+		}
+	}
+	// 校验配置的密钥与数据目录使用的密钥是否一致
+	if err := db.checkEncryptionKey(); err != nil {
+		_ = db.index.Close()
+		_ = fileLock.Unlock()
+		return nil, err
+	}
+
+	if options.ValueCacheSize > 0 {
+		db.cache = newValueCache(options.ValueCacheSize)
+	}
+
+	if options.IndexType == BPlusTree {
+		db.dirtyFids = make(map[uint32]struct{})
+	}
+	// 分片索引的不同分片可以并发更新，写入时只有追加数据文件需要持有互斥锁
+	if options.IndexType == ShardedBTree {
+		db.keyLocks = make([]sync.Mutex, keyLockNum)
+	}
+
+	// 加载数据文件和索引，失败时释放已经打开的资源
+	if err := db.load(); err != nil {
+		_ = db.Close()
+		return nil, err
 	}
 
+	// 启动后台自动 merge 任务
+	if options.MergeCheckInterval > 0 {
+		db.bgWg.Add(1)
+		go db.autoMerge()
+	}
+
+	// 启动后台定期写入索引快照的任务
+	if options.IndexSnapshot && options.IndexSnapshotInterval > 0 && options.IndexType != BPlusTree {
+		db.bgWg.Add(1)
+		go db.autoIndexSnapshot()
+	}
+
+	return db, nil
+}
+
+// load 加载 merge 数据目录、数据文件以及内存索引
+func (db *DB) load() error {
 	// 加载 merge 数据目录
 	if err := db.loadMergeFiles(); err != nil {
-		return nil, err
+		return err
 	}
 
 	// 加载数据文件
 	if err := db.loadDataFile(); err != nil {
-		return nil, err
+		return err
+	}
+
+	// 打开 value log 文件
+	if err := db.loadValueLogFiles(); err != nil {
+		return err
 	}
 
-	// B+树索引不需要从数据文件中加载
-	if options.IndexType != BPlusTree {
+	if db.options.IndexType == BPlusTree {
+		// B+树索引已经持久化，只需要从数据文件中加载水位之后的数据
+		if err := db.loadBPlusTreeIndex(); err != nil {
+			return err
+		}
+	} else {
+		// 优先从索引快照加载，没有开启时删除之前留下的快照
+		var snapshotPos *data.LogRecordPos
+		if db.options.IndexSnapshot {
+			var err error
+			if snapshotPos, err = db.loadIndexSnapshot(); err != nil {
+				return err
+			}
+		} else if err := db.removeIndexSnapshot(); err != nil {
+			return err
+		}
+
 		// 从 hint file 加载索引
-		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+		if snapshotPos == nil {
+			if err := db.loadIndexFromHintFile(); err != nil {
+				return err
+			}
 		}
 
 		// 从数据文件中构建索引
-		if err := db.loadIndexFromDataFile(); err != nil {
-			return nil, err
+		if err := db.loadIndexFromDataFile(snapshotPos); err != nil {
+			return err
 		}
+	}
 
-		// 重置 IO 类型为 标准文件IO
-		if db.options.MMapAtStartup {
-			if err := db.resetIOType(); err != nil {
-				return nil, err
-			}
+	// 重置 IO 类型为 标准文件IO
+	if db.options.MMapAtStartup {
+		if err := db.resetIOType(); err != nil {
+			return err
 		}
 	}
 
-	return db, nil
+	// 记录 B+ 树索引加载完成之后的水位，下次启动时不再重放已经加载的数据
+	if err := db.saveWatermark(); err != nil {
+		return err
+	}
+	db.indexLoaded = true
+	return nil
 }
 
 // Close 关闭数据库
@@ -110,11 +238,63 @@ func (db *DB) Close() error {
 		}
 	}()
 
-	if db.activeFile != nil {
-		return nil
+	// 等待后台任务退出，后台任务可能会用到互斥锁
+	select {
+	case <-db.closeCh:
+	default:
+		close(db.closeCh)
 	}
+	// 唤醒等待迭代器关闭的 merge，merge 的结果在下次启动时生效
 	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.itersClosed.Broadcast()
+	db.mu.Unlock()
+	db.bgWg.Wait()
+
+	db.lock()
+	defer db.unlock()
+
+	// 等待 hint 文件生成完成
+	db.hintWg.Wait()
+
+	// 释放仍然存活的快照，否则 B+ 树索引无法关闭
+	for snap := range db.snapshots {
+		if err := snap.release(); err != nil {
+			return err
+		}
+	}
+
+	// 写入索引快照，下次启动时不需要重新加载所有的数据
+	if db.indexLoaded && db.options.IndexSnapshot && db.options.IndexType != BPlusTree &&
+		db.activeFile != nil && !db.isMerging {
+		idx, meta, err := db.prepareIndexSnapshot()
+		if err != nil {
+			return err
+		}
+		if err := db.saveIndexSnapshot(idx, meta); err != nil {
+			return err
+		}
+	}
+
+	// 记录 B+ 树索引的水位，下次启动时只需要重放之后写入的数据
+	if db.indexLoaded {
+		if err := db.saveWatermark(); err != nil {
+			return err
+		}
+	}
+
+	// 关闭索引
+	if err := db.index.Close(); err != nil {
+		return err
+	}
+
+	// 关闭 value log 文件
+	if err := db.closeValueLogFiles(); err != nil {
+		return err
+	}
+
+	if db.activeFile == nil {
+		return nil
+	}
 
 	// 关闭当前活跃文件
 	if err := db.activeFile.Close(); err != nil {
@@ -127,6 +307,10 @@ func (db *DB) Close() error {
 			return err
 		}
 	}
+
+	// 清空文件引用，避免重复关闭
+	db.activeFile = nil
+	db.oldFiles = make(map[uint32]*data.DataFile)
 	return nil
 }
 
@@ -135,11 +319,47 @@ func (db *DB) Sync() error {
 	if db.activeFile == nil {
 		return nil
 	}
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.lock()
+	defer db.unlock()
+	if db.activeVlog != nil {
+		if err := db.activeVlog.Sync(); err != nil {
+			return err
+		}
+	}
 	return db.activeFile.Sync()
 }
 
+// Stat 返回数据库的相关统计信息
+func (db *DB) Stat() (*Stat, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dataFiles := uint(len(db.oldFiles))
+	var activeFileId uint32
+	if db.activeFile != nil {
+		dataFiles += 1
+		activeFileId = db.activeFile.FileId
+	}
+
+	dirSize, err := utils.DirSize(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	stat := &Stat{
+		KeyNum:          uint(db.index.Size()),
+		DataFileNum:     dataFiles,
+		ReclaimableSize: db.reclaimableSize(),
+		DiskSize:        dirSize,
+		ActiveFileId:    activeFileId,
+		ValueLogFileNum: db.valueLogFileNum(),
+	}
+	if db.cache != nil {
+		stat.CacheHits = db.cache.hits.Load()
+		stat.CacheMisses = db.cache.misses.Load()
+	}
+	return stat, nil
+}
+
 // Backup 备份数据库, 将数据文件拷贝到新的目录
 func (db *DB) Backup(dir string) error {
 	db.mu.RLock()
@@ -150,6 +370,19 @@ func (db *DB) Backup(dir string) error {
 
 // Put 写入Key-Value数据，key不能为空
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.put(key, value, 0)
+}
+
+// PutWithTTL 写入Key-Value数据，并在 ttl 之后自动过期
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return db.put(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// put 写入Key-Value数据，expire 为 0 表示永不过期
+func (db *DB) put(key []byte, value []byte, expire int64) error {
 	// 判断key是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
@@ -157,23 +390,40 @@ func (db *DB) Put(key []byte, value []byte) error {
 
 	// 构造LogRecord结构体
 	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
+	}
+
+	// 写入数据文件和更新内存索引需要在同一个临界区中，
+	// 否则并发写同一个 key 时索引可能指向较旧的数据；分片索引使用 key 锁保证同一个 key 的顺序
+	if db.keyLocks != nil {
+		defer db.lockKey(key)()
 	}
+	db.mu.Lock()
 
 	// 追加写入到活跃文件
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
+		db.mu.Unlock()
 		return err
 	}
 
-	// 更新内存索引
-	if ok := db.index.Put(key, pos); !ok {
-		return ErrIndexUpdateFaild
+	if db.keyLocks != nil {
+		// 持有 key 锁时其他写入不会修改这个 key 的索引，被覆盖的旧数据可以被回收
+		db.markModified(key)
+		db.addStaleReclaimable(db.index.Get(key))
+		db.updateIndexUnlocked(func() { db.index.Put(key, pos) })
+		return nil
 	}
+	defer db.mu.Unlock()
 
-	return nil
+	// 更新内存索引，被覆盖的旧数据可以被回收
+	db.markModified(key)
+	return db.updateIndex(func(idx indexWriter) {
+		db.addStaleReclaimable(idx.Put(key, pos))
+	})
 }
 
 // Delete 根据key删除对应的数据
@@ -183,8 +433,15 @@ func (db *DB) Delete(key []byte) error {
 		return ErrKeyIsEmpty
 	}
 
+	if db.keyLocks != nil {
+		defer db.lockKey(key)()
+	}
+	db.mu.Lock()
+
 	// 检查key是否存在，不存在直接返回
-	if pos := db.index.Get(key); pos == nil {
+	oldPos := db.index.Get(key)
+	if oldPos == nil {
+		db.mu.Unlock()
 		return nil
 	}
 
@@ -194,17 +451,31 @@ func (db *DB) Delete(key []byte) error {
 		Type: data.LogRecordDelete,
 	}
 	// 写入数据文件
-	_, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+
+	// 被删除的数据和删除标记本身都可以被回收
+	db.markModified(key)
+	db.addStaleReclaimable(oldPos)
+	db.addReclaimable(pos)
+
+	if db.keyLocks != nil {
+		db.updateIndexUnlocked(func() { db.index.Delete(key) })
 		return nil
 	}
+	defer db.mu.Unlock()
 
 	// 删除对应key的内存索引
-	ok := db.index.Delete(key)
+	var ok bool
+	if err := db.updateIndex(func(idx indexWriter) { ok = idx.Delete(key) }); err != nil {
+		return err
+	}
 	if !ok {
 		return ErrIndexUpdateFaild
 	}
-
 	return nil
 }
 
@@ -215,9 +486,13 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 		return nil, ErrKeyIsEmpty
 	}
 
+	// merge 替换数据文件期间不能读取
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	// 从内存索引中取出 key对应的的内存索引信息
 	logRecordPos := db.index.Get(key)
-	if logRecordPos == nil {
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
 		return nil, ErrKeyNotFound
 	}
 
@@ -225,14 +500,17 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.getValueByPosition(logRecordPos)
 }
 
-// ListKey 获取数据库中所有的 key
+// ListKey 获取数据库中所有的 key，已过期的 key 不会返回
 func (db *DB) ListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	defer iterator.Close()
+	keys := make([][]byte, 0, db.index.Size())
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
@@ -243,7 +521,13 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	defer db.mu.RUnlock()
 
 	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		// 跳过已过期的数据
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
 		value, err := db.getValueByPosition(iterator.Value())
 		if err != nil {
 			return err
@@ -257,38 +541,76 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 
 // 根据索引信息获取对应的 value
 func (db *DB) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
-	// 根据文件id找到对应数据文件
-	var dataFile *data.DataFile
-	if db.activeFile.FileId == pos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.oldFiles[pos.Fid]
-	}
-
-	// 数据文件为空
-	if dataFile == nil {
-		return nil, ErrDataFileNotFound
+	if db.cache != nil {
+		if value, ok := db.cache.get(pos); ok {
+			return value, nil
+		}
 	}
 
-	// 根据偏移量读取对应的数据
-	logRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
+	logRecord, err := db.readLogRecord(pos)
 	if err != nil {
 		return nil, err
 	}
+	return db.getValueByLogRecord(pos, logRecord)
+}
 
+// getValueByLogRecord 根据已经读取的 LogRecord 获取完整的 value
+func (db *DB) getValueByLogRecord(pos *data.LogRecordPos, logRecord *data.LogRecord) ([]byte, error) {
 	// 判断数据是否已被删除
 	if logRecord.Type == data.LogRecordDelete {
 		return nil, ErrKeyNotFound
 	}
 
+	// 流式写入的 value 需要读取每段数据
+	if logRecord.Type == data.LogRecordManifest {
+		manifest, err := decodeStreamManifest(logRecord.Value)
+		if err != nil {
+			return nil, err
+		}
+		return db.readStreamValue(manifest)
+	}
+
+	// 从 value log 文件中读取 value
+	if logRecord.ValuePointer {
+		var err error
+		if logRecord, err = db.readValueLog(data.DecodeLogRecordPos(logRecord.Value)); err != nil {
+			return nil, err
+		}
+	}
+
+	// 解压 value
+	if err := db.decompressLogRecord(logRecord); err != nil {
+		return nil, err
+	}
+
+	// 放入缓存，流式写入的 value 较大，不会缓存
+	if db.cache != nil {
+		db.cache.put(pos, logRecord.Value)
+	}
 	return logRecord.Value, nil
 }
 
-// appendLogRecordWithLock 加锁版本的追加写入数据到活跃文件
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+// readLogRecord 根据索引信息读取数据文件中对应的记录
+func (db *DB) readLogRecord(pos *data.LogRecordPos) (*data.LogRecord, error) {
+	// 根据文件id找到对应数据文件
+	dataFile := db.getDataFile(pos.Fid)
+
+	// 数据文件为空
+	if dataFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+
+	// 根据偏移量读取对应的数据
+	logRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
+	return logRecord, err
+}
+
+// getDataFile 根据文件 id 找到对应的数据文件，不存在时返回空
+func (db *DB) getDataFile(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return db.activeFile
+	}
+	return db.oldFiles[fid]
 }
 
 // appendLogRecord 追加写入数据到活跃文件
@@ -301,7 +623,22 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
+	// 较大的 value 写入 value log 文件
+	logRecord, err := db.separateValue(logRecord)
+	if err != nil {
+		return nil, err
+	}
+
 	// 数据编码
+	// 按照配置压缩 value，压缩之后再加密
+	logRecord, err = db.compressLogRecord(logRecord)
+	if err != nil {
+		return nil, err
+	}
+	if db.cipher != nil {
+		logRecord = db.cipher.Seal(logRecord)
+	}
+
 	// 如果写入数据编码已经到达活跃文件的阈值，关闭活跃文件，打开新的活跃文件
 	encRecord, size := data.EncodeLogRecord(logRecord)
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
@@ -310,8 +647,9 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 			return nil, err
 		}
 
-		// 当前活跃文件转化成旧的数据文件
+		// 当前活跃文件转化成旧的数据文件，并在后台生成 hint 文件
 		db.oldFiles[db.activeFile.FileId] = db.activeFile
+		db.startHintWriter(db.activeFile.FileId)
 
 		// 打开新的数据文件
 		if err := db.setActiveDataFile(); err != nil {
@@ -342,10 +680,61 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	// 构造内存索引信息
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff}
+	pos := &data.LogRecordPos{
+		Fid:    db.activeFile.FileId,
+		Offset: writeOff,
+		Size:   uint32(size),
+		Expire: logRecord.Expire,
+	}
 	return pos, nil
 }
 
+// lock 获取互斥锁，并等待在互斥锁之外进行的分片索引更新完成
+func (db *DB) lock() {
+	db.mu.Lock()
+	db.indexMu.Lock()
+}
+
+// unlock 释放 lock 获取的锁
+func (db *DB) unlock() {
+	db.indexMu.Unlock()
+	db.mu.Unlock()
+}
+
+// lockKey 获取 key 对应的锁，返回释放锁的函数，使用 FNV-1a 哈希
+func (db *DB) lockKey(key []byte) func() {
+	var hash uint32 = 2166136261
+	for _, b := range key {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	mu := &db.keyLocks[hash%uint32(len(db.keyLocks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// updateIndexUnlocked 释放互斥锁之后再更新分片索引，不同分片的更新可以并发进行
+// 更新期间持有 indexMu 的读锁，之后获取互斥锁的操作会等待更新完成
+// 在使用此方法前必须持有互斥锁
+func (db *DB) updateIndexUnlocked(update func()) {
+	db.indexMu.RLock()
+	db.mu.Unlock()
+	defer db.indexMu.RUnlock()
+	update()
+}
+
+// addReclaimable 记录 pos 对应的数据已经失效，可以在 merge 时回收
+// 在使用此方法前必须持有互斥锁
+func (db *DB) addReclaimable(pos *data.LogRecordPos) {
+	if pos == nil {
+		return
+	}
+	db.reclaimable[pos.Fid] += int64(pos.Size)
+	if db.dirtyFids != nil {
+		db.dirtyFids[pos.Fid] = struct{}{}
+	}
+}
+
 // setActiveDataFile 设置当前活跃文件
 // 在使用此方法前必须持有互斥锁
 func (db *DB) setActiveDataFile() error {
@@ -359,6 +748,7 @@ func (db *DB) setActiveDataFile() error {
 	if err != nil {
 		return err
 	}
+	dataFile.Cipher = db.cipher
 	// 更新当前文件为活跃文件
 	db.activeFile = dataFile
 	return nil
@@ -372,6 +762,33 @@ func checkOptions(options Options) error {
 	if options.DataFileSize <= 0 {
 		return errors.New("database data file size must be greater than zero")
 	}
+	if options.MergeRatio < 0 || options.MergeRatio > 1 {
+		return errors.New("invalid merge ratio, must between 0 and 1")
+	}
+	if options.Compression > CustomCompression {
+		return errors.New("invalid compression type")
+	}
+	if options.Compression == CustomCompression && options.Compressor == nil {
+		return errors.New("compressor must be set when using custom compression")
+	}
+	if len(options.OldEncryptionKeys) > 0 && len(options.EncryptionKey) == 0 {
+		return errors.New("encryption key must be set when using old encryption keys")
+	}
+	if options.ValueLogThreshold < 0 {
+		return errors.New("value log threshold must not be negative")
+	}
+	if options.ValueLogThreshold > 0 && options.ValueLogFileSize <= 0 {
+		return errors.New("value log file size must be greater than zero")
+	}
+	if options.ValueLogGCRatio < 0 || options.ValueLogGCRatio > 1 {
+		return errors.New("invalid value log gc ratio, must between 0 and 1")
+	}
+	if options.ValueCacheSize < 0 {
+		return errors.New("value cache size must not be negative")
+	}
+	if options.IndexSnapshotInterval < 0 {
+		return errors.New("index snapshot interval must not be negative")
+	}
 	return nil
 }
 
@@ -412,6 +829,7 @@ func (db *DB) loadDataFile() error {
 		if err != nil {
 			return err
 		}
+		dataFile.Cipher = db.cipher
 		if i == len(fileIds)-1 { // 当前是活跃文件
 			db.activeFile = dataFile
 		} else { // 当前是旧的文件
@@ -422,16 +840,16 @@ func (db *DB) loadDataFile() error {
 }
 
 // loadIndexFromDataFile 从数据文件中构建索引
-// 遍历数据文件中的所有记录，更新到内存索引
-func (db *DB) loadIndexFromDataFile() error {
+// 遍历数据文件中的所有记录，更新到内存索引；snapshotPos 不为空时只加载索引快照之后写入的数据
+func (db *DB) loadIndexFromDataFile(snapshotPos *data.LogRecordPos) error {
 	// 数据库是空的，直接返回
 	if len(db.fileIds) == 0 {
 		return nil
 	}
-	//  查看是否发生过 merge
+	//  查看是否发生过 merge，从索引快照加载时不需要
 	hasMerge, nonMergeFileId := false, uint32(0)
 	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
-	if _, err := os.Stat(mergeFinFileName); err == nil {
+	if _, err := os.Stat(mergeFinFileName); err == nil && snapshotPos == nil {
 		fid, err := db.getNonMergeFileId(db.options.DirPath)
 		if err != nil {
 			return err
@@ -440,66 +858,88 @@ func (db *DB) loadIndexFromDataFile() error {
 		nonMergeFileId = fid
 	}
 
+	now := time.Now().UnixNano()
 	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var ok bool
-		if typ == data.LogRecordDelete {
-			ok = db.index.Delete(key)
-		} else {
-			ok = db.index.Put(key, pos)
+		// 流式写入的每段数据由清单引用，不加入索引
+		if typ == data.LogRecordChunk {
+			return
+		}
+		// 已过期的数据等同于被删除
+		if typ == data.LogRecordDelete || pos.IsExpired(now) {
+			db.addStaleReclaimable(db.index.Get(key))
+			db.index.Delete(key)
+			// 删除标记本身也可以被回收
+			if typ == data.LogRecordDelete {
+				db.addReclaimable(pos)
+			}
+			return
 		}
-		if !ok {
-			panic("failed to update index at startup")
+		// 重放已经更新到 B+ 树索引中的数据时，旧的位置就是这条数据本身，不能计入可回收空间
+		if oldPos := db.index.Put(key, pos); oldPos == nil || oldPos.Fid != pos.Fid || oldPos.Offset != pos.Offset {
+			db.addStaleReclaimable(oldPos)
 		}
 	}
 
 	// 暂存事务数据
 	transcationRecords := make(map[uint64][]*data.TranscationRecord)
-	var currentSeqNo = nonTransactionSeqNo
+	var currentSeqNo = db.seqNo
 
-	// 遍历所有的文件id，处理文件中的记录
-	for i, fid := range db.fileIds {
+	// 找出需要加载的数据文件
+	var dataFiles []*data.DataFile
+	for _, fid := range db.fileIds {
 		var fileId = uint32(fid)
 		// 如果最近未参与 merge 的文件id更小，则说明已经从 hint file加载索引了
 		if hasMerge && fileId < nonMergeFileId {
 			continue
 		}
-		var dataFile *data.DataFile
+		// 索引快照已经包含了之前的数据文件
+		if snapshotPos != nil && fileId < snapshotPos.Fid {
+			continue
+		}
 		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
+			dataFiles = append(dataFiles, db.activeFile)
 		} else {
-			dataFile = db.oldFiles[fileId]
+			dataFiles = append(dataFiles, db.oldFiles[fileId])
 		}
+	}
 
-		var offset int64 = 0
-		// 处理每个文件中的数据项
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
+	// 并发读取数据文件，按照文件 id 从小到大的顺序更新索引
+	results := db.readIndexRecordsConcurrently(dataFiles, snapshotPos)
+	defer results.stop()
+
+	for i, dataFile := range dataFiles {
+		result := results.wait(i)
+		isActiveFile := dataFile == db.activeFile
+		if result.err != nil {
+			if err := db.recoverDataFile(dataFile, isActiveFile, result); err != nil {
 				return err
 			}
+		}
+		if !result.fromHint && !isActiveFile {
+			db.startHintWriter(dataFile.FileId)
+		}
 
-			// 构造内存索引并保存
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset}
+		// 处理每个文件中的数据项，事务的数据可能跨越多个文件
+		for _, record := range result.records {
+			logRecordPos := record.pos
 
 			// 解析 key，拿到事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
+			realKey, seqNo := parseLogRecordKey(record.key)
 			if seqNo == nonTransactionSeqNo {
 				// 非事务提交，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
+				updateIndex(realKey, record.typ, logRecordPos)
 			} else {
 				// 事务完成，对应的 seq no 的数据可以更新到内存索引中
-				if logRecord.Type == data.LogRecordTxnFinished {
+				if record.typ == data.LogRecordTxnFinished {
 					for _, txnRecord := range transcationRecords[seqNo] {
 						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
 					}
 					delete(transcationRecords, seqNo)
+					// 事务完成标记本身也可以被回收
+					db.addReclaimable(logRecordPos)
 				} else {
-					logRecord.Key = realKey
 					transcationRecords[seqNo] = append(transcationRecords[seqNo], &data.TranscationRecord{
-						Record: logRecord,
+						Record: &data.LogRecord{Key: realKey, Type: record.typ},
 						Pos:    logRecordPos,
 					})
 				}
@@ -509,15 +949,13 @@ func (db *DB) loadIndexFromDataFile() error {
 			if seqNo > currentSeqNo {
 				currentSeqNo = seqNo
 			}
-
-			// 递增offset，下一次从新的位置开始读取
-			offset += size
 		}
 
 		// 如果当前是活跃文件，更新这个文件的WriteOff
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		if isActiveFile {
+			db.activeFile.WriteOff = result.offset
 		}
+		results.done()
 	}
 
 	// 更新事务序列号
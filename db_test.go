@@ -1,9 +1,13 @@
 package gobitcask
 
 import (
+	"bytes"
 	"go-bitcask/utils"
 	"os"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -347,3 +351,250 @@ func TestDB_Backup(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db2)
 }
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// ttl 不合法
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(10), 0)
+	assert.Equal(t, ErrInvalidTTL, err)
+
+	// 未过期的数据可以正常读取
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(10), time.Hour)
+	assert.Nil(t, err)
+	value1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, value1)
+
+	// 过期之后读取不到
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(10), 50*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(10))
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, len(db.ListKeys()))
+
+	var folded int
+	err = db.Fold(func(key, value []byte) bool {
+		folded++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, folded)
+
+	// 重新 Put 之后不再过期
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+
+	// 重启之后过期时间依然有效
+	err = db.PutWithTTL(utils.GetTestKey(4), utils.RandomValue(10), 50*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+	value2, err := db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value1, value2)
+}
+
+func TestDB_Stat(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-stat")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1000), stat.KeyNum)
+	assert.True(t, stat.DataFileNum > 1)
+	assert.Equal(t, uint32(stat.DataFileNum-1), stat.ActiveFileId)
+	assert.Equal(t, int64(0), stat.ReclaimableSize)
+	assert.True(t, stat.DiskSize > 0)
+
+	// 覆盖写、删除和批量写都会产生可回收的数据
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(128)))
+	stat1, err := db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat1.ReclaimableSize > stat.ReclaimableSize)
+
+	assert.Nil(t, db.Delete(utils.GetTestKey(2)))
+	stat2, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(999), stat2.KeyNum)
+	assert.True(t, stat2.ReclaimableSize > stat1.ReclaimableSize)
+
+	wb := db.NewWriteBtach(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(3), utils.RandomValue(128)))
+	assert.Nil(t, wb.Commit())
+	stat3, err := db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat3.ReclaimableSize > stat2.ReclaimableSize)
+}
+
+func TestDB_ARTIndex(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-art")
+	opts.DirPath = dir
+	opts.IndexType = ART
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(10)))
+	assert.Equal(t, 999, len(db.ListKeys()))
+
+	iter := db.NewIterator(IteratorOptions{Reverse: true})
+	iter.Rewind()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, utils.GetTestKey(999), iter.Key())
+	iter.Close()
+
+	// 重启之后重新构建索引
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	value, err := db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.NotNil(t, value)
+}
+
+func TestDB_ShardedBTreeIndex(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded-index")
+	opts.DirPath = dir
+	opts.IndexType = ShardedBTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(10)))
+	assert.Equal(t, 999, len(db.ListKeys()))
+
+	// 不同分片中的 key 合并之后仍然有序
+	iter := db.NewIterator(DefaultIteratorOption)
+	var prev []byte
+	var n int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.True(t, bytes.Compare(prev, iter.Key()) < 0)
+		prev = iter.Key()
+		n++
+	}
+	iter.Close()
+	assert.Equal(t, 999, n)
+
+	iter = db.NewIterator(IteratorOptions{Reverse: true})
+	iter.Rewind()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, utils.GetTestKey(999), iter.Key())
+	iter.Seek(utils.GetTestKey(10))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, utils.GetTestKey(9), iter.Key())
+	iter.Close()
+
+	// 重启之后重新构建索引
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 999, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	value, err := db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.NotNil(t, value)
+}
+
+func TestDB_ShardedBTree_ConcurrentWrite(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexType = ShardedBTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 并发写入、删除相同的 key，同时进行批量写入和遍历
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := utils.GetTestKey(i % 50)
+				switch i % 10 {
+				case 3:
+					assert.Nil(t, db.Delete(key))
+				case 7:
+					wb := db.NewWriteBtach(DefaultWriteBatchOptions)
+					assert.Nil(t, wb.Put(key, []byte(strconv.Itoa(g))))
+					assert.Nil(t, wb.Commit())
+				case 9:
+					iter := db.NewIterator(DefaultIteratorOption)
+					for iter.Rewind(); iter.Valid(); iter.Next() {
+					}
+					iter.Close()
+				default:
+					assert.Nil(t, db.Put(key, []byte(strconv.Itoa(g))))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// 索引指向每个 key 最后写入的数据，重新启动之后从数据文件中得到相同的结果
+	values := make(map[string][]byte)
+	for i := 0; i < 50; i++ {
+		value, err := db.Get(utils.GetTestKey(i))
+		if err == nil {
+			values[string(utils.GetTestKey(i))] = value
+		} else {
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+	}
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(values), len(db.ListKeys()))
+	for key, value := range values {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	stat2, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, stat.ReclaimableSize, stat2.ReclaimableSize)
+}
@@ -0,0 +1,80 @@
+package gobitcask
+
+import (
+	"bytes"
+	"go-bitcask/data"
+	"go-bitcask/fio"
+	"os"
+	"path/filepath"
+)
+
+// 校验文件中使用密钥加密的已知内容
+var keyCheckValue = []byte("bitcask-encryption-key-check")
+
+// checkEncryptionKey 使用数据目录中的校验文件检查配置的密钥
+// 从 B+ 树索引、索引快照中加载索引，或者只有 value log 文件时，启动时不会解密任何记录，
+// 错误的密钥只能通过校验文件发现。没有校验文件时使用当前的密钥创建
+func (db *DB) checkEncryptionKey() error {
+	fileName := filepath.Join(db.options.DirPath, data.KeyCheckFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		if db.cipher == nil {
+			return nil
+		}
+		return db.writeKeyCheckFile()
+	} else if err != nil {
+		return err
+	}
+	if db.cipher == nil {
+		return ErrEncryptionKeyRequired
+	}
+
+	checkFile, err := data.OpenKeyCheckFile(fileName, fio.MemoryMap)
+	if err != nil {
+		return err
+	}
+	defer checkFile.Close()
+	checkFile.Cipher = db.cipher
+	logRecord, _, err := checkFile.ReadLogRecord(checkFile.HeaderSize())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(logRecord.Value, keyCheckValue) {
+		return ErrInvalidEncryptionKey
+	}
+
+	// 校验文件由轮换之前的旧密钥加密时，使用当前的密钥重新写入，之后写入的数据只能使用当前的密钥读取
+	if len(db.options.OldEncryptionKeys) > 0 {
+		current, err := data.NewCipher(db.options.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		checkFile.Cipher = current
+		if _, _, err := checkFile.ReadLogRecord(checkFile.HeaderSize()); err == ErrInvalidEncryptionKey {
+			return db.writeKeyCheckFile()
+		}
+	}
+	return nil
+}
+
+// writeKeyCheckFile 使用当前的密钥写入校验文件，完整写入临时文件之后再替换
+func (db *DB) writeKeyCheckFile() error {
+	fileName := filepath.Join(db.options.DirPath, data.KeyCheckFileName)
+	tmpFileName := fileName + ".tmp"
+	if err := os.Remove(tmpFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	checkFile, err := data.OpenKeyCheckFile(tmpFileName, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer checkFile.Close()
+
+	encRecord, _ := data.EncodeLogRecord(db.cipher.Seal(&data.LogRecord{Value: keyCheckValue}))
+	if err := checkFile.Write(encRecord); err != nil {
+		return err
+	}
+	if err := checkFile.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
@@ -0,0 +1,181 @@
+package gobitcask
+
+import (
+	"bytes"
+	"errors"
+	"go-bitcask/data"
+	"go-bitcask/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.EncryptionKey = bytes.Repeat([]byte("k"), 32)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := []byte("plaintext-value")
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	activeFile := data.GetDatafleName(dir, db.activeFile.FileId)
+	assert.Nil(t, db.Close())
+
+	// 数据文件和 hint 文件中都不包含明文
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		buf, err := os.ReadFile(dir + "/" + entry.Name())
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(buf, value), entry.Name())
+		assert.False(t, bytes.Contains(buf, utils.GetTestKey(1)), entry.Name())
+	}
+	info, err := os.Stat(activeFile)
+	assert.Nil(t, err)
+
+	// 使用错误的密钥或者没有密钥时无法打开，数据不会被截断
+	wrongOpts := opts
+	wrongOpts.EncryptionKey = bytes.Repeat([]byte("x"), 32)
+	_, err = Open(wrongOpts)
+	assert.True(t, errors.Is(err, ErrInvalidEncryptionKey))
+	wrongOpts.EncryptionKey = nil
+	_, err = Open(wrongOpts)
+	assert.True(t, errors.Is(err, ErrEncryptionKeyRequired))
+	info2, err := os.Stat(activeFile)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Size(), info2.Size())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 299, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+}
+
+func TestDB_Encryption_KeyRotation(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-key-rotation")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	oldKey := bytes.Repeat([]byte("o"), 16)
+	opts.EncryptionKey = oldKey
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 轮换密钥，旧数据使用旧密钥读取，merge 之后使用新密钥重新加密
+	opts.EncryptionKey = bytes.Repeat([]byte("n"), 32)
+	opts.OldEncryptionKeys = [][]byte{oldKey}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 300; i < 400; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	opts.OldEncryptionKeys = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 400, len(db.ListKeys()))
+	for i := 0; i < 400; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	opts.EncryptionKey = nil
+	opts.OldEncryptionKeys = [][]byte{oldKey}
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_Encryption_KeyCheck(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), 32)
+	wrongKey := bytes.Repeat([]byte("w"), 32)
+	tests := []struct {
+		name  string
+		setup func(opts *Options)
+		write bool
+	}{
+		{"empty", func(opts *Options) {}, false},
+		{"bptree with watermark", func(opts *Options) { opts.IndexType = BPlusTree }, true},
+		{"index snapshot", func(opts *Options) { opts.IndexSnapshot = true }, true},
+		{"value log only", func(opts *Options) { opts.ValueLogThreshold = 1 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOption
+			dir, _ := os.MkdirTemp("", "bitcask-go-encryption-check")
+			defer os.RemoveAll(dir)
+			opts.DirPath = dir
+			tt.setup(&opts)
+			opts.EncryptionKey = key
+			db, err := Open(opts)
+			assert.Nil(t, err)
+			if tt.write {
+				for i := 0; i < 10; i++ {
+					assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+				}
+			}
+			assert.Nil(t, db.Close())
+
+			// 启动时不需要解密任何记录，错误的密钥也要在 Open 时返回错误
+			opts.EncryptionKey = wrongKey
+			_, err = Open(opts)
+			assert.Equal(t, ErrInvalidEncryptionKey, err)
+			opts.EncryptionKey = nil
+			_, err = Open(opts)
+			assert.Equal(t, ErrEncryptionKeyRequired, err)
+
+			opts.EncryptionKey = key
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			assert.Nil(t, db.Close())
+		})
+	}
+}
+
+func TestDB_Encryption_KeyCheckRotation(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-check-rotation")
+	defer os.RemoveAll(dir)
+	opts.DirPath = dir
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	opts.EncryptionKey = oldKey
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// 轮换之后校验文件使用新密钥重新写入，只配置旧密钥无法打开
+	opts.EncryptionKey = bytes.Repeat([]byte("n"), 32)
+	opts.OldEncryptionKeys = [][]byte{oldKey}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	opts.OldEncryptionKeys = nil
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	opts.EncryptionKey = oldKey
+	_, err = Open(opts)
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+}
@@ -1,6 +1,9 @@
 package gobitcask
 
-import "errors"
+import (
+	"errors"
+	"go-bitcask/data"
+)
 
 var (
 	ErrKeyIsEmpty             = errors.New("the key is empty")
@@ -11,4 +14,19 @@ var (
 	ErrExceedMaxBatchNum      = errors.New("exceed the max batch num")
 	ErrMergeIsProgress        = errors.New("merge is in progress, try again later")
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
+	ErrInvalidTTL             = errors.New("the ttl must be greater than zero")
+	ErrSnapshotIsAlive        = errors.New("data files are still referenced by live snapshots")
+	ErrSnapshotReleased       = errors.New("the snapshot has been released")
+	ErrTxnConflict            = errors.New("transaction conflict, keys read were modified by others")
+	ErrTxnClosed              = errors.New("the transaction has been committed or rolled back")
+	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrDataFileCorrupted      = errors.New("the data file is corrupted")
+	ErrUnknownCompression     = errors.New("unknown compression type or compressor not set")
+	ErrEncryptionKeyRequired  = data.ErrEncryptionKeyRequired
+	ErrInvalidEncryptionKey   = data.ErrInvalidEncryptionKey
+	ErrInvalidValueSize       = errors.New("the value size must not be negative")
+	ErrInvalidStreamManifest  = errors.New("the manifest of the streamed value is invalid")
+	ErrInvalidRecordType      = errors.New("unknown type of the exported record")
+	ErrStreamIsWriting        = errors.New("streamed values are being written, try again later")
+	ErrReaderClosed           = errors.New("the value reader has been closed")
 )
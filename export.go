@@ -0,0 +1,111 @@
+package gobitcask
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-bitcask/data"
+	"io"
+	"time"
+)
+
+// 导出数据的类型
+const (
+	exportTypeNormal = "normal" // 普通写入的数据
+	exportTypeStream = "stream" // 通过 PutReader 流式写入的数据，导入时同样分段写入
+)
+
+// exportRecord 导出数据的格式，每条数据占一行 JSON，key 和 value 使用 base64 编码
+type exportRecord struct {
+	Type   string `json:"type"`
+	Key    []byte `json:"key"`
+	Value  []byte `json:"value"`
+	Expire int64  `json:"expire,omitempty"` // 过期时间，Unix 纳秒时间戳，永不过期时省略
+}
+
+// Export 将所有未过期的数据以 JSON Lines 的格式写入 w
+// 导出基于快照进行，导出期间不会阻塞写操作
+func (db *DB) Export(w io.Writer) error {
+	snap := db.NewSnapshot()
+	defer snap.Release()
+
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	iterator := snap.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.IsExpired(snap.readTime) {
+			continue
+		}
+		db.mu.RLock()
+		logRecord, err := db.readLogRecord(pos)
+		var value []byte
+		if err == nil {
+			value, err = db.getValueByLogRecord(pos, logRecord)
+		}
+		db.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		record := &exportRecord{Type: exportTypeNormal, Key: iterator.Key(), Value: value, Expire: pos.Expire}
+		if logRecord.Type == data.LogRecordManifest {
+			record.Type = exportTypeStream
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Import 从 r 中读取 Export 导出的数据并写入数据库
+// 数据按照 WriteBatch 分批提交，每一批要么全部写入，要么全部不写入，
+// 出错时之前已经提交的批次仍然有效
+func (db *DB) Import(r io.Reader) error {
+	opts := DefaultWriteBatchOptions
+	opts.SyncWrites = false
+	wb := db.NewWriteBtach(opts)
+
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	now := time.Now().UnixNano()
+	var pending uint
+	for n := 1; ; n++ {
+		var record exportRecord
+		if err := decoder.Decode(&record); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("invalid record %d: %w", n, err)
+		}
+		// 导出之后已经过期的数据直接丢弃
+		if record.Expire > 0 && record.Expire <= now {
+			continue
+		}
+		// 流式写入的数据不能放在 WriteBatch 中，直接分段写入
+		if record.Type == exportTypeStream {
+			if err := db.PutReader(record.Key, bytes.NewReader(record.Value), int64(len(record.Value))); err != nil {
+				return fmt.Errorf("invalid record %d: %w", n, err)
+			}
+			continue
+		}
+		if record.Type != exportTypeNormal {
+			return fmt.Errorf("invalid record %d: %w", n, ErrInvalidRecordType)
+		}
+		if err := wb.put(record.Key, record.Value, record.Expire); err != nil {
+			return fmt.Errorf("invalid record %d: %w", n, err)
+		}
+		pending++
+		if pending >= opts.MaxBatchNum {
+			if err := wb.Commit(); err != nil {
+				return err
+			}
+			pending = 0
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+	return db.Sync()
+}
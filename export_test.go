@@ -0,0 +1,84 @@
+package gobitcask
+
+import (
+	"bytes"
+	"errors"
+	"go-bitcask/utils"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ExportImport(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-export")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 25000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, db.PutWithTTL([]byte("ttl-key"), []byte{0, 1, 2}, time.Hour))
+	assert.Nil(t, db.PutWithTTL([]byte("expired-key"), []byte("value"), time.Millisecond))
+	streamValue := utils.RandomValue(streamChunkSize + 100)
+	assert.Nil(t, db.PutReader([]byte("stream-key"), bytes.NewReader(streamValue), int64(len(streamValue))))
+	time.Sleep(5 * time.Millisecond)
+
+	var buf bytes.Buffer
+	assert.Nil(t, db.Export(&buf))
+	assert.Equal(t, 25001, strings.Count(buf.String(), "\n"))
+	assert.Equal(t, 25000, strings.Count(buf.String(), `"type":"normal"`))
+	assert.Equal(t, 1, strings.Count(buf.String(), `"type":"stream"`))
+
+	dir2, _ := os.MkdirTemp("", "bitcask-go-import")
+	opts.DirPath = dir2
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.Import(&buf))
+
+	assert.Equal(t, 25001, len(db2.ListKeys()))
+	err = db.Fold(func(key []byte, value []byte) bool {
+		val, err := db2.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+		return true
+	})
+	assert.Nil(t, err)
+	// 过期时间也会被导入
+	assert.Equal(t, db.index.Get([]byte("ttl-key")).Expire, db2.index.Get([]byte("ttl-key")).Expire)
+	_, err = db2.Get([]byte("expired-key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_Import_Invalid(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-import-invalid")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 出错的批次不会写入任何数据
+	input := `{"type":"normal","key":"a2V5LTE=","value":"dmFsdWUtMQ=="}
+{"type":"normal","key":"a2V5LTI=","value":"dmFsdWUtMg=="}
+{"type":"normal","key":"not base64!","value":"dmFsdWUtMw=="}
+`
+	err = db.Import(strings.NewReader(input))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(db.ListKeys()))
+
+	// 未知或者缺少类型的数据
+	for _, typ := range []string{`"type":"delete",`, ``} {
+		input = `{` + typ + `"key":"a2V5LTE=","value":"dmFsdWUtMQ=="}` + "\n"
+		err = db.Import(strings.NewReader(input))
+		assert.True(t, errors.Is(err, ErrInvalidRecordType))
+	}
+	assert.Equal(t, 0, len(db.ListKeys()))
+}
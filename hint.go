@@ -0,0 +1,156 @@
+package gobitcask
+
+import (
+	"go-bitcask/data"
+	"go-bitcask/fio"
+	"io"
+	"os"
+	"strconv"
+)
+
+// indexRecord 构建索引需要的记录信息，从数据文件或者 hint 文件中读取
+type indexRecord struct {
+	key []byte             // 带有事务序列号的 key
+	typ data.LogRecordType // 记录的类型
+	pos *data.LogRecordPos // 记录在数据文件中的位置
+}
+
+// readIndexRecords 从 offset 开始扫描数据文件，返回其中所有记录的索引信息以及文件中有效数据的末尾位置
+// 遇到损坏的记录时，返回损坏位置之前的记录以及对应的错误
+func readIndexRecords(dataFile *data.DataFile, offset int64) ([]*indexRecord, int64, error) {
+	var records []*indexRecord
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return records, offset, err
+		}
+		records = append(records, &indexRecord{
+			key: logRecord.Key,
+			typ: logRecord.Type,
+			pos: &data.LogRecordPos{
+				Fid:    dataFile.FileId,
+				Offset: offset,
+				Size:   uint32(size),
+				Expire: logRecord.Expire,
+			},
+		})
+		offset += size
+	}
+
+	// 有效数据之后还有剩余的字节，说明最后一条记录没有完整写入
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset < fileSize {
+		return records, offset, io.ErrUnexpectedEOF
+	}
+	return records, offset, nil
+}
+
+// readHintRecords 从数据文件对应的 hint 文件中读取索引信息
+// hint 文件不存在、已损坏或者与数据文件不一致时返回 false，需要扫描数据文件
+func (db *DB) readHintRecords(dataFile *data.DataFile) ([]*indexRecord, bool) {
+	hintFileName := data.GetHintFileName(db.options.DirPath, dataFile.FileId)
+	if _, err := os.Stat(hintFileName); err != nil {
+		return nil, false
+	}
+	dataSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, false
+	}
+	ioType := fio.StandardFIO
+	if db.options.MMapAtStartup {
+		ioType = fio.MemoryMap
+	}
+	hintFile, err := data.OpenDataHintFile(db.options.DirPath, dataFile.FileId, ioType)
+	if err != nil {
+		return nil, false
+	}
+	hintFile.Cipher = db.cipher
+	defer hintFile.Close()
+
+	var records []*indexRecord
+	var offset = hintFile.HeaderSize()
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			return nil, false
+		}
+		offset += size
+
+		// key 为空的是最后一条记录，保存了生成 hint 文件时数据文件的大小
+		if len(logRecord.Key) == 0 {
+			size, err := strconv.ParseInt(string(logRecord.Value), 10, 64)
+			if err != nil || size != dataSize {
+				return nil, false
+			}
+			return records, true
+		}
+		records = append(records, &indexRecord{
+			key: logRecord.Key,
+			typ: logRecord.Type,
+			pos: data.DecodeLogRecordPos(logRecord.Value),
+		})
+	}
+}
+
+// writeHintFile 为已经写满的数据文件生成 hint 文件，启动时不再需要扫描整个数据文件
+func (db *DB) writeHintFile(fileId uint32) error {
+	// 写满的数据文件不会再被修改，使用单独的内存映射读取，不影响数据文件的正常读写
+	dataFile, err := data.OpenDataFile(db.options.DirPath, fileId, fio.MemoryMap)
+	if err != nil {
+		return err
+	}
+	defer dataFile.Close()
+	dataFile.Cipher = db.cipher
+	records, dataSize, err := readIndexRecords(dataFile, dataFile.HeaderSize())
+	if err != nil {
+		return err
+	}
+
+	// 删除之前未写完的 hint 文件
+	hintFileName := data.GetHintFileName(db.options.DirPath, fileId)
+	if err := os.Remove(hintFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	hintFile, err := data.OpenDataHintFile(db.options.DirPath, fileId, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+	hintFile.Cipher = db.cipher
+
+	for _, record := range records {
+		if err := hintFile.WriteTypedHintRecord(record.key, record.typ, record.pos); err != nil {
+			return err
+		}
+	}
+	// 最后写入数据文件的大小，读取时据此判断 hint 文件是否完整
+	finRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Value: []byte(strconv.FormatInt(dataSize, 10)),
+	})
+	if err := hintFile.Write(finRecord); err != nil {
+		return err
+	}
+	return hintFile.Sync()
+}
+
+// startHintWriter 在后台为数据文件生成 hint 文件
+// B+ 树索引启动时只需要重放水位之后的数据，不需要 hint 文件
+func (db *DB) startHintWriter(fileId uint32) {
+	if db.options.IndexType == BPlusTree || db.noHintFiles {
+		return
+	}
+	db.hintWg.Add(1)
+	go func() {
+		defer db.hintWg.Done()
+		if err := db.writeHintFile(fileId); err != nil {
+			// 生成失败不影响正确性，启动时会回退到扫描数据文件
+			_ = os.Remove(data.GetHintFileName(db.options.DirPath, fileId))
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+package gobitcask
+
+import (
+	"go-bitcask/data"
+	"go-bitcask/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_HintFile(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	// 事务中的数据可能跨越多个数据文件
+	wb := db.NewWriteBtach(DefaultWriteBatchOptions)
+	for i := 100; i < 500; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte("batch-value")))
+	}
+	assert.Nil(t, wb.Commit())
+	reclaimable := db.reclaimableSize()
+	assert.Nil(t, db.Close())
+
+	// 每个写满的数据文件都有对应的 hint 文件
+	for fid := uint32(0); ; fid++ {
+		if _, err := os.Stat(data.GetDatafleName(dir, fid+1)); err != nil {
+			break
+		}
+		_, err := os.Stat(data.GetHintFileName(dir, fid))
+		assert.Nil(t, err)
+	}
+
+	check := func(db *DB) {
+		for i := 0; i < 1000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			if i < 100 {
+				assert.Equal(t, ErrKeyNotFound, err)
+				continue
+			}
+			assert.Nil(t, err)
+			if i < 500 {
+				assert.Equal(t, []byte("batch-value"), val)
+			}
+		}
+	}
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	check(db2)
+	assert.Equal(t, reclaimable, db2.reclaimableSize())
+	assert.Nil(t, db2.Close())
+
+	// hint 文件损坏时回退到扫描数据文件
+	assert.Nil(t, os.WriteFile(data.GetHintFileName(dir, 0), []byte("corrupted"), 0644))
+	assert.Nil(t, os.Remove(data.GetHintFileName(dir, 1)))
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	check(db3)
+	assert.Equal(t, reclaimable, db3.reclaimableSize())
+}
@@ -0,0 +1,652 @@
+package index
+
+import (
+	"bytes"
+	"go-bitcask/data"
+	"sync"
+)
+
+// 自适应基数树内部节点的类型，子节点数量增加时扩展为更大的节点，减少时收缩为更小的节点
+const (
+	artNode4 uint8 = iota
+	artNode16
+	artNode48
+	artNode256
+)
+
+// artChild 内部节点的子节点，为 *artLeaf 或 *artInner
+type artChild interface{}
+
+// artCow 写时复制的标记，节点只有被当前的树创建时才能原地修改
+// 大小为 0 的结构体分配的地址可能相同，因此需要包含一个字段
+type artCow struct {
+	_ byte
+}
+
+// artLeaf 叶子节点，保存完整的 key 和位置信息，创建之后不会再被修改
+type artLeaf struct {
+	key []byte
+	pos data.LogRecordPos // 直接保存位置信息，不需要为每个 key 单独分配
+}
+
+// artInner 内部节点
+type artInner struct {
+	kind     uint8
+	cow      *artCow
+	prefix   []byte     // 压缩的公共路径，底层数组不会被原地修改，可以在节点之间共享
+	leaf     *artLeaf   // 恰好在这个节点结束的 key
+	size     int        // 子节点的数量
+	keys     []byte     // node4、node16 中为有序的子节点字节；node48 中为每个字节对应的子节点下标加一
+	children []artChild // node4、node16 中与 keys 一一对应；node48 中为 48 个槽位；node256 中按字节下标
+}
+
+// AdaptiveRadixTree 自适应基数树索引
+// 内部节点按照子节点数量自适应大小，并且压缩了公共路径，相比 BTree 占用更少的内存；
+// 和 BTree 一样使用写时复制，创建快照和迭代器的开销是 O(1)
+type AdaptiveRadixTree struct {
+	root artChild
+	size int
+	cow  *artCow
+	lock *sync.RWMutex
+}
+
+// NewART 初始化自适应基数树索引
+func NewART() *AdaptiveRadixTree {
+	return &AdaptiveRadixTree{
+		cow:  new(artCow),
+		lock: new(sync.RWMutex),
+	}
+}
+
+// Put 向索引中存储key对应的数据位置信息，返回被覆盖的旧的位置信息
+func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	leaf := &artLeaf{key: key, pos: *pos}
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	var oldPos *data.LogRecordPos
+	art.root, oldPos = art.insert(art.root, leaf, 0)
+	if oldPos == nil {
+		art.size++
+	}
+	return oldPos
+}
+
+// Get 根据key取出对应的索引位置信息
+func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	ref, depth := art.root, 0
+	for ref != nil {
+		switch n := ref.(type) {
+		case *artLeaf:
+			if bytes.Equal(n.key, key) {
+				return &n.pos
+			}
+			return nil
+		case *artInner:
+			if !bytes.HasPrefix(key[depth:], n.prefix) {
+				return nil
+			}
+			depth += len(n.prefix)
+			if depth == len(key) {
+				if n.leaf == nil {
+					return nil
+				}
+				return &n.leaf.pos
+			}
+			ref = n.findChild(key[depth])
+			depth++
+		}
+	}
+	return nil
+}
+
+// Delete 根据key删除对应的索引位置信息
+func (art *AdaptiveRadixTree) Delete(key []byte) bool {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	root, ok := art.delete(art.root, key, 0)
+	if ok {
+		art.root = root
+		art.size--
+	}
+	return ok
+}
+
+// Size 索引中的数据大小
+func (art *AdaptiveRadixTree) Size() int {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	return art.size
+}
+
+// Snapshot 返回索引在当前时刻的只读视图
+// 之后两棵树修改共享的节点时都需要先复制，创建快照的开销是 O(1)
+func (art *AdaptiveRadixTree) Snapshot() Indexer {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	art.cow = new(artCow)
+	return &AdaptiveRadixTree{
+		root: art.root,
+		size: art.size,
+		cow:  new(artCow),
+		lock: new(sync.RWMutex),
+	}
+}
+
+// Close 关闭索引，内存索引无需释放资源
+func (art *AdaptiveRadixTree) Close() error {
+	return nil
+}
+
+// Iterator 返回索引迭代器
+// 迭代器遍历的是创建时刻的快照，不需要将所有的数据复制到数组中
+func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	art.cow = new(artCow)
+	iter := &artIterator{root: art.root, reverse: reverse}
+	iter.Rewind()
+	return iter
+}
+
+// insert 将叶子节点插入到 ref 中，返回插入之后的节点和被覆盖的旧的位置信息
+func (art *AdaptiveRadixTree) insert(ref artChild, leaf *artLeaf, depth int) (artChild, *data.LogRecordPos) {
+	key := leaf.key
+	switch n := ref.(type) {
+	case *artLeaf:
+		if bytes.Equal(n.key, key) {
+			return leaf, &n.pos
+		}
+		// 两个 key 的公共部分作为新节点的前缀
+		common := commonPrefixLen(n.key[depth:], key[depth:])
+		inner := art.newNode(artNode4, key[depth:depth+common])
+		art.place(inner, n, depth+common)
+		art.place(inner, leaf, depth+common)
+		return inner, nil
+	case *artInner:
+		common := commonPrefixLen(n.prefix, key[depth:])
+		if common < len(n.prefix) {
+			// 前缀不匹配时分裂，原来的节点保留剩余的前缀
+			prefix := n.prefix
+			inner := art.newNode(artNode4, prefix[:common])
+			child := art.mutable(n)
+			child.prefix = prefix[common+1:]
+			art.addChild(inner, prefix[common], child)
+			art.place(inner, leaf, depth+common)
+			return inner, nil
+		}
+		depth += len(n.prefix)
+		n = art.mutable(n)
+		if depth == len(key) {
+			old := n.leaf
+			n.leaf = leaf
+			if old == nil {
+				return n, nil
+			}
+			return n, &old.pos
+		}
+		c := key[depth]
+		if child := n.findChild(c); child != nil {
+			child, oldPos := art.insert(child, leaf, depth+1)
+			n.setChild(c, child)
+			return n, oldPos
+		}
+		return art.addChild(n, c, leaf), nil
+	default:
+		return leaf, nil
+	}
+}
+
+// place 将 depth 处之前与 n 的路径相同的叶子节点放入新创建的 n 中
+func (art *AdaptiveRadixTree) place(n *artInner, leaf *artLeaf, depth int) {
+	if len(leaf.key) == depth {
+		n.leaf = leaf
+		return
+	}
+	art.addChild(n, leaf.key[depth], leaf)
+}
+
+// delete 从 ref 中删除 key，返回删除之后的节点
+func (art *AdaptiveRadixTree) delete(ref artChild, key []byte, depth int) (artChild, bool) {
+	switch n := ref.(type) {
+	case *artLeaf:
+		if bytes.Equal(n.key, key) {
+			return nil, true
+		}
+		return n, false
+	case *artInner:
+		if !bytes.HasPrefix(key[depth:], n.prefix) {
+			return n, false
+		}
+		depth += len(n.prefix)
+		if depth == len(key) {
+			if n.leaf == nil {
+				return n, false
+			}
+			n = art.mutable(n)
+			n.leaf = nil
+			return art.shrink(n), true
+		}
+		c := key[depth]
+		child := n.findChild(c)
+		if child == nil {
+			return n, false
+		}
+		child, ok := art.delete(child, key, depth+1)
+		if !ok {
+			return n, false
+		}
+		n = art.mutable(n)
+		if child == nil {
+			n.removeChild(c)
+		} else {
+			n.setChild(c, child)
+		}
+		return art.shrink(n), true
+	default:
+		return nil, false
+	}
+}
+
+// newNode 创建属于当前树的内部节点
+func (art *AdaptiveRadixTree) newNode(kind uint8, prefix []byte) *artInner {
+	n := &artInner{kind: kind, cow: art.cow, prefix: prefix}
+	switch kind {
+	case artNode4:
+		n.keys = make([]byte, 0, 4)
+		n.children = make([]artChild, 0, 4)
+	case artNode16:
+		n.keys = make([]byte, 0, 16)
+		n.children = make([]artChild, 0, 16)
+	case artNode48:
+		n.keys = make([]byte, 256)
+		n.children = make([]artChild, 48)
+	case artNode256:
+		n.children = make([]artChild, 256)
+	}
+	return n
+}
+
+// mutable 返回可以原地修改的节点，节点被快照共享时复制一份
+func (art *AdaptiveRadixTree) mutable(n *artInner) *artInner {
+	if n.cow == art.cow {
+		return n
+	}
+	c := *n
+	c.cow = art.cow
+	c.keys = append(make([]byte, 0, cap(n.keys)), n.keys...)
+	c.children = append(make([]artChild, 0, cap(n.children)), n.children...)
+	return &c
+}
+
+// addChild 向节点中添加子节点，节点已满时扩展为更大的节点，返回添加之后的节点
+// 在使用此方法前 n 必须是可以原地修改的节点
+func (art *AdaptiveRadixTree) addChild(n *artInner, c byte, child artChild) *artInner {
+	switch n.kind {
+	case artNode4, artNode16:
+		if n.size == cap(n.keys) {
+			kind := artNode16
+			if n.kind == artNode16 {
+				kind = artNode48
+			}
+			return art.addChild(art.resize(n, kind), c, child)
+		}
+		i := 0
+		for i < n.size && n.keys[i] < c {
+			i++
+		}
+		n.keys = append(n.keys, 0)
+		n.children = append(n.children, nil)
+		copy(n.keys[i+1:], n.keys[i:])
+		copy(n.children[i+1:], n.children[i:])
+		n.keys[i] = c
+		n.children[i] = child
+	case artNode48:
+		if n.size == len(n.children) {
+			return art.addChild(art.resize(n, artNode256), c, child)
+		}
+		slot := 0
+		for n.children[slot] != nil {
+			slot++
+		}
+		n.keys[c] = byte(slot + 1)
+		n.children[slot] = child
+	case artNode256:
+		n.children[c] = child
+	}
+	n.size++
+	return n
+}
+
+// shrink 子节点减少之后收缩节点，只剩一个子节点时与子节点合并
+// 在使用此方法前 n 必须是可以原地修改的节点
+func (art *AdaptiveRadixTree) shrink(n *artInner) artChild {
+	if n.size == 0 {
+		// 叶子节点保存了完整的 key，可以直接替代内部节点
+		if n.leaf == nil {
+			return nil
+		}
+		return n.leaf
+	}
+	if n.size == 1 && n.leaf == nil {
+		c, child := n.nextChild(0)
+		if inner, ok := child.(*artInner); ok {
+			inner = art.mutable(inner)
+			prefix := make([]byte, 0, len(n.prefix)+1+len(inner.prefix))
+			prefix = append(append(append(prefix, n.prefix...), byte(c)), inner.prefix...)
+			inner.prefix = prefix
+			return inner
+		}
+		return child
+	}
+	switch {
+	case n.kind == artNode256 && n.size <= 36:
+		return art.resize(n, artNode48)
+	case n.kind == artNode48 && n.size <= 12:
+		return art.resize(n, artNode16)
+	case n.kind == artNode16 && n.size <= 3:
+		return art.resize(n, artNode4)
+	}
+	return n
+}
+
+// resize 将节点中的数据复制到 kind 类型的新节点中
+func (art *AdaptiveRadixTree) resize(n *artInner, kind uint8) *artInner {
+	node := art.newNode(kind, n.prefix)
+	node.leaf = n.leaf
+	for c, child := n.nextChild(0); child != nil; c, child = n.nextChild(c + 1) {
+		art.addChild(node, byte(c), child)
+	}
+	return node
+}
+
+// findChild 返回字节 c 对应的子节点
+func (n *artInner) findChild(c byte) artChild {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := 0; i < n.size; i++ {
+			if n.keys[i] == c {
+				return n.children[i]
+			}
+		}
+	case artNode48:
+		if slot := n.keys[c]; slot != 0 {
+			return n.children[slot-1]
+		}
+	case artNode256:
+		return n.children[c]
+	}
+	return nil
+}
+
+// setChild 替换字节 c 对应的已经存在的子节点
+func (n *artInner) setChild(c byte, child artChild) {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := 0; i < n.size; i++ {
+			if n.keys[i] == c {
+				n.children[i] = child
+				return
+			}
+		}
+	case artNode48:
+		n.children[n.keys[c]-1] = child
+	case artNode256:
+		n.children[c] = child
+	}
+}
+
+// removeChild 删除字节 c 对应的子节点
+func (n *artInner) removeChild(c byte) {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := 0; i < n.size; i++ {
+			if n.keys[i] == c {
+				copy(n.keys[i:], n.keys[i+1:])
+				copy(n.children[i:], n.children[i+1:])
+				n.children[n.size-1] = nil
+				n.keys = n.keys[:n.size-1]
+				n.children = n.children[:n.size-1]
+				break
+			}
+		}
+	case artNode48:
+		n.children[n.keys[c]-1] = nil
+		n.keys[c] = 0
+	case artNode256:
+		n.children[c] = nil
+	}
+	n.size--
+}
+
+// nextChild 返回字节大于等于 c 的第一个子节点和它的字节，不存在时子节点为空
+func (n *artInner) nextChild(c int) (int, artChild) {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := 0; i < n.size; i++ {
+			if int(n.keys[i]) >= c {
+				return int(n.keys[i]), n.children[i]
+			}
+		}
+	case artNode48:
+		for ; c < 256; c++ {
+			if slot := n.keys[c]; slot != 0 {
+				return c, n.children[slot-1]
+			}
+		}
+	case artNode256:
+		for ; c < 256; c++ {
+			if n.children[c] != nil {
+				return c, n.children[c]
+			}
+		}
+	}
+	return 0, nil
+}
+
+// prevChild 返回字节小于等于 c 的最后一个子节点和它的字节，不存在时子节点为空
+func (n *artInner) prevChild(c int) (int, artChild) {
+	switch n.kind {
+	case artNode4, artNode16:
+		for i := n.size - 1; i >= 0; i-- {
+			if int(n.keys[i]) <= c {
+				return int(n.keys[i]), n.children[i]
+			}
+		}
+	case artNode48:
+		for ; c >= 0; c-- {
+			if slot := n.keys[c]; slot != 0 {
+				return c, n.children[slot-1]
+			}
+		}
+	case artNode256:
+		for ; c >= 0; c-- {
+			if n.children[c] != nil {
+				return c, n.children[c]
+			}
+		}
+	}
+	return 0, nil
+}
+
+// commonPrefixLen 返回 a 和 b 公共前缀的长度
+func commonPrefixLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// artFrame 迭代器在一个内部节点中的遍历状态
+// next 为下一个要访问的子节点字节，-1 表示下一个访问的是节点自身的叶子节点
+type artFrame struct {
+	node *artInner
+	next int
+}
+
+// ART 索引迭代器，按照 key 的顺序在快照上遍历
+type artIterator struct {
+	root    artChild
+	reverse bool
+	stack   []artFrame // 从根节点到当前叶子节点的路径
+	curr    *artLeaf   // 当前遍历位置的叶子节点
+}
+
+// Rewind 重新回到迭代器起点
+func (ai *artIterator) Rewind() {
+	ai.stack = ai.stack[:0]
+	ai.curr = nil
+	if ai.root != nil && !ai.descend(ai.root) {
+		ai.advance()
+	}
+}
+
+// Seek 根据传入的 key 查找第一个大于（或小于）等于目标 key， 根据这个 key 开始遍历
+func (ai *artIterator) Seek(key []byte) {
+	ai.stack = ai.stack[:0]
+	ai.curr = nil
+	ref, depth := ai.root, 0
+	for ref != nil {
+		switch n := ref.(type) {
+		case *artLeaf:
+			cmp := bytes.Compare(n.key, key)
+			if cmp == 0 || (cmp > 0) != ai.reverse {
+				ai.curr = n
+				return
+			}
+			ai.advance()
+			return
+		case *artInner:
+			// 比较前缀和 key 中对应的部分，判断整棵子树是否都在目标 key 的一侧
+			rest := key[depth:]
+			cmp := bytes.Compare(n.prefix, rest[:min(len(n.prefix), len(rest))])
+			if cmp == 0 && len(rest) < len(n.prefix) {
+				cmp = 1
+			}
+			if cmp != 0 {
+				// 子树整体都在遍历方向上时从子树的第一个 key 开始，否则跳过子树
+				if (cmp > 0) != ai.reverse {
+					ai.descend(n)
+				}
+				ai.advance()
+				return
+			}
+			depth += len(n.prefix)
+			if depth == len(key) {
+				// 正向遍历时子树中所有的 key 都大于等于目标 key，反向遍历时只有节点自身的叶子节点可能等于
+				if ai.reverse {
+					ai.stack = append(ai.stack, artFrame{node: n, next: -1})
+				} else {
+					ai.descend(n)
+				}
+				ai.advance()
+				return
+			}
+			c := int(key[depth])
+			if ai.reverse {
+				ai.stack = append(ai.stack, artFrame{node: n, next: c - 1})
+			} else {
+				ai.stack = append(ai.stack, artFrame{node: n, next: c + 1})
+			}
+			ref = n.findChild(key[depth])
+			depth++
+		}
+	}
+	ai.advance()
+}
+
+// descend 进入子节点，子节点是叶子节点时作为当前遍历位置并返回 true
+func (ai *artIterator) descend(child artChild) bool {
+	switch c := child.(type) {
+	case *artLeaf:
+		ai.curr = c
+		return true
+	case *artInner:
+		next := -1
+		if ai.reverse {
+			next = 255
+		}
+		ai.stack = append(ai.stack, artFrame{node: c, next: next})
+	}
+	return false
+}
+
+// advance 从栈顶的节点继续遍历，直到找到下一个叶子节点
+// 正向遍历时节点自身的叶子节点在子节点之前，反向遍历时在子节点之后
+func (ai *artIterator) advance() {
+	ai.curr = nil
+	for len(ai.stack) > 0 {
+		f := &ai.stack[len(ai.stack)-1]
+		if !ai.reverse {
+			if f.next == -1 {
+				f.next = 0
+				if f.node.leaf != nil {
+					ai.curr = f.node.leaf
+					return
+				}
+				continue
+			}
+			c, child := -1, artChild(nil)
+			if f.next < 256 {
+				c, child = f.node.nextChild(f.next)
+			}
+			if child == nil {
+				ai.stack = ai.stack[:len(ai.stack)-1]
+				continue
+			}
+			f.next = c + 1
+			if ai.descend(child) {
+				return
+			}
+			continue
+		}
+
+		if f.next < 0 {
+			leaf := f.node.leaf
+			ai.stack = ai.stack[:len(ai.stack)-1]
+			if leaf != nil {
+				ai.curr = leaf
+				return
+			}
+			continue
+		}
+		c, child := f.node.prevChild(f.next)
+		if child == nil {
+			f.next = -1
+			continue
+		}
+		f.next = c - 1
+		if ai.descend(child) {
+			return
+		}
+	}
+}
+
+// Next 跳转到下一个 key
+func (ai *artIterator) Next() {
+	ai.advance()
+}
+
+// Valid 是否已经遍历完了所有 key ，用于退出遍历
+func (ai *artIterator) Valid() bool {
+	return ai.curr != nil
+}
+
+// Key 当前遍历位置的 Key 数据
+func (ai *artIterator) Key() []byte {
+	return ai.curr.key
+}
+
+// Value 当前遍历位置的 Value 数据
+func (ai *artIterator) Value() *data.LogRecordPos {
+	return &ai.curr.pos
+}
+
+// Close 关闭迭代器，释放相关资源
+func (ai *artIterator) Close() {
+	ai.root = nil
+	ai.stack = nil
+	ai.curr = nil
+}
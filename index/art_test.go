@@ -0,0 +1,201 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"go-bitcask/data"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestART_Put(t *testing.T) {
+	art := NewART()
+
+	res1 := art.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	assert.Nil(t, res1)
+
+	res2 := art.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, res2)
+
+	res3 := art.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.Equal(t, int64(2), res3.Offset)
+	assert.Equal(t, 2, art.Size())
+}
+
+func TestART_Get(t *testing.T) {
+	art := NewART()
+
+	art.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	pos1 := art.Get(nil)
+	assert.Equal(t, uint32(1), pos1.Fid)
+	assert.Equal(t, int64(100), pos1.Offset)
+
+	// key 互为前缀的情况
+	art.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	art.Put([]byte("ab"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	art.Put([]byte("abcd"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	art.Put([]byte("abd"), &data.LogRecordPos{Fid: 1, Offset: 4})
+	assert.Equal(t, int64(1), art.Get([]byte("abc")).Offset)
+	assert.Equal(t, int64(2), art.Get([]byte("ab")).Offset)
+	assert.Equal(t, int64(3), art.Get([]byte("abcd")).Offset)
+	assert.Equal(t, int64(4), art.Get([]byte("abd")).Offset)
+	assert.Nil(t, art.Get([]byte("a")))
+	assert.Nil(t, art.Get([]byte("abcde")))
+	assert.Nil(t, art.Get([]byte("b")))
+}
+
+func TestART_Delete(t *testing.T) {
+	art := NewART()
+
+	art.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100})
+	assert.True(t, art.Delete(nil))
+	assert.False(t, art.Delete(nil))
+
+	art.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 888})
+	art.Put([]byte("ab"), &data.LogRecordPos{Fid: 2, Offset: 999})
+	assert.False(t, art.Delete([]byte("b")))
+	assert.True(t, art.Delete([]byte("a")))
+	assert.Nil(t, art.Get([]byte("a")))
+	assert.Equal(t, int64(999), art.Get([]byte("ab")).Offset)
+	assert.True(t, art.Delete([]byte("ab")))
+	assert.Equal(t, 0, art.Size())
+}
+
+func TestART_Iterator(t *testing.T) {
+	art := NewART()
+	// 为空时，Iterator 无效
+	iter1 := art.Iterator(false)
+	assert.False(t, iter1.Valid())
+	iter1.Seek([]byte("a"))
+	assert.False(t, iter1.Valid())
+
+	keys := []string{"a", "ab", "abc", "abd", "b", "ba", "c"}
+	for i, key := range keys {
+		art.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	collect := func(iter Iterator) []string {
+		var res []string
+		for ; iter.Valid(); iter.Next() {
+			res = append(res, string(iter.Key()))
+		}
+		return res
+	}
+
+	iter2 := art.Iterator(false)
+	assert.Equal(t, keys, collect(iter2))
+	iter2.Seek([]byte("abca"))
+	assert.Equal(t, []string{"abd", "b", "ba", "c"}, collect(iter2))
+	iter2.Seek([]byte("ab"))
+	assert.Equal(t, int64(1), iter2.Value().Offset)
+	iter2.Seek([]byte("d"))
+	assert.False(t, iter2.Valid())
+
+	iter3 := art.Iterator(true)
+	assert.Equal(t, []string{"c", "ba", "b", "abd", "abc", "ab", "a"}, collect(iter3))
+	iter3.Seek([]byte("abca"))
+	assert.Equal(t, []string{"abc", "ab", "a"}, collect(iter3))
+	iter3.Seek([]byte("b"))
+	assert.Equal(t, []string{"b", "abd", "abc", "ab", "a"}, collect(iter3))
+	iter3.Seek([]byte("0"))
+	assert.False(t, iter3.Valid())
+
+	// 迭代器遍历的是创建时刻的数据
+	art.Put([]byte("aa"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	art.Delete([]byte("c"))
+	iter3.Rewind()
+	assert.Equal(t, "c", string(iter3.Key()))
+	iter3.Close()
+}
+
+func TestART_Random(t *testing.T) {
+	art := NewART()
+	expected := make(map[string]int64)
+	rnd := rand.New(rand.NewSource(1))
+	var snap Indexer
+	var snapExpected map[string]int64
+
+	// 随机的 key 覆盖各种大小的节点的扩展和收缩
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("%d-%d", rnd.Intn(30), rnd.Intn(300))
+		if rnd.Intn(2) == 0 {
+			key = string([]byte{byte(rnd.Intn(256)), byte(rnd.Intn(256))}[:1+rnd.Intn(2)])
+		}
+		if rnd.Intn(3) == 0 {
+			_, ok := expected[key]
+			assert.Equal(t, ok, art.Delete([]byte(key)))
+			delete(expected, key)
+		} else {
+			art.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+			expected[key] = int64(i)
+		}
+		if i == 10000 {
+			snap = art.Snapshot()
+			snapExpected = make(map[string]int64, len(expected))
+			for k, v := range expected {
+				snapExpected[k] = v
+			}
+		}
+	}
+
+	check := func(idx Indexer, expected map[string]int64) {
+		assert.Equal(t, len(expected), idx.Size())
+		keys := make([]string, 0, len(expected))
+		for key, offset := range expected {
+			keys = append(keys, key)
+			assert.Equal(t, offset, idx.Get([]byte(key)).Offset)
+		}
+		sort.Strings(keys)
+
+		iter := idx.Iterator(false)
+		var i int
+		for ; iter.Valid(); iter.Next() {
+			assert.Equal(t, keys[i], string(iter.Key()))
+			i++
+		}
+		assert.Equal(t, len(keys), i)
+
+		targets := []string{"", "1", "15-", "29-299", "3-1", "9", "\x80", "\xff\xff"}
+		for _, target := range targets {
+			iter.Seek([]byte(target))
+			j := sort.Search(len(keys), func(i int) bool { return bytes.Compare([]byte(keys[i]), []byte(target)) >= 0 })
+			if j == len(keys) {
+				assert.False(t, iter.Valid())
+				continue
+			}
+			assert.Equal(t, keys[j], string(iter.Key()))
+		}
+
+		iter = idx.Iterator(true)
+		for _, target := range targets {
+			iter.Seek([]byte(target))
+			j := sort.Search(len(keys), func(i int) bool { return bytes.Compare([]byte(keys[i]), []byte(target)) > 0 }) - 1
+			if j < 0 {
+				assert.False(t, iter.Valid())
+				continue
+			}
+			assert.Equal(t, keys[j], string(iter.Key()))
+		}
+	}
+	check(art, expected)
+	check(snap, snapExpected)
+}
+
+func TestART_Snapshot(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("aa"), &data.LogRecordPos{Fid: 1, Offset: 10})
+
+	snap := art.Snapshot()
+	art.Put([]byte("aa"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	art.Put([]byte("bb"), &data.LogRecordPos{Fid: 1, Offset: 30})
+
+	assert.Equal(t, int64(10), snap.Get([]byte("aa")).Offset)
+	assert.Nil(t, snap.Get([]byte("bb")))
+	assert.Equal(t, 1, snap.Size())
+	assert.Equal(t, int64(20), art.Get([]byte("aa")).Offset)
+	assert.Equal(t, 2, art.Size())
+	assert.Nil(t, snap.Close())
+}
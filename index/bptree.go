@@ -1,8 +1,11 @@
 package index
 
 import (
+	"encoding/binary"
+	"errors"
 	"go-bitcask/data"
 	"path/filepath"
+	"sync/atomic"
 
 	"go.etcd.io/bbolt"
 )
@@ -11,37 +14,210 @@ const bptreeIndexFileName = "bptree-index"
 
 var indexBucketName = []byte("bitcask-index")
 
+// 存储索引元数据的 bucket，与索引数据分开，不会出现在遍历中
+// 每个数据文件中可回收的字节数存储在嵌套的 bucket 中，写入时只需要更新发生变化的文件
+var (
+	metaBucketName        = []byte("bitcask-meta")
+	watermarkKey          = []byte("watermark")
+	reclaimableBucketName = []byte("reclaimable")
+)
+
+var ErrInvalidWatermark = errors.New("invalid bptree index watermark")
+
 type BPlusTree struct {
 	tree *bbolt.DB
+	size int64 // 索引中 key 的数量，bbolt 统计 key 的数量需要遍历整个 bucket
+}
+
+// Watermark B+ 树索引已经应用到的数据文件位置，启动时只需要重放这个位置之后写入的数据
+type Watermark struct {
+	Fid         uint32           // 数据文件 id
+	Offset      int64            // 数据文件中已经应用的数据的末尾位置
+	SeqNo       uint64           // 事务序列号
+	Reclaimable map[uint32]int64 // 水位之前每个数据文件中可以被 merge 回收的字节数
+}
+
+// BPlusTreeTx 在同一个 bbolt 事务中读写索引
+type BPlusTreeTx struct {
+	bucket *bbolt.Bucket
+	delta  int64 // 事务中 key 数量的变化，提交之后更新到 size 中
+	err    error
 }
 
 func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, nil)
+	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
 	if err != nil {
 		panic("failed to open bptree")
 	}
 
+	var size int
 	if err := bptree.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(indexBucketName)
-		return err
+		bucket, err := tx.CreateBucketIfNotExists(indexBucketName)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(metaBucketName); err != nil {
+			return err
+		}
+		size = bucket.Stats().KeyN
+		return nil
 	}); err != nil {
 		panic("failed to create bucket on bptree")
 	}
 
-	return &BPlusTree{tree: bptree}
+	return &BPlusTree{tree: bptree, size: int64(size)}
+}
+
+// Watermark 返回索引中记录的水位，没有记录时返回空
+func (bpt *BPlusTree) Watermark() (*Watermark, error) {
+	var wm *Watermark
+	err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		buf := meta.Get(watermarkKey)
+		if buf == nil {
+			return nil
+		}
+		var index = 0
+		fid, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return ErrInvalidWatermark
+		}
+		index += n
+		offset, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return ErrInvalidWatermark
+		}
+		index += n
+		seqNo, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return ErrInvalidWatermark
+		}
+
+		reclaimable := make(map[uint32]int64)
+		if bucket := meta.Bucket(reclaimableBucketName); bucket != nil {
+			if err := bucket.ForEach(func(k, v []byte) error {
+				size, n := binary.Uvarint(v)
+				if len(k) != 4 || n <= 0 {
+					return ErrInvalidWatermark
+				}
+				reclaimable[binary.BigEndian.Uint32(k)] = int64(size)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		wm = &Watermark{Fid: uint32(fid), Offset: offset, SeqNo: seqNo, Reclaimable: reclaimable}
+		return nil
+	})
+	return wm, err
+}
+
+// SetWatermark 记录索引已经应用到的位置，为空时删除水位，之后启动时需要重新构建索引
+// 调用时水位之前的数据必须都已经更新到索引中
+func (bpt *BPlusTree) SetWatermark(wm *Watermark) error {
+	return bpt.tree.Update(func(tx *bbolt.Tx) error {
+		meta := tx.Bucket(metaBucketName)
+		if err := meta.DeleteBucket(reclaimableBucketName); err != nil && err != bbolt.ErrBucketNotFound {
+			return err
+		}
+		if wm == nil {
+			return meta.Delete(watermarkKey)
+		}
+		return putWatermark(meta, wm)
+	})
 }
 
-// Put 向索引中存储key对应的数据位置信息
-func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) bool {
+// Update 在同一个事务中执行 fn 中对索引的修改，并记录 fn 返回的水位，返回空时不修改水位
+// 水位中的 Reclaimable 只需要包含发生变化的数据文件，其他数据文件保持不变
+func (bpt *BPlusTree) Update(fn func(tx *BPlusTreeTx) *Watermark) error {
+	var delta int64
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(indexBucketName)
-		return bucket.Put(key, data.EncodeLogRecordPos(pos))
+		btx := &BPlusTreeTx{bucket: tx.Bucket(indexBucketName)}
+		wm := fn(btx)
+		if btx.err != nil {
+			return btx.err
+		}
+		delta = btx.delta
+		if wm == nil {
+			return nil
+		}
+		return putWatermark(tx.Bucket(metaBucketName), wm)
+	}); err != nil {
+		return err
+	}
+	atomic.AddInt64(&bpt.size, delta)
+	return nil
+}
+
+// putWatermark 写入水位以及 wm.Reclaimable 中每个数据文件可回收的字节数
+func putWatermark(meta *bbolt.Bucket, wm *Watermark) error {
+	buf := make([]byte, binary.MaxVarintLen64*3)
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(wm.Fid))
+	index += binary.PutVarint(buf[index:], wm.Offset)
+	index += binary.PutUvarint(buf[index:], wm.SeqNo)
+	if err := meta.Put(watermarkKey, buf[:index]); err != nil {
+		return err
+	}
+	if len(wm.Reclaimable) == 0 {
+		return nil
+	}
+
+	bucket, err := meta.CreateBucketIfNotExists(reclaimableBucketName)
+	if err != nil {
+		return err
+	}
+	for fid, size := range wm.Reclaimable {
+		var key [4]byte
+		binary.BigEndian.PutUint32(key[:], fid)
+		if size == 0 {
+			if err := bucket.Delete(key[:]); err != nil {
+				return err
+			}
+			continue
+		}
+		value := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(value, uint64(size))
+		if err := bucket.Put(key[:], value[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset 清空索引中的数据以及水位
+func (bpt *BPlusTree) Reset() error {
+	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(indexBucketName); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket(indexBucketName); err != nil {
+			return err
+		}
+		meta := tx.Bucket(metaBucketName)
+		if err := meta.DeleteBucket(reclaimableBucketName); err != nil && err != bbolt.ErrBucketNotFound {
+			return err
+		}
+		return meta.Delete(watermarkKey)
+	}); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&bpt.size, 0)
+	return nil
+}
+
+// Put 向索引中存储key对应的数据位置信息，返回被覆盖的旧的位置信息
+func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	var oldPos *data.LogRecordPos
+	if err := bpt.Update(func(tx *BPlusTreeTx) *Watermark {
+		oldPos = tx.Put(key, pos)
+		return nil
 	}); err != nil {
 		panic("failed to put value in bptree")
 	}
-	return true
+	return oldPos
 }
 
 // Get 根据key取出对应的索引位置信息
@@ -63,12 +239,8 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 // Delete 根据key删除对应的索引位置信息
 func (bpt *BPlusTree) Delete(key []byte) bool {
 	var ok bool
-	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(indexBucketName)
-		if value := bucket.Get(key); len(value) != 0 {
-			ok = true
-			return bucket.Delete(key)
-		}
+	if err := bpt.Update(func(tx *BPlusTreeTx) *Watermark {
+		ok = tx.Delete(key)
 		return nil
 	}); err != nil {
 		panic("failed to delete value in bptree")
@@ -76,22 +248,79 @@ func (bpt *BPlusTree) Delete(key []byte) bool {
 	return ok
 }
 
+// Put 在事务中存储 key 对应的数据位置信息，返回被覆盖的旧的位置信息
+func (tx *BPlusTreeTx) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	if tx.err != nil {
+		return nil
+	}
+	var oldPos *data.LogRecordPos
+	if oldValue := tx.bucket.Get(key); len(oldValue) != 0 {
+		oldPos = data.DecodeLogRecordPos(oldValue)
+	}
+	if tx.err = tx.bucket.Put(key, data.EncodeLogRecordPos(pos)); tx.err != nil {
+		return nil
+	}
+	if oldPos == nil {
+		tx.delta++
+	}
+	return oldPos
+}
+
+// Get 在事务中读取 key 对应的数据位置信息，能读到事务中的修改
+func (tx *BPlusTreeTx) Get(key []byte) *data.LogRecordPos {
+	if value := tx.bucket.Get(key); len(value) != 0 {
+		return data.DecodeLogRecordPos(value)
+	}
+	return nil
+}
+
+// Delete 在事务中删除 key 对应的数据位置信息
+func (tx *BPlusTreeTx) Delete(key []byte) bool {
+	if tx.err != nil || len(tx.bucket.Get(key)) == 0 {
+		return false
+	}
+	if tx.err = tx.bucket.Delete(key); tx.err != nil {
+		return false
+	}
+	tx.delta--
+	return true
+}
+
 // Size 索引中的数据
 func (bpt *BPlusTree) Size() int {
-	var size int
+	return int(atomic.LoadInt64(&bpt.size))
+}
+
+// Iterator 返回索引迭代器
+func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
+	tx, err := bpt.tree.Begin(false)
+	if err != nil {
+		panic("failed to begin a transaction")
+	}
+	return newBptreeIterator(tx, reverse)
+}
+
+// Snapshot 返回索引在当前时刻的只读视图
+// bbolt 的事务不能在多个 goroutine 中使用，长时间持有只读事务还会阻塞写事务扩容，
+// 因此将索引复制到内存中的 BTree
+func (bpt *BPlusTree) Snapshot() Indexer {
+	snap := NewBTree()
 	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket(indexBucketName)
-		size = bucket.Stats().KeyN
-		return nil
+		return tx.Bucket(indexBucketName).ForEach(func(k, v []byte) error {
+			key := make([]byte, len(k))
+			copy(key, k)
+			snap.tree.ReplaceOrInsert(&Item{key: key, pos: data.DecodeLogRecordPos(v)})
+			return nil
+		})
 	}); err != nil {
-		panic("failed to get size from bptree")
+		panic("failed to snapshot bptree")
 	}
-	return size
+	return snap
 }
 
-// Iterator 返回索引迭代器
-func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
-	return newBptreeIterator(bpt.tree, reverse)
+// Close 关闭索引
+func (bpt *BPlusTree) Close() error {
+	return bpt.tree.Close()
 }
 
 // B+树迭代器
@@ -104,11 +333,7 @@ type bptreeIterator struct {
 }
 
 // newBptreeIterator 返回 B+树迭代器
-func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
-	tx, err := tree.Begin(false)
-	if err != nil {
-		panic("failed to begin a transaction")
-	}
+func newBptreeIterator(tx *bbolt.Tx, reverse bool) *bptreeIterator {
 	bpi := &bptreeIterator{
 		tx:      tx,
 		cursor:  tx.Bucket(indexBucketName).Cursor(),
@@ -157,6 +382,7 @@ func (bpi *bptreeIterator) Value() *data.LogRecordPos {
 }
 
 // Close 关闭迭代器，释放相关资源
+// 只读事务不能 Commit，需要通过 Rollback 释放
 func (bpi *bptreeIterator) Close() {
-	_ = bpi.tx.Commit()
+	_ = bpi.tx.Rollback()
 }
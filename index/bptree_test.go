@@ -1,6 +1,7 @@
 package index
 
 import (
+	"fmt"
 	"go-bitcask/data"
 	"os"
 	"path/filepath"
@@ -72,6 +73,18 @@ func TestBPTree_Size(t *testing.T) {
 	tree.Put([]byte("aag"), &data.LogRecordPos{Fid: 123, Offset: 111})
 	tree.Put([]byte("aah"), &data.LogRecordPos{Fid: 123, Offset: 111})
 	assert.Equal(t, 3, tree.Size())
+
+	// 覆盖写不增加数量，删除不存在的 key 不减少数量
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 222})
+	assert.True(t, tree.Delete([]byte("aag")))
+	assert.False(t, tree.Delete([]byte("aag")))
+	assert.Equal(t, 2, tree.Size())
+
+	// 重新打开后数量保持一致
+	assert.Nil(t, tree.Close())
+	tree = NewBPlusTree(path, false)
+	defer tree.Close()
+	assert.Equal(t, 2, tree.Size())
 }
 
 func TestBpTree_Iterator(t *testing.T) {
@@ -95,3 +108,128 @@ func TestBpTree_Iterator(t *testing.T) {
 		assert.NotNil(t, it.Value())
 	}
 }
+
+func TestBPTree_Snapshot(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-Snapshot")
+	_ = os.Mkdir(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+	defer tree.Close()
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
+	snap := tree.Snapshot()
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 1234})
+	tree.Put([]byte("bbc"), &data.LogRecordPos{Fid: 123, Offset: 2345})
+
+	assert.Equal(t, int64(999), snap.Get([]byte("aac")).Offset)
+	assert.Nil(t, snap.Get([]byte("bbc")))
+	assert.Equal(t, 1, snap.Size())
+
+	iter := snap.Iterator(false)
+	var keys [][]byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, [][]byte{[]byte("aac")}, keys)
+	assert.Equal(t, int64(999), snap.Get([]byte("aac")).Offset)
+
+	// 快照存活期间可以继续写入
+	for i := 0; i < 1000; i++ {
+		tree.Put([]byte(fmt.Sprintf("key-%d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Equal(t, 1002, tree.Size())
+	assert.Equal(t, 1, snap.Size())
+	assert.Nil(t, snap.Close())
+}
+
+func TestBPTree_Watermark(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-Watermark")
+	_ = os.Mkdir(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+
+	wm, err := tree.Watermark()
+	assert.Nil(t, err)
+	assert.Nil(t, wm)
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
+	reclaimable := map[uint32]int64{0: 4096, 123: 512}
+	assert.Nil(t, tree.SetWatermark(&Watermark{Fid: 123, Offset: 1024, SeqNo: 7, Reclaimable: reclaimable}))
+	assert.Nil(t, tree.Close())
+
+	// 水位与索引数据一起持久化，并且不会出现在遍历中
+	tree = NewBPlusTree(path, false)
+	wm, err = tree.Watermark()
+	assert.Nil(t, err)
+	assert.Equal(t, &Watermark{Fid: 123, Offset: 1024, SeqNo: 7, Reclaimable: reclaimable}, wm)
+	assert.Equal(t, 1, tree.Size())
+
+	assert.Nil(t, tree.SetWatermark(nil))
+	wm, err = tree.Watermark()
+	assert.Nil(t, err)
+	assert.Nil(t, wm)
+
+	assert.Nil(t, tree.SetWatermark(&Watermark{Fid: 1, Offset: 16}))
+	assert.Nil(t, tree.Reset())
+	wm, err = tree.Watermark()
+	assert.Nil(t, err)
+	assert.Nil(t, wm)
+	assert.Equal(t, 0, tree.Size())
+	assert.Nil(t, tree.Get([]byte("aac")))
+	assert.Nil(t, tree.Close())
+}
+
+func TestBPTree_Update(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-Update")
+	_ = os.Mkdir(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+
+	assert.Nil(t, tree.SetWatermark(&Watermark{Fid: 1, Offset: 16, Reclaimable: map[uint32]int64{0: 100, 1: 200}}))
+	err := tree.Update(func(tx *BPlusTreeTx) *Watermark {
+		assert.Nil(t, tx.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 16}))
+		assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 16}, tx.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 32}))
+		assert.Nil(t, tx.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 48}))
+		assert.True(t, tx.Delete([]byte("b")))
+		assert.False(t, tx.Delete([]byte("c")))
+		assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 32}, tx.Get([]byte("a")))
+		// 只更新发生变化的数据文件，为 0 时删除
+		return &Watermark{Fid: 1, Offset: 64, SeqNo: 3, Reclaimable: map[uint32]int64{0: 0, 1: 250, 2: 10}}
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, tree.Size())
+	wm, err := tree.Watermark()
+	assert.Nil(t, err)
+	assert.Equal(t, &Watermark{Fid: 1, Offset: 64, SeqNo: 3, Reclaimable: map[uint32]int64{1: 250, 2: 10}}, wm)
+
+	// 出错时索引和水位都不会被修改
+	err = tree.Update(func(tx *BPlusTreeTx) *Watermark {
+		tx.Put([]byte("d"), &data.LogRecordPos{Fid: 1, Offset: 80})
+		tx.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 96})
+		return &Watermark{Fid: 1, Offset: 112}
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, tree.Size())
+	assert.Nil(t, tree.Get([]byte("d")))
+	wm, err = tree.Watermark()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(64), wm.Offset)
+
+	// 不返回水位时只修改索引
+	assert.Nil(t, tree.Update(func(tx *BPlusTreeTx) *Watermark {
+		tx.Put([]byte("e"), &data.LogRecordPos{Fid: 1, Offset: 128})
+		return nil
+	}))
+	assert.Equal(t, 2, tree.Size())
+	wm, err = tree.Watermark()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(64), wm.Offset)
+	assert.Nil(t, tree.Close())
+}
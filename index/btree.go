@@ -3,7 +3,6 @@ package index
 import (
 	"bytes"
 	"go-bitcask/data"
-	"sort"
 	"sync"
 
 	"github.com/google/btree"
@@ -23,19 +22,24 @@ func NewBTree() *BTree {
 	}
 }
 
-// Put 向索引中存储key对应的数据位置信息
-func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) bool {
+// Put 向索引中存储key对应的数据位置信息，返回被覆盖的旧的位置信息
+func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	it := &Item{key: key, pos: pos}
 	bt.lock.Lock()
-	bt.tree.ReplaceOrInsert(it)
+	oldItem := bt.tree.ReplaceOrInsert(it)
 	bt.lock.Unlock()
-	return true
+	if oldItem == nil {
+		return nil
+	}
+	return oldItem.(*Item).pos
 }
 
 // Get 根据key取出对应的索引位置信息
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
+	bt.lock.RLock()
 	btreeItem := bt.tree.Get(it)
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
@@ -53,73 +57,104 @@ func (bt *BTree) Delete(key []byte) bool {
 
 // Size 索引中的数据大小
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
+// Snapshot 返回索引在当前时刻的只读视图
+// Clone 是写时复制的，创建快照的开销是 O(1)
+func (bt *BTree) Snapshot() Indexer {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}
+}
+
+// Close 关闭索引，内存索引无需释放资源
+func (bt *BTree) Close() error {
+	return nil
+}
+
 // Iterator 返回索引迭代器
+// 迭代器遍历的是写时复制的克隆，创建的开销是 O(1)，之后的写入对迭代器不可见
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
 		return nil
-
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
-
+	// Clone 会修改原来的树，需要持有写锁
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return newBTreeIterator(bt.tree.Clone(), reverse)
 }
 
-// BTree 索引迭代器
+// 迭代器每次从 BTree 中取出的数据条数
+const btreeIteratorBatchSize = 64
+
+// BTree 索引迭代器，每次从克隆的 BTree 中按顺序取出一批数据
 type btreeIterator struct {
-	currIndex int     // 当前索引下标位置
-	reverse   bool    // 是否逆序遍历
-	values    []*Item // 索引值
+	tree      *btree.BTree // 创建迭代器时的克隆，只读
+	currIndex int          // 当前批次中的下标位置
+	reverse   bool         // 是否逆序遍历
+	values    []*Item      // 当前批次的索引值
+	exhausted bool         // 当前批次之后是否已经没有数据
 }
 
 func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
-
-	// 将所有的数据存放到数组中
-	saveValues := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
-		return true
+	bti := &btreeIterator{
+		tree:    tree,
+		reverse: reverse,
+		values:  make([]*Item, 0, btreeIteratorBatchSize),
 	}
+	bti.Rewind()
+	return bti
+}
 
-	if reverse {
-		tree.Descend(saveValues)
-	} else {
-		tree.Ascend(saveValues)
+// fill 取出下一批数据，pivot 为空时从起点开始；inclusive 为 false 时跳过等于 pivot 的 key
+func (bti *btreeIterator) fill(pivot *Item, inclusive bool) {
+	bti.values = bti.values[:0]
+	bti.currIndex = 0
+	saveValues := func(it btree.Item) bool {
+		item := it.(*Item)
+		if !inclusive && bytes.Equal(item.key, pivot.key) {
+			return true
+		}
+		bti.values = append(bti.values, item)
+		return len(bti.values) < btreeIteratorBatchSize
 	}
 
-	return &btreeIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
+	switch {
+	case pivot == nil && bti.reverse:
+		bti.tree.Descend(saveValues)
+	case pivot == nil:
+		bti.tree.Ascend(saveValues)
+	case bti.reverse:
+		bti.tree.DescendLessOrEqual(pivot, saveValues)
+	default:
+		bti.tree.AscendGreaterOrEqual(pivot, saveValues)
 	}
+	bti.exhausted = len(bti.values) < btreeIteratorBatchSize
 }
 
 // Rewind 重新回到迭代器起点
 func (bti *btreeIterator) Rewind() {
-	bti.currIndex = 0
+	bti.fill(nil, true)
 }
 
 // Seek 根据传入的 key 查找第一个大于（或小于）等于目标 key， 根据这个 key 开始遍历
 func (bti *btreeIterator) Seek(key []byte) {
-	if bti.reverse {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
-	} else {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
-	}
+	bti.fill(&Item{key: key}, true)
 }
 
 // Next 跳转到下一个 key
 func (bti *btreeIterator) Next() {
 	bti.currIndex += 1
+	// 当前批次遍历完之后，从最后一个 key 之后取出下一批
+	if bti.currIndex == len(bti.values) && !bti.exhausted {
+		bti.fill(bti.values[len(bti.values)-1], false)
+	}
 }
 
 // Valid 是否已经遍历完了所有 key ，用于退出遍历
@@ -139,5 +174,6 @@ func (bti *btreeIterator) Value() *data.LogRecordPos {
 
 // Close 关闭迭代器，释放相关资源
 func (bti *btreeIterator) Close() {
+	bti.tree = nil
 	bti.values = nil
 }
@@ -1,6 +1,7 @@
 package index
 
 import (
+	"fmt"
 	"go-bitcask/data"
 	"testing"
 
@@ -100,3 +101,62 @@ func TestBTree_Iterator(t *testing.T) {
 	}
 
 }
+
+func TestBTree_Iterator_Batch(t *testing.T) {
+	bt := NewBTree()
+	// 数据量超过迭代器一批取出的数量
+	n := btreeIteratorBatchSize*3 + 5
+	for i := 0; i < n; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter := bt.Iterator(false)
+	// 创建迭代器之后的修改对迭代器不可见
+	bt.Put([]byte("key-0000a"), &data.LogRecordPos{Fid: 1, Offset: 1000})
+	bt.Delete([]byte(fmt.Sprintf("key-%04d", n-1)))
+
+	var i int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", i), string(iter.Key()))
+		assert.Equal(t, int64(i), iter.Value().Offset)
+		i++
+	}
+	assert.Equal(t, n, i)
+
+	iter.Seek([]byte("key-0100"))
+	for i = 100; iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", i), string(iter.Key()))
+		i++
+	}
+	assert.Equal(t, n, i)
+	iter.Close()
+
+	riter := bt.Iterator(true)
+	riter.Seek([]byte("key-0100a"))
+	for i = 100; riter.Valid(); riter.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", i), string(riter.Key()))
+		i--
+		if i == 0 {
+			// 创建迭代器之前插入的 key 可见
+			riter.Next()
+			assert.Equal(t, "key-0000a", string(riter.Key()))
+		}
+	}
+	riter.Close()
+}
+
+func TestBTree_Snapshot(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("aa"), &data.LogRecordPos{Fid: 1, Offset: 10})
+
+	snap := bt.Snapshot()
+	bt.Put([]byte("aa"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	bt.Put([]byte("bb"), &data.LogRecordPos{Fid: 1, Offset: 30})
+
+	assert.Equal(t, int64(10), snap.Get([]byte("aa")).Offset)
+	assert.Nil(t, snap.Get([]byte("bb")))
+	assert.Equal(t, 1, snap.Size())
+	assert.Equal(t, int64(20), bt.Get([]byte("aa")).Offset)
+	assert.Equal(t, 2, bt.Size())
+	assert.Nil(t, snap.Close())
+}
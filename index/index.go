@@ -9,8 +9,8 @@ import (
 
 // Indexer 索引接口，方便接入其他的数据结构
 type Indexer interface {
-	// Put 向索引中存储key对应的数据位置信息
-	Put(key []byte, pos *data.LogRecordPos) bool
+	// Put 向索引中存储key对应的数据位置信息，返回被覆盖的旧的位置信息
+	Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos
 
 	// Get 根据key取出对应的索引位置信息
 	Get(key []byte) *data.LogRecordPos
@@ -23,6 +23,12 @@ type Indexer interface {
 
 	// Size 索引中的数据
 	Size() int
+
+	// Snapshot 返回索引在当前时刻的只读视图，后续对索引的修改对其不可见
+	Snapshot() Indexer
+
+	// Close 关闭索引，释放相关资源
+	Close() error
 }
 
 type IndexType = int8
@@ -34,6 +40,12 @@ const (
 
 	// B+ tree 索引
 	BPTree
+
+	// 自适应基数树索引
+	ART
+
+	// 按照 key 分片的 BTree 索引
+	Sharded
 )
 
 // NewIndexer 根据具体类型初始化索引
@@ -43,6 +55,10 @@ func NewIndexer(typ IndexType, dirPath string, sync bool) Indexer {
 		return NewBTree()
 	case BPTree:
 		return NewBPlusTree(dirPath, sync)
+	case ART:
+		return NewART()
+	case Sharded:
+		return NewShardedBTree(defaultShardNum)
 	default:
 		panic("unsupported index type")
 	}
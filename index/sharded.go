@@ -0,0 +1,198 @@
+package index
+
+import (
+	"bytes"
+	"container/heap"
+	"go-bitcask/data"
+	"sync"
+)
+
+// 分片索引默认的分片数量
+const defaultShardNum = 16
+
+// ShardedBTree 按照 key 的哈希值分片的 BTree 索引
+// 不同分片中的 key 读写时只使用各自分片的锁，有序遍历时合并所有分片的迭代器
+type ShardedBTree struct {
+	shards []*BTree
+}
+
+// NewShardedBTree 初始化分片 BTree 索引，shardNum 为分片的数量
+func NewShardedBTree(shardNum int) *ShardedBTree {
+	if shardNum <= 0 {
+		shardNum = defaultShardNum
+	}
+	shards := make([]*BTree, shardNum)
+	for i := range shards {
+		shards[i] = NewBTree()
+	}
+	return &ShardedBTree{shards: shards}
+}
+
+// shard 返回 key 所在的分片，使用 FNV-1a 哈希
+func (sbt *ShardedBTree) shard(key []byte) *BTree {
+	var hash uint32 = 2166136261
+	for _, b := range key {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	return sbt.shards[hash%uint32(len(sbt.shards))]
+}
+
+// Put 向索引中存储key对应的数据位置信息，返回被覆盖的旧的位置信息
+func (sbt *ShardedBTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	return sbt.shard(key).Put(key, pos)
+}
+
+// Get 根据key取出对应的索引位置信息
+func (sbt *ShardedBTree) Get(key []byte) *data.LogRecordPos {
+	return sbt.shard(key).Get(key)
+}
+
+// Delete 根据key删除对应的索引位置信息
+func (sbt *ShardedBTree) Delete(key []byte) bool {
+	return sbt.shard(key).Delete(key)
+}
+
+// Size 索引中的数据大小
+func (sbt *ShardedBTree) Size() int {
+	var size int
+	for _, shard := range sbt.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+// lockAll 获取所有分片的写锁，保证克隆的所有分片是同一时刻的视图
+func (sbt *ShardedBTree) lockAll() func() {
+	for _, shard := range sbt.shards {
+		shard.lock.Lock()
+	}
+	return func() {
+		for _, shard := range sbt.shards {
+			shard.lock.Unlock()
+		}
+	}
+}
+
+// Snapshot 返回索引在当前时刻的只读视图
+func (sbt *ShardedBTree) Snapshot() Indexer {
+	defer sbt.lockAll()()
+	shards := make([]*BTree, len(sbt.shards))
+	for i, shard := range sbt.shards {
+		shards[i] = &BTree{tree: shard.tree.Clone(), lock: new(sync.RWMutex)}
+	}
+	return &ShardedBTree{shards: shards}
+}
+
+// Close 关闭索引，内存索引无需释放资源
+func (sbt *ShardedBTree) Close() error {
+	return nil
+}
+
+// Iterator 返回索引迭代器，按照 key 的顺序合并所有分片的迭代器
+func (sbt *ShardedBTree) Iterator(reverse bool) Iterator {
+	defer sbt.lockAll()()
+	iters := make([]Iterator, len(sbt.shards))
+	for i, shard := range sbt.shards {
+		iters[i] = newBTreeIterator(shard.tree.Clone(), reverse)
+	}
+	iter := &shardedIterator{iters: iters, reverse: reverse}
+	iter.reset()
+	return iter
+}
+
+// 分片索引迭代器，使用堆维护每个分片迭代器的当前位置
+// 不同分片中的 key 不会重复，堆顶即为下一个要遍历的 key
+type shardedIterator struct {
+	iters   []Iterator // 每个分片的迭代器
+	heap    []Iterator // 仍然有效的迭代器
+	reverse bool
+}
+
+func (si *shardedIterator) Len() int {
+	return len(si.heap)
+}
+
+func (si *shardedIterator) Less(i, j int) bool {
+	cmp := bytes.Compare(si.heap[i].Key(), si.heap[j].Key())
+	if si.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (si *shardedIterator) Swap(i, j int) {
+	si.heap[i], si.heap[j] = si.heap[j], si.heap[i]
+}
+
+func (si *shardedIterator) Push(x any) {
+	si.heap = append(si.heap, x.(Iterator))
+}
+
+func (si *shardedIterator) Pop() any {
+	last := si.heap[len(si.heap)-1]
+	si.heap = si.heap[:len(si.heap)-1]
+	return last
+}
+
+// reset 分片的迭代器移动位置之后重新构建堆
+func (si *shardedIterator) reset() {
+	si.heap = si.heap[:0]
+	for _, iter := range si.iters {
+		if iter.Valid() {
+			si.heap = append(si.heap, iter)
+		}
+	}
+	heap.Init(si)
+}
+
+// Rewind 重新回到迭代器起点
+func (si *shardedIterator) Rewind() {
+	for _, iter := range si.iters {
+		iter.Rewind()
+	}
+	si.reset()
+}
+
+// Seek 根据传入的 key 查找第一个大于（或小于）等于目标 key， 根据这个 key 开始遍历
+func (si *shardedIterator) Seek(key []byte) {
+	for _, iter := range si.iters {
+		iter.Seek(key)
+	}
+	si.reset()
+}
+
+// Next 跳转到下一个 key
+func (si *shardedIterator) Next() {
+	top := si.heap[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(si, 0)
+	} else {
+		heap.Pop(si)
+	}
+}
+
+// Valid 是否已经遍历完了所有 key ，用于退出遍历
+func (si *shardedIterator) Valid() bool {
+	return len(si.heap) > 0
+}
+
+// Key 当前遍历位置的 Key 数据
+func (si *shardedIterator) Key() []byte {
+	return si.heap[0].Key()
+}
+
+// Value 当前遍历位置的 Value 数据
+func (si *shardedIterator) Value() *data.LogRecordPos {
+	return si.heap[0].Value()
+}
+
+// Close 关闭迭代器，释放相关资源
+func (si *shardedIterator) Close() {
+	for _, iter := range si.iters {
+		iter.Close()
+	}
+	si.iters = nil
+	si.heap = nil
+}
@@ -0,0 +1,96 @@
+package index
+
+import (
+	"fmt"
+	"go-bitcask/data"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedBTree_PutGetDelete(t *testing.T) {
+	sbt := NewShardedBTree(4)
+
+	assert.Nil(t, sbt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 1}))
+	assert.Nil(t, sbt.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 2}))
+	old := sbt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.Equal(t, int64(1), old.Offset)
+	assert.Equal(t, int64(3), sbt.Get([]byte("a")).Offset)
+	assert.Equal(t, 2, sbt.Size())
+
+	assert.True(t, sbt.Delete([]byte("a")))
+	assert.False(t, sbt.Delete([]byte("a")))
+	assert.Nil(t, sbt.Get([]byte("a")))
+	assert.Equal(t, 1, sbt.Size())
+}
+
+func TestShardedBTree_Concurrent(t *testing.T) {
+	sbt := NewShardedBTree(8)
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%04d", g, i))
+				sbt.Put(key, &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+				assert.Equal(t, int64(i), sbt.Get(key).Offset)
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 8000, sbt.Size())
+}
+
+func TestShardedBTree_Iterator(t *testing.T) {
+	sbt := NewShardedBTree(4)
+	iter1 := sbt.Iterator(false)
+	assert.False(t, iter1.Valid())
+	iter1.Close()
+
+	for i := 0; i < 200; i++ {
+		sbt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	iter2 := sbt.Iterator(false)
+	// 创建迭代器之后的修改对迭代器不可见
+	sbt.Delete([]byte("key-0000"))
+	var i int
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", i), string(iter2.Key()))
+		assert.Equal(t, int64(i), iter2.Value().Offset)
+		i++
+	}
+	assert.Equal(t, 200, i)
+	iter2.Seek([]byte("key-0150a"))
+	assert.Equal(t, "key-0151", string(iter2.Key()))
+	iter2.Close()
+
+	iter3 := sbt.Iterator(true)
+	i = 199
+	for ; iter3.Valid(); iter3.Next() {
+		assert.Equal(t, fmt.Sprintf("key-%04d", i), string(iter3.Key()))
+		i--
+	}
+	assert.Equal(t, 0, i)
+	iter3.Seek([]byte("key-0150a"))
+	assert.Equal(t, "key-0150", string(iter3.Key()))
+	iter3.Close()
+}
+
+func TestShardedBTree_Snapshot(t *testing.T) {
+	sbt := NewShardedBTree(4)
+	sbt.Put([]byte("aa"), &data.LogRecordPos{Fid: 1, Offset: 10})
+
+	snap := sbt.Snapshot()
+	sbt.Put([]byte("aa"), &data.LogRecordPos{Fid: 1, Offset: 20})
+	sbt.Put([]byte("bb"), &data.LogRecordPos{Fid: 1, Offset: 30})
+
+	assert.Equal(t, int64(10), snap.Get([]byte("aa")).Offset)
+	assert.Nil(t, snap.Get([]byte("bb")))
+	assert.Equal(t, 1, snap.Size())
+	assert.Equal(t, int64(20), sbt.Get([]byte("aa")).Offset)
+	assert.Equal(t, 2, sbt.Size())
+	assert.Nil(t, snap.Close())
+}
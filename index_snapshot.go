@@ -0,0 +1,297 @@
+package gobitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"go-bitcask/data"
+	"go-bitcask/fio"
+	"go-bitcask/index"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 写入索引快照时每次写入文件的数据量
+const indexSnapshotBufferSize = 4 * 1024 * 1024
+
+var errInvalidIndexSnapshot = errors.New("invalid index snapshot")
+
+// indexSnapshotMeta 索引快照的元数据，作为快照文件的最后一条记录写入，读取到这条记录说明快照是完整的
+//
+//	+-------------+-------------+-------------+-------------+-------------+--------------------------------+
+//	| 覆盖的文件id | 覆盖的偏移量  |  事务序列号  |   索引数量   | 可回收的文件数 |  每个文件可回收的字节数（fid, size） |
+//	+-------------+-------------+-------------+-------------+-------------+--------------------------------+
+//	    变长          变长           变长          变长           变长                    变长
+type indexSnapshotMeta struct {
+	pos         *data.LogRecordPos // 快照覆盖到的位置，之后写入的数据需要从数据文件中加载
+	seqNo       uint64
+	count       int64
+	reclaimable map[uint32]int64
+}
+
+func encodeIndexSnapshotMeta(meta *indexSnapshotMeta) []byte {
+	buf := make([]byte, binary.MaxVarintLen64*5+len(meta.reclaimable)*binary.MaxVarintLen64*2)
+	var index = 0
+	index += binary.PutVarint(buf[index:], int64(meta.pos.Fid))
+	index += binary.PutVarint(buf[index:], meta.pos.Offset)
+	index += binary.PutUvarint(buf[index:], meta.seqNo)
+	index += binary.PutVarint(buf[index:], meta.count)
+	index += binary.PutVarint(buf[index:], int64(len(meta.reclaimable)))
+	for fid, size := range meta.reclaimable {
+		index += binary.PutVarint(buf[index:], int64(fid))
+		index += binary.PutVarint(buf[index:], size)
+	}
+	return buf[:index]
+}
+
+func decodeIndexSnapshotMeta(buf []byte) (*indexSnapshotMeta, error) {
+	var index = 0
+	readVarint := func() (int64, error) {
+		v, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return 0, errInvalidIndexSnapshot
+		}
+		index += n
+		return v, nil
+	}
+
+	meta := &indexSnapshotMeta{pos: &data.LogRecordPos{}, reclaimable: make(map[uint32]int64)}
+	fid, err := readVarint()
+	if err != nil {
+		return nil, err
+	}
+	meta.pos.Fid = uint32(fid)
+	if meta.pos.Offset, err = readVarint(); err != nil {
+		return nil, err
+	}
+	seqNo, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil, errInvalidIndexSnapshot
+	}
+	index += n
+	meta.seqNo = seqNo
+	if meta.count, err = readVarint(); err != nil {
+		return nil, err
+	}
+	files, err := readVarint()
+	if err != nil {
+		return nil, err
+	}
+	for i := int64(0); i < files; i++ {
+		fid, err := readVarint()
+		if err != nil {
+			return nil, err
+		}
+		size, err := readVarint()
+		if err != nil {
+			return nil, err
+		}
+		meta.reclaimable[uint32(fid)] = size
+	}
+	return meta, nil
+}
+
+func (db *DB) indexSnapshotFileName() string {
+	return filepath.Join(db.options.DirPath, data.IndexSnapshotFileName)
+}
+
+// removeIndexSnapshot 删除索引快照，快照中的位置不再有效时调用
+func (db *DB) removeIndexSnapshot() error {
+	if err := os.Remove(db.indexSnapshotFileName()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// prepareIndexSnapshot 获取内存索引在当前时刻的快照以及对应的元数据，之后可以在不持有锁的情况下写入
+// 在使用此方法前必须持有互斥锁
+func (db *DB) prepareIndexSnapshot() (index.Indexer, *indexSnapshotMeta, error) {
+	// 快照引用的数据需要先持久化，否则崩溃之后快照可能指向不存在的数据
+	if err := db.activeFile.Sync(); err != nil {
+		return nil, nil, err
+	}
+	reclaimable := make(map[uint32]int64, len(db.reclaimable))
+	for fid, size := range db.reclaimable {
+		reclaimable[fid] = size
+	}
+	meta := &indexSnapshotMeta{
+		pos:         &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOff},
+		seqNo:       db.seqNo,
+		reclaimable: reclaimable,
+	}
+	return db.index.Snapshot(), meta, nil
+}
+
+// saveIndexSnapshot 将索引快照写入临时文件，完整写入之后再替换旧的快照文件
+func (db *DB) saveIndexSnapshot(idx index.Indexer, meta *indexSnapshotMeta) error {
+	defer idx.Close()
+
+	tmpFileName := db.indexSnapshotFileName() + ".tmp"
+	if err := os.Remove(tmpFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	snapshotFile, err := data.OpenIndexSnapshotFile(tmpFileName, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer snapshotFile.Close()
+
+	// 与 hint 文件一样，每个 key 写入一条记录，value 为编码之后的位置信息
+	buf := make([]byte, 0, indexSnapshotBufferSize)
+	writeRecord := func(logRecord *data.LogRecord) error {
+		if db.cipher != nil {
+			logRecord = db.cipher.Seal(logRecord)
+		}
+		encRecord, _ := data.EncodeLogRecord(logRecord)
+		buf = append(buf, encRecord...)
+		if len(buf) < indexSnapshotBufferSize {
+			return nil
+		}
+		err := snapshotFile.Write(buf)
+		buf = buf[:0]
+		return err
+	}
+
+	iterator := idx.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		err := writeRecord(&data.LogRecord{Key: iterator.Key(), Value: data.EncodeLogRecordPos(iterator.Value())})
+		if err != nil {
+			iterator.Close()
+			return err
+		}
+		meta.count++
+	}
+	iterator.Close()
+
+	// key 为空的最后一条记录保存元数据
+	if err := writeRecord(&data.LogRecord{Value: encodeIndexSnapshotMeta(meta)}); err != nil {
+		return err
+	}
+	if err := snapshotFile.Write(buf); err != nil {
+		return err
+	}
+	if err := snapshotFile.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, db.indexSnapshotFileName())
+}
+
+// writeIndexSnapshot 写入索引快照，与 merge 不能同时进行，merge 之后快照中的位置会失效
+func (db *DB) writeIndexSnapshot() error {
+	db.snapshotMu.Lock()
+	defer db.snapshotMu.Unlock()
+
+	db.lock()
+	if db.activeFile == nil {
+		db.unlock()
+		return nil
+	}
+	idx, meta, err := db.prepareIndexSnapshot()
+	db.unlock()
+	if err != nil {
+		return err
+	}
+	return db.saveIndexSnapshot(idx, meta)
+}
+
+// autoIndexSnapshot 后台定期写入索引快照
+func (db *DB) autoIndexSnapshot() {
+	defer db.bgWg.Done()
+
+	ticker := time.NewTicker(db.options.IndexSnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 写入失败时保留旧的快照，下次继续尝试
+			_ = db.writeIndexSnapshot()
+		case <-db.closeCh:
+			return
+		}
+	}
+}
+
+// loadIndexSnapshot 从索引快照中加载内存索引，返回快照覆盖到的位置
+// 快照不存在或者无效时返回空，无效的快照会被删除，之后从 hint 文件和数据文件中加载
+func (db *DB) loadIndexSnapshot() (*data.LogRecordPos, error) {
+	if _, err := os.Stat(db.indexSnapshotFileName()); os.IsNotExist(err) {
+		return nil, nil
+	}
+	meta, err := db.readIndexSnapshot()
+	if err == nil {
+		return meta.pos, nil
+	}
+	// 密钥错误时不能当作快照损坏处理
+	if errors.Is(err, ErrEncryptionKeyRequired) || errors.Is(err, ErrInvalidEncryptionKey) {
+		return nil, err
+	}
+
+	// 丢弃已经加载的部分索引
+	if err := db.index.Close(); err != nil {
+		return nil, err
+	}
+	db.index = index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrite)
+	db.reclaimable = make(map[uint32]int64)
+	db.seqNo = nonTransactionSeqNo
+	return nil, db.removeIndexSnapshot()
+}
+
+// readIndexSnapshot 读取索引快照并加载到内存索引中
+func (db *DB) readIndexSnapshot() (*indexSnapshotMeta, error) {
+	ioType := fio.StandardFIO
+	if db.options.MMapAtStartup {
+		ioType = fio.MemoryMap
+	}
+	snapshotFile, err := data.OpenIndexSnapshotFile(db.indexSnapshotFileName(), ioType)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshotFile.Close()
+	snapshotFile.Cipher = db.cipher
+
+	now := time.Now().UnixNano()
+	var count int64
+	var offset = snapshotFile.HeaderSize()
+	for {
+		logRecord, size, err := snapshotFile.ReadLogRecord(offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += size
+
+		if len(logRecord.Key) > 0 {
+			count++
+			if pos := data.DecodeLogRecordPos(logRecord.Value); !pos.IsExpired(now) {
+				db.index.Put(logRecord.Key, pos)
+			}
+			continue
+		}
+
+		// 最后一条记录，校验快照是否与数据文件一致
+		meta, err := decodeIndexSnapshotMeta(logRecord.Value)
+		if err != nil {
+			return nil, err
+		}
+		if meta.count != count || !db.coversPosition(meta.pos) {
+			return nil, errInvalidIndexSnapshot
+		}
+		db.reclaimable = meta.reclaimable
+		db.seqNo = meta.seqNo
+		return meta, nil
+	}
+}
+
+// coversPosition 判断数据文件中是否包含索引快照或者 B+ 树索引水位之前的所有数据
+func (db *DB) coversPosition(pos *data.LogRecordPos) bool {
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == pos.Fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.oldFiles[pos.Fid]
+	}
+	if dataFile == nil || pos.Offset < dataFile.HeaderSize() {
+		return false
+	}
+	size, err := dataFile.IoManager.Size()
+	return err == nil && size >= pos.Offset
+}
@@ -0,0 +1,169 @@
+package gobitcask
+
+import (
+	"go-bitcask/data"
+	"go-bitcask/utils"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_IndexSnapshot(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBtach(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(2000), []byte("batch")))
+	assert.Nil(t, wb.Commit())
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	seqNo := db.seqNo
+	assert.Nil(t, db.Close())
+
+	snapshotFile := filepath.Join(dir, data.IndexSnapshotFileName)
+	_, err = os.Stat(snapshotFile)
+	assert.Nil(t, err)
+
+	// 从快照中加载索引、可回收的数据量以及事务序列号
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 901, len(db.ListKeys()))
+	stat2, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, stat.ReclaimableSize, stat2.ReclaimableSize)
+	assert.Equal(t, seqNo, db.seqNo)
+	val, err := db.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), val)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 保留当前的快照，之后的写入在启动时从数据文件中加载
+	assert.Nil(t, db.writeIndexSnapshot())
+	oldSnapshot, err := os.ReadFile(snapshotFile)
+	assert.Nil(t, err)
+	for i := 1000; i < 1500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(500)))
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.WriteFile(snapshotFile, oldSnapshot, 0644))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1400, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(500))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(utils.GetTestKey(1499))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_IndexSnapshot_Invalid(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 没有完整写入的快照被丢弃，从数据文件中重新加载
+	snapshotFile := filepath.Join(dir, data.IndexSnapshotFileName)
+	info, err := os.Stat(snapshotFile)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(snapshotFile, info.Size()-10))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	_, err = os.Stat(snapshotFile)
+	assert.True(t, os.IsNotExist(err))
+
+	// merge 之后快照中的位置失效
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.writeIndexSnapshot())
+	assert.Nil(t, db.Merge())
+	_, err = os.Stat(snapshotFile)
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_IndexSnapshot_Disabled(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	// 默认不写入索引快照
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(64)))
+	assert.Nil(t, db.Close())
+	_, err = os.Stat(filepath.Join(dir, data.IndexSnapshotFileName))
+	assert.True(t, os.IsNotExist(err))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+}
+
+func TestDB_IndexSnapshot_ConcurrentMerge(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-index-snapshot")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexSnapshot = true
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%500), utils.RandomValue(64)))
+	}
+
+	// 写入快照不会导致 merge 返回 ErrMergeIsProgress，两者依次进行
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assert.Nil(t, db.writeIndexSnapshot())
+	}()
+	go func() {
+		defer wg.Done()
+		assert.Nil(t, db.Merge())
+	}()
+	wg.Wait()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db.ListKeys()))
+}
@@ -3,6 +3,7 @@ package gobitcask
 import (
 	"bytes"
 	"go-bitcask/index"
+	"time"
 )
 
 // Iterator 迭代器
@@ -10,15 +11,23 @@ type Iterator struct {
 	indexIter index.Iterator // 索引迭代器
 	db        *DB
 	options   IteratorOptions
+	readTime  int64 // 判断数据是否过期的时刻，为 0 时使用当前时间
+	tracked   bool  // 是否计入数据库未关闭的迭代器数量
 }
 
 // NewIterator 初始化迭代器
+// 迭代器未关闭期间，merge 会等待迭代器关闭之后再替换旧的数据文件
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
+	db.lock()
+	db.iterators++
+	db.unlock()
+
 	indexIter := db.index.Iterator(opts.Reverse)
 	return &Iterator{
 		indexIter: indexIter,
 		db:        db,
 		options:   opts,
+		tracked:   true,
 	}
 }
 
@@ -62,17 +71,28 @@ func (it *Iterator) Value() ([]byte, error) {
 // Close 关闭迭代器，释放相关资源
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	if it.tracked {
+		it.tracked = false
+		it.db.lock()
+		it.db.releaseIterator()
+		it.db.unlock()
+	}
 }
 
+// skipToNext 跳过前缀不匹配以及已经过期的 key
 func (it *Iterator) skipToNext() {
 	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return
+	now := it.readTime
+	if now == 0 {
+		now = time.Now().UnixNano()
 	}
 
-	for ; it.Valid(); it.Next() {
+	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		if it.indexIter.Value().IsExpired(now) {
+			continue
+		}
 		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Equal(it.options.Prefix, key[:prefixLen]) {
+		if prefixLen == 0 || prefixLen <= len(key) && bytes.Equal(it.options.Prefix, key[:prefixLen]) {
 			break
 		}
 	}
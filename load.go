@@ -0,0 +1,87 @@
+package gobitcask
+
+import (
+	"go-bitcask/data"
+	"sync"
+)
+
+// loadResult 单个数据文件读取到的索引信息
+type loadResult struct {
+	records  []*indexRecord // 文件中的所有记录
+	offset   int64          // 文件中有效数据的末尾位置，从 hint 文件读取时为 0
+	fromHint bool           // 是否从 hint 文件中读取
+	err      error
+}
+
+// loadPipeline 并发读取数据文件，结果按照文件的顺序依次取出
+// 同时读取以及等待处理的文件数量不超过 LoadConcurrency，避免占用过多内存
+type loadPipeline struct {
+	results  []chan *loadResult
+	sem      chan struct{}
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// readIndexRecordsConcurrently 在后台并发读取数据文件中的索引信息
+// snapshotPos 不为空时，它所在的数据文件从这个位置开始读取
+func (db *DB) readIndexRecordsConcurrently(dataFiles []*data.DataFile, snapshotPos *data.LogRecordPos) *loadPipeline {
+	concurrency := db.options.LoadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	lp := &loadPipeline{
+		results: make([]chan *loadResult, len(dataFiles)),
+		sem:     make(chan struct{}, concurrency),
+		stopCh:  make(chan struct{}),
+	}
+	for i := range lp.results {
+		lp.results[i] = make(chan *loadResult, 1)
+	}
+
+	go func() {
+		for i, dataFile := range dataFiles {
+			select {
+			case lp.sem <- struct{}{}:
+			case <-lp.stopCh:
+				return
+			}
+			go func(i int, dataFile *data.DataFile) {
+				lp.results[i] <- db.readFileIndexRecords(dataFile, snapshotPos)
+			}(i, dataFile)
+		}
+	}()
+	return lp
+}
+
+// readFileIndexRecords 读取单个数据文件的索引信息，写满的数据文件优先从 hint 文件中读取
+func (db *DB) readFileIndexRecords(dataFile *data.DataFile, snapshotPos *data.LogRecordPos) *loadResult {
+	// 索引快照覆盖到的数据文件只需要读取快照之后的数据
+	if snapshotPos != nil && snapshotPos.Fid == dataFile.FileId {
+		records, offset, err := readIndexRecords(dataFile, snapshotPos.Offset)
+		return &loadResult{records: records, offset: offset, err: err}
+	}
+	if dataFile != db.activeFile {
+		if records, ok := db.readHintRecords(dataFile); ok {
+			return &loadResult{records: records, fromHint: true}
+		}
+	}
+	records, offset, err := readIndexRecords(dataFile, dataFile.HeaderSize())
+	return &loadResult{records: records, offset: offset, err: err}
+}
+
+// wait 等待第 i 个文件读取完成
+func (lp *loadPipeline) wait(i int) *loadResult {
+	return <-lp.results[i]
+}
+
+// done 当前文件已经处理完成，可以开始读取下一个文件
+func (lp *loadPipeline) done() {
+	<-lp.sem
+}
+
+// stop 停止读取剩余的文件
+func (lp *loadPipeline) stop() {
+	lp.stopOnce.Do(func() {
+		close(lp.stopCh)
+	})
+}
@@ -0,0 +1,67 @@
+package gobitcask
+
+import (
+	"go-bitcask/data"
+	"go-bitcask/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Open_LoadConcurrency(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-load")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	// 同一个 key 在后面的文件中被覆盖写和删除
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new-value")))
+	}
+	for i := 500; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	// 跨越多个数据文件的事务
+	wb := db.NewWriteBtach(DefaultWriteBatchOptions)
+	for i := 1000; i < 1500; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte("batch-value")))
+	}
+	assert.Nil(t, wb.Commit())
+	seqNo := db.seqNo
+	reclaimable := db.reclaimableSize()
+	assert.Nil(t, db.Close())
+
+	for _, concurrency := range []int{0, 1, 4, 16} {
+		// 删除 hint 文件，从数据文件中构建索引
+		for fid := uint32(0); fid < 100; fid++ {
+			_ = os.Remove(data.GetHintFileName(dir, fid))
+		}
+		opts.LoadConcurrency = concurrency
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, seqNo, db2.seqNo)
+		assert.Equal(t, reclaimable, db2.reclaimableSize())
+		assert.Equal(t, 1500, len(db2.ListKeys()))
+		for i := 0; i < 2000; i++ {
+			val, err := db2.Get(utils.GetTestKey(i))
+			switch {
+			case i < 500:
+				assert.Equal(t, []byte("new-value"), val)
+			case i < 1000:
+				assert.Equal(t, ErrKeyNotFound, err)
+			case i < 1500:
+				assert.Equal(t, []byte("batch-value"), val)
+			default:
+				assert.Nil(t, err)
+			}
+		}
+		assert.Nil(t, db2.Close())
+	}
+}
@@ -2,12 +2,16 @@ package gobitcask
 
 import (
 	"go-bitcask/data"
+	"go-bitcask/fio"
+	"go-bitcask/utils"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -16,33 +20,72 @@ const (
 )
 
 // Merge 清理无效数据，生成 Hint File
+// 存在未关闭的迭代器时，等待迭代器全部关闭之后再替换旧的数据文件，因此不能在持有迭代器的 goroutine 中调用
 func (db *DB) Merge() error {
 	// 如果数据库为空，直接返回
 	if db.activeFile == nil {
 		return nil
 	}
-	db.mu.Lock()
+	// 等待正在写入的索引快照完成，merge 会删除快照文件
+	db.snapshotMu.Lock()
+	defer db.snapshotMu.Unlock()
+	db.lock()
 	// 如果 Merge 正在进行，直接返回
 	if db.isMerging {
-		db.mu.Unlock()
+		db.unlock()
 		return ErrMergeIsProgress
 	}
+	// 存活的快照仍然引用着旧的数据文件，不能进行 merge
+	if len(db.snapshots) > 0 {
+		db.unlock()
+		return ErrSnapshotIsAlive
+	}
+	// 检查磁盘剩余空间是否足够容纳 merge 之后的数据
+	totalSize, err := utils.DirSize(db.options.DirPath)
+	if err != nil {
+		db.unlock()
+		return err
+	}
+	availableSize, err := utils.AvailableDiskSize(db.options.DirPath)
+	if err != nil {
+		db.unlock()
+		return err
+	}
+	// value log 文件不参与 merge
+	vlogSize, err := db.valueLogSize()
+	if err != nil {
+		db.unlock()
+		return err
+	}
+	if uint64(totalSize-vlogSize-db.reclaimableSize()) >= availableSize {
+		db.unlock()
+		return ErrNoEnoughSpaceForMerge
+	}
+	// 正在流式写入的 value 的清单可能引用参与 merge 的文件
+	if !db.streamMu.TryLock() {
+		db.unlock()
+		return ErrStreamIsWriting
+	}
+
 	db.isMerging = true
 	defer func() {
+		db.lock()
 		db.isMerging = false
+		db.unlock()
+		db.streamMu.Unlock()
 	}()
 
 	// 持久化当前活跃文件
 	if err := db.activeFile.Sync(); err != nil {
-		db.mu.Unlock()
+		db.unlock()
 		return err
 	}
 	// 将当前活跃文件转换为旧的数据文件
 	db.oldFiles[db.activeFile.FileId] = db.activeFile
 	// 打开新的活跃文件
 	if err := db.setActiveDataFile(); err != nil {
-		db.mu.Unlock()
-		return nil
+		db.unlock()
+		return err
 	}
 	// 记录最近没有参与 merge 的文件 id
 	nonMergeFileIId := db.activeFile.FileId
@@ -52,12 +95,16 @@ func (db *DB) Merge() error {
 	for _, file := range db.oldFiles {
 		mergeFiles = append(mergeFiles, file)
 	}
-	db.mu.Unlock()
+	db.unlock()
 
 	// 从小到大排序待 merge 的file，依次merge
 	sort.Slice(mergeFiles, func(i, j int) bool {
 		return mergeFiles[i].FileId < mergeFiles[j].FileId
 	})
+	mergeFileMap := make(map[uint32]*data.DataFile, len(mergeFiles))
+	for _, dataFile := range mergeFiles {
+		mergeFileMap[dataFile.FileId] = dataFile
+	}
 
 	mergePath := db.getMergePath()
 	// 如果目录存在，说明发生过Merge，将其删除
@@ -74,21 +121,37 @@ func (db *DB) Merge() error {
 	mergeOption := db.options
 	mergeOption.DirPath = mergePath
 	mergeOption.SyncWrite = false
+	mergeOption.MergeCheckInterval = 0
+	// merge 的结果由 hint-index 文件加载索引，不需要索引快照
+	mergeOption.IndexSnapshot = false
+	mergeOption.IndexSnapshotInterval = 0
+	// 只记录了 value 位置的记录原样重写，不会拷贝 value log 中的 value
+	mergeOption.ValueLogThreshold = 0
 	mergeDB, err := Open(mergeOption)
 	if err != nil {
 		return err
 	}
-
-	// 打开 hint file 存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
-	if err != nil {
+	defer func() {
+		_ = mergeDB.Close()
+	}()
+	// merge 的结果由 hint-index 文件加载索引，不需要为每个数据文件生成 hint 文件
+	mergeDB.noHintFiles = true
+	// 保证至少有一个数据文件，安装 merge 结果时据此判断旧文件是否已经删除
+	if err := mergeDB.setActiveDataFile(); err != nil {
 		return err
 	}
 
 	// 遍历处理每个数据文件
-	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
+	now := time.Now().UnixNano()
+	for i := 0; i < len(mergeFiles); i++ {
+		dataFile := mergeFiles[i]
+		var offset = dataFile.HeaderSize()
+		// 启动时发现损坏的文件只读取损坏位置之前的数据，之后的数据已经被丢弃
+		validSize, corrupted := db.corrupted[dataFile.FileId]
 		for {
+			if corrupted && offset >= validSize {
+				break
+			}
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
@@ -99,35 +162,91 @@ func (db *DB) Merge() error {
 			// 解析拿到实际的 key
 			realKey, _ := parseLogRecordKey(logRecord.Key)
 			logRecordPos := db.index.Get(realKey)
-			// 与内存索引位置进行比较，如果有效则重写
-			if logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset {
+			// 与内存索引位置进行比较，如果有效则重写，已过期的数据直接丢弃
+			if logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset &&
+				!logRecordPos.IsExpired(now) {
 				// 清除事务标记
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+				// 压缩方式与当前配置不同时先解压，写入时使用当前的压缩方式重新压缩
+				if logRecord.Compression != db.options.Compression {
+					if err := db.decompressLogRecord(logRecord); err != nil {
+						return err
+					}
+				}
+				// 流式写入的 value 先重写每段数据，再写入新的清单
+				if logRecord.Type == data.LogRecordManifest {
+					if logRecord.Value, err = db.mergeStreamChunks(mergeDB, mergeFileMap, logRecord.Value); err != nil {
+						return err
+					}
+				}
 				pos, err := mergeDB.appendLogRecord(logRecord)
 				if err != nil {
 					return err
 				}
-				// 将当前位置索引写入 Hint File
-				if err = hintFile.WriteHintRecord(realKey, pos); err != nil {
-					return err
-				}
+				// 记录在 merge 实例的索引中，用于重写当前实例的索引
+				// 后加入 merge 的文件中有更新的数据时，之前重写的数据可以被回收
+				mergeDB.addStaleReclaimable(mergeDB.index.Put(realKey, pos))
 
 			}
 			// 递增 offset
 			offset += size
 		}
 
+		// 解压或者加密之后 merge 的结果可能比原来的数据更大，文件 id 不能与没有参与 merge 的文件重叠，
+		// 否则替换时会覆盖新写入的数据。此时跳过一段文件 id 留给 merge 的结果，merge 期间写满的文件也一起参与 merge
+		if i == len(mergeFiles)-1 && mergeDB.activeFile.FileId >= nonMergeFileIId {
+			newFiles, nextFileId, err := db.extendMerge(nonMergeFileIId, mergeDB.activeFile.FileId+1)
+			if err != nil {
+				return err
+			}
+			for _, newFile := range newFiles {
+				mergeFileMap[newFile.FileId] = newFile
+			}
+			mergeFiles = append(mergeFiles, newFiles...)
+			nonMergeFileIId = nextFileId
+		}
+	}
+
+	// 打开 hint file 存储索引
+	hintFile, err := data.OpenHintFile(mergePath, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	hintFile.Cipher = db.cipher
+	// 后加入 merge 的文件中可能有删除记录，这些文件在重启时不会被重新加载，
+	// 已经被删除的 key 不能写入 hint 文件，否则重启之后会重新出现
+	var deletedKeys [][]byte
+	iterator := mergeDB.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if db.index.Get(iterator.Key()) == nil {
+			deletedKeys = append(deletedKeys, append([]byte(nil), iterator.Key()...))
+			continue
+		}
+		if err := hintFile.WriteHintRecord(iterator.Key(), iterator.Value()); err != nil {
+			iterator.Close()
+			_ = hintFile.Close()
+			return err
+		}
+	}
+	iterator.Close()
+	for _, key := range deletedKeys {
+		mergeDB.addStaleReclaimable(mergeDB.index.Get(key))
+		mergeDB.index.Delete(key)
 	}
+
 	//  sync 保证数据持久化
 	if err := hintFile.Sync(); err != nil {
 		return err
 	}
+	if err := hintFile.Close(); err != nil {
+		return err
+	}
 	if err := mergeDB.Sync(); err != nil {
 		return err
 	}
 
 	// 写标识 merge 完成的文件
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
@@ -142,10 +261,329 @@ func (db *DB) Merge() error {
 	if err := mergeFinishedFile.Sync(); err != nil {
 		return err
 	}
+	if err := mergeFinishedFile.Close(); err != nil {
+		return err
+	}
+
+	// 用 merge 之后的文件替换旧的数据文件
+	installed, err := db.installMergeFiles(mergeDB, nonMergeFileIId)
+	if err != nil {
+		return err
+	}
+	if installed {
+		_ = mergeDB.Close()
+		return os.RemoveAll(mergePath)
+	}
+
+	// 参与 merge 的文件会在下次启动时被替换，不再计入可回收空间
+	db.lock()
+	for _, dataFile := range mergeFiles {
+		delete(db.reclaimable, dataFile.FileId)
+	}
+	db.unlock()
+
+	return nil
+}
+
+// extendMerge 将文件 id 不小于 nonMergeFileId 的旧数据文件以及当前活跃文件加入 merge，
+// 新的活跃文件至少跳过 reserved 个文件 id，返回按照文件 id 排序的新加入的文件，以及新的活跃文件 id
+func (db *DB) extendMerge(nonMergeFileId, reserved uint32) ([]*data.DataFile, uint32, error) {
+	db.lock()
+	defer db.unlock()
+
+	if err := db.activeFile.Sync(); err != nil {
+		return nil, 0, err
+	}
+	activeFile := db.activeFile
+	dataFile, err := data.OpenDataFile(db.options.DirPath, activeFile.FileId+1+reserved, fio.StandardFIO)
+	if err != nil {
+		return nil, 0, err
+	}
+	dataFile.Cipher = db.cipher
+	db.oldFiles[activeFile.FileId] = activeFile
+	db.activeFile = dataFile
+
+	var newFiles []*data.DataFile
+	for fid, oldFile := range db.oldFiles {
+		if fid >= nonMergeFileId {
+			newFiles = append(newFiles, oldFile)
+		}
+	}
+	sort.Slice(newFiles, func(i, j int) bool {
+		return newFiles[i].FileId < newFiles[j].FileId
+	})
+	return newFiles, dataFile.FileId, nil
+}
+
+// mergeStreamChunks 将清单中的每段数据重写到 merge 实例中，返回新的清单
+func (db *DB) mergeStreamChunks(mergeDB *DB, mergeFiles map[uint32]*data.DataFile, value []byte) ([]byte, error) {
+	manifest, err := decodeStreamManifest(value)
+	if err != nil {
+		return nil, err
+	}
+	for i, pos := range manifest.chunks {
+		// 每段数据都在清单之前写入，一定在参与 merge 的文件中
+		dataFile := mergeFiles[pos.Fid]
+		if dataFile == nil {
+			return nil, ErrDataFileNotFound
+		}
+		logRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
+		if err != nil {
+			return nil, err
+		}
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+		if logRecord.Compression != db.options.Compression {
+			if err := db.decompressLogRecord(logRecord); err != nil {
+				return nil, err
+			}
+		}
+		if manifest.chunks[i], err = mergeDB.appendLogRecord(logRecord); err != nil {
+			return nil, err
+		}
+	}
+	return encodeStreamManifest(manifest), nil
+}
+
+// installMergeFiles 将 merge 之后的数据文件替换掉旧的数据文件，并将内存索引更新到新的位置
+// 迭代器仍然可能读取旧的数据文件，先等待未关闭的迭代器全部关闭。
+// 存在快照或者数据库正在关闭时不能替换，返回 false，merge 的结果会在下次启动时生效
+func (db *DB) installMergeFiles(mergeDB *DB, nonMergeFileId uint32) (bool, error) {
+	db.mu.Lock()
+	for db.iterators > 0 && !db.isClosing() {
+		db.itersClosed.Wait()
+	}
+	db.indexMu.Lock()
+	defer db.unlock()
+
+	if len(db.snapshots) > 0 || db.iterators > 0 {
+		return false, nil
+	}
+
+	// 等待正在生成的 hint 文件，旧的数据文件之后会被删除
+	db.hintWg.Wait()
+
+	// 关闭参与了 merge 的旧数据文件
+	for fid, dataFile := range db.oldFiles {
+		if fid >= nonMergeFileId {
+			continue
+		}
+		if err := dataFile.Close(); err != nil {
+			return false, err
+		}
+		delete(db.oldFiles, fid)
+	}
+
+	// merge 之后的数据文件会复用旧的文件 id，缓存中的位置不再有效
+	if db.cache != nil {
+		db.cache.purge()
+	}
+
+	// 替换磁盘上的文件
+	if err := db.moveMergeFiles(mergeDB.options.DirPath, nonMergeFileId); err != nil {
+		return false, err
+	}
+
+	// 打开 merge 之后的数据文件
+	mergeFileIds := []uint32{mergeDB.activeFile.FileId}
+	for fid := range mergeDB.oldFiles {
+		mergeFileIds = append(mergeFileIds, fid)
+	}
+	for _, fid := range mergeFileIds {
+		dataFile, err := data.OpenDataFile(db.options.DirPath, fid, fio.StandardFIO)
+		if err != nil {
+			return false, err
+		}
+		dataFile.Cipher = db.cipher
+		db.oldFiles[fid] = dataFile
+	}
+
+	// 找出索引中仍然指向旧数据文件的 key，迭代期间不能修改索引
+	var keys [][]byte
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().Fid < nonMergeFileId {
+			keys = append(keys, append([]byte(nil), iterator.Key()...))
+		}
+	}
+	iterator.Close()
+
+	// 更新到 merge 之后的位置，不在 merge 结果中的 key 已经过期
+	for _, key := range keys {
+		if pos := mergeDB.index.Get(key); pos != nil {
+			db.index.Put(key, pos)
+		} else {
+			db.index.Delete(key)
+		}
+	}
+
+	// 重新统计可回收空间，merge 期间被覆盖写或删除的数据可以被回收
+	for fid := range db.reclaimable {
+		if fid < nonMergeFileId {
+			delete(db.reclaimable, fid)
+		}
+	}
+	// 损坏的文件已经被 merge 的结果替换，merge 的结果会复用这些文件 id
+	for fid := range db.corrupted {
+		if fid < nonMergeFileId {
+			delete(db.corrupted, fid)
+		}
+	}
+	for fid, size := range mergeDB.reclaimable {
+		db.reclaimable[fid] += size
+	}
+	iterator = mergeDB.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		mergePos := iterator.Value()
+		pos := db.index.Get(iterator.Key())
+		if pos == nil || pos.Fid != mergePos.Fid || pos.Offset != mergePos.Offset {
+			db.addStaleReclaimable(mergePos)
+		}
+	}
+
+	// B+ 树索引已经更新到 merge 之后的位置，重新记录水位
+	if err := db.saveWatermark(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// moveMergeFiles 用 merge 目录中的文件替换数据目录中的旧文件
+// 先删除不会被覆盖的旧数据文件，再按照文件 id 从小到大依次移动，最后移动 merge 完成的标识文件，
+// 中途崩溃时下次启动会重新执行，已经移动过的文件不会被误删
+func (db *DB) moveMergeFiles(mergePath string, nonMergeFileId uint32) error {
+	dirEntries, err := os.ReadDir(mergePath)
+	if err != nil {
+		return err
+	}
+	// 删除参与 merge 的数据文件对应的 hint 文件，同 id 的数据文件内容会被替换
+	if err := db.removeHintFiles(nonMergeFileId); err != nil {
+		return err
+	}
+	// 索引快照中的位置在替换之后不再有效
+	if err := db.removeIndexSnapshot(); err != nil {
+		return err
+	}
+	// B+ 树索引在更新到 merge 之后的位置之前崩溃，下次启动时需要重新构建
+	if err := db.clearWatermark(); err != nil {
+		return err
+	}
+
+	var fileIds []int
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+			if err != nil {
+				return ErrDataDirectoryCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	sort.Ints(fileIds)
+
+	// merge 目录中没有数据文件，说明已经全部移动过了，旧文件也已经删除
+	if len(fileIds) > 0 {
+		for fileId := uint32(fileIds[len(fileIds)-1]) + 1; fileId < nonMergeFileId; fileId++ {
+			fileName := data.GetDatafleName(db.options.DirPath, fileId)
+			if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
 
+	// 依次移动数据文件、hint 文件以及 merge 完成的标识文件，
+	// 同 id 的旧数据文件直接被覆盖
+	var fileNames []string
+	for _, fileId := range fileIds {
+		fileNames = append(fileNames, filepath.Base(data.GetDatafleName(mergePath, uint32(fileId))))
+	}
+	fileNames = append(fileNames, data.HintFileName, data.MergeFinishedFileName)
+	for _, fileName := range fileNames {
+		srcPath := filepath.Join(mergePath, fileName)
+		if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+			continue
+		}
+		destPath := filepath.Join(db.options.DirPath, fileName)
+		if err := os.Rename(srcPath, destPath); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// releaseIterator 减少未关闭的迭代器数量，全部关闭时唤醒等待替换 merge 结果的 goroutine
+// 在使用此方法前必须持有锁
+func (db *DB) releaseIterator() {
+	db.iterators--
+	if db.iterators == 0 {
+		db.itersClosed.Broadcast()
+	}
+}
+
+// isClosing 数据库是否已经开始关闭
+func (db *DB) isClosing() bool {
+	select {
+	case <-db.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// reclaimableSize 获取所有数据文件中可回收的字节数
+// 在使用此方法前必须持有锁
+func (db *DB) reclaimableSize() int64 {
+	var size int64
+	for _, n := range db.reclaimable {
+		size += n
+	}
+	return size
+}
+
+// needMerge 判断可回收数据的比例是否达到了自动 merge 的阈值
+func (db *DB) needMerge() (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	reclaimableSize := db.reclaimableSize()
+	if reclaimableSize == 0 {
+		return false, nil
+	}
+	totalSize, err := utils.DirSize(db.options.DirPath)
+	if err != nil {
+		return false, err
+	}
+	// value log 文件不参与 merge
+	vlogSize, err := db.valueLogSize()
+	if err != nil {
+		return false, err
+	}
+	totalSize -= vlogSize
+	if totalSize <= 0 {
+		return false, nil
+	}
+	return float32(reclaimableSize)/float32(totalSize) >= db.options.MergeRatio, nil
+}
+
+// autoMerge 后台定期检查可回收数据的比例，达到阈值时自动进行 merge
+func (db *DB) autoMerge() {
+	defer db.bgWg.Done()
+
+	ticker := time.NewTicker(db.options.MergeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if ok, err := db.needMerge(); err == nil && ok {
+				_ = db.Merge()
+			}
+		case <-db.closeCh:
+			return
+		}
+	}
+}
+
 func (db *DB) getMergePath() string {
 	dir := path.Dir(path.Clean(db.options.DirPath))
 	base := path.Base(db.options.DirPath)
@@ -171,13 +609,10 @@ func (db *DB) loadMergeFiles() error {
 
 	// 查找merge 完成的文件，判断merge是否处理完了
 	var mergeFinished bool
-	var mergeFileNames []string
-
 	for _, entry := range dirEntries {
 		if entry.Name() == data.MergeFinishedFileName {
 			mergeFinished = true
 		}
-		mergeFileNames = append(mergeFileNames, entry.Name())
 	}
 
 	// 没有merge完成则直接返回
@@ -187,25 +622,28 @@ func (db *DB) loadMergeFiles() error {
 
 	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
 	if err != nil {
-		return nil
+		return err
 	}
 
-	// 删除旧的数据文件
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDatafleName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err != nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
-			}
-		}
-	}
+	// 将新的数据文件移动到数据目录中，替换旧的数据文件
+	return db.moveMergeFiles(mergePath, nonMergeFileId)
+}
 
-	// 将新的数据文件移动到数据目录中
-	for _, fileName := range mergeFileNames {
-		srcPath := filepath.Join(mergePath, fileName)
-		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
+// removeHintFiles 删除文件 id 小于 nonMergeFileId 的数据文件对应的 hint 文件
+func (db *DB) removeHintFiles(nonMergeFileId uint32) error {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.HintFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.HintFileNameSuffix))
+		if err != nil || uint32(fileId) >= nonMergeFileId {
+			continue
+		}
+		if err := os.Remove(filepath.Join(db.options.DirPath, entry.Name())); err != nil {
 			return err
 		}
 	}
@@ -213,11 +651,12 @@ func (db *DB) loadMergeFiles() error {
 }
 
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath, fio.StandardFIO)
 	if err != nil {
 		return 0, err
 	}
-	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	defer mergeFinishedFile.Close()
+	record, _, err := mergeFinishedFile.ReadLogRecord(mergeFinishedFile.HeaderSize())
 	if err != nil {
 		return 0, err
 	}
@@ -237,13 +676,15 @@ func (db *DB) loadIndexFromHintFile() error {
 	}
 
 	// 打开 hint file
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := data.OpenHintFile(db.options.DirPath, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
 
 	// 读取 hint file中的索引
-	var offset int64 = 0
+	now := time.Now().UnixNano()
+	var offset = hintFile.HeaderSize()
 	for {
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
@@ -254,7 +695,9 @@ func (db *DB) loadIndexFromHintFile() error {
 		}
 		// 解码拿到实际位置的索引
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		if !pos.IsExpired(now) {
+			db.index.Put(logRecord.Key, pos)
+		}
 		offset += size
 	}
 	return nil
@@ -0,0 +1,370 @@
+package gobitcask
+
+import (
+	"bytes"
+	"go-bitcask/data"
+	"go-bitcask/utils"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Reclaimable(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-reclaim")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Equal(t, int64(0), db.reclaimableSize())
+
+	// 覆盖写和删除都会产生可回收的数据
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 50; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	reclaimable := db.reclaimableSize()
+	assert.True(t, reclaimable > 0)
+
+	// 重启之后可回收的数据量保持一致
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, reclaimable, db2.reclaimableSize())
+}
+
+func TestDB_AutoMerge(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeRatio = 0.5
+	opts.MergeCheckInterval = 20 * time.Millisecond
+	db, err := Open(opts)
+	defer func() {
+		_ = os.RemoveAll(db.getMergePath())
+	}()
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+
+	// 等待后台完成 merge，旧的数据文件被清理
+	assert.Eventually(t, func() bool {
+		stat1, err := db.Stat()
+		return err == nil && stat1.DiskSize < stat.DiskSize/2
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestDB_Open_InvalidMergeRatio(t *testing.T) {
+	opts := DefaultOption
+	opts.MergeRatio = 1.5
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_Merge_Online(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-online")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new-value")))
+	}
+	for i := 1000; i < 1500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+
+	// merge 期间读写都能够正常进行
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 2000; i < 3000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new-value")))
+			val, err := db.Get(utils.GetTestKey(i % 1000))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("new-value"), val)
+		}
+	}()
+	assert.Nil(t, db.Merge())
+	wg.Wait()
+
+	// merge 之后立即生效，不需要重启
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	stat1, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2500), stat1.KeyNum)
+	assert.True(t, stat1.DiskSize < stat.DiskSize)
+	assert.True(t, stat1.DataFileNum < stat.DataFileNum)
+
+	check := func(db *DB) {
+		for i := 0; i < 3000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			if i >= 1000 && i < 1500 {
+				assert.Equal(t, ErrKeyNotFound, err)
+				continue
+			}
+			assert.Nil(t, err)
+			if i < 1000 || i >= 2000 {
+				assert.Equal(t, []byte("new-value"), val)
+			}
+		}
+	}
+	check(db)
+
+	// 重启之后数据保持一致
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	check(db2)
+	assert.Nil(t, db2.Merge())
+	check(db2)
+}
+
+func TestDB_Merge_WithIterator(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-iter")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 存在未关闭的迭代器时，merge 等待迭代器关闭之后再替换旧的数据文件
+	iter := db.NewIterator(DefaultIteratorOption)
+	done := make(chan error)
+	go func() {
+		done <- db.Merge()
+	}()
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		_, err := iter.Value()
+		assert.Nil(t, err)
+		count++
+	}
+	assert.Equal(t, 50, count)
+	select {
+	case <-done:
+		t.Fatal("merge installed while an iterator was open")
+	case <-time.After(100 * time.Millisecond):
+	}
+	iter.Close()
+	assert.Nil(t, <-done)
+
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stat.ReclaimableSize)
+	for i := 50; i < 100; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 关闭数据库时不再等待，merge 的结果在下次启动时生效
+	assert.Nil(t, db.Put(utils.GetTestKey(100), utils.RandomValue(128)))
+	assert.Nil(t, db.Delete(utils.GetTestKey(100)))
+	iter = db.NewIterator(DefaultIteratorOption)
+	go func() {
+		done <- db.Merge()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, db.Close())
+	assert.Nil(t, <-done)
+	iter.Close()
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, len(db2.ListKeys()))
+	_, err = os.Stat(db2.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_Merge_UpgradeLegacyFiles(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-legacy")
+	opts.DirPath = dir
+
+	// 构造没有文件头的旧数据文件
+	var buf []byte
+	for i := 0; i < 100; i++ {
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+			Key:   logRecordKeyWithSeq(utils.GetTestKey(i), nonTransactionSeqNo),
+			Value: utils.GetTestKey(i),
+		})
+		buf = append(buf, encRecord...)
+	}
+	assert.Nil(t, os.WriteFile(data.GetDatafleName(dir, 0), buf, 0644))
+
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Nil(t, db.activeFile.Header)
+	assert.Nil(t, db.Put(utils.GetTestKey(100), utils.GetTestKey(100)))
+	for i := 0; i <= 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	// merge 之后所有文件都带有文件头
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		if entry.Name() == fileLockName {
+			continue
+		}
+		buf, err := os.ReadFile(dir + "/" + entry.Name())
+		assert.Nil(t, err)
+		assert.True(t, bytes.HasPrefix(buf, []byte("BTCK")), entry.Name())
+	}
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 101, len(db.ListKeys()))
+	for i := 0; i <= 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+func TestDB_Merge_OutputLargerThanInput(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-larger-output")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.Compression = FlateCompression
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	value := bytes.Repeat([]byte("a"), 2048)
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	assert.Nil(t, db.Close())
+
+	// 关闭压缩之后 merge 的结果远大于原来的数据，需要跳过一段文件 id，merge 仍然能够完成
+	opts.Compression = NoCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Put(utils.GetTestKey(200), value))
+
+	check := func(db *DB) {
+		assert.Equal(t, 201, len(db.ListKeys()))
+		for i := 0; i <= 200; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+	}
+	check(db)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 再次 merge 时没有解压的数据，结果不会超过原来的数据
+	assert.Nil(t, db.Merge())
+	check(db)
+}
+
+func TestDB_Merge_OutputLargerThanInputWithWrites(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-larger-output-writes")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.Compression = FlateCompression
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	value := bytes.Repeat([]byte("a"), 2048)
+	for i := 0; i < 400; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	assert.Nil(t, db.Close())
+
+	opts.Compression = NoCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+
+	// merge 期间删除和覆盖写，写满的文件可能被加入 merge
+	newValue := bytes.Repeat([]byte("b"), 2048)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			assert.Nil(t, db.Put(utils.GetTestKey(100+i), newValue))
+		}
+	}()
+	assert.Nil(t, db.Merge())
+	<-done
+
+	check := func(db *DB) {
+		assert.Equal(t, 300, len(db.ListKeys()))
+		for i := 0; i < 400; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			switch {
+			case i < 100:
+				assert.Equal(t, ErrKeyNotFound, err)
+			case i < 200:
+				assert.Nil(t, err)
+				assert.Equal(t, newValue, val)
+			default:
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+		}
+	}
+	check(db)
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
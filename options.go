@@ -1,6 +1,10 @@
 package gobitcask
 
-import "os"
+import (
+	"os"
+	"runtime"
+	"time"
+)
 
 type Options struct {
 	// 数据库存放数据的目录
@@ -20,6 +24,51 @@ type Options struct {
 
 	// 启动时是否需要以 mmap 的方式加载
 	MMapAtStartup bool
+
+	// 可回收数据占总数据量的比例达到该阈值时，自动进行 merge
+	MergeRatio float32
+
+	// 后台检查是否需要 merge 的时间间隔，为 0 时不开启自动 merge
+	MergeCheckInterval time.Duration
+
+	// 启动时并发读取数据文件构建索引的数量，小于等于 1 时依次读取
+	LoadConcurrency int
+
+	// 写满的数据文件中存在损坏的数据时，是否丢弃损坏位置之后的数据继续启动
+	// 活跃文件末尾没有完整写入的数据总是会被截断
+	TolerateCorruption bool
+
+	// 写入时 value 的压缩方式，已经写入的数据在 merge 时会使用新的压缩方式重新压缩
+	Compression CompressionType
+
+	// Compression 为 CustomCompression 时使用的压缩实现
+	Compressor Compressor
+
+	// 加密数据文件和 hint 文件中 key 和 value 的 AES 密钥，长度为 16、24 或 32 字节，为空时不加密
+	EncryptionKey []byte
+
+	// 轮换之前使用的密钥，只用于读取旧的数据，merge 时所有数据都会使用 EncryptionKey 重新加密
+	OldEncryptionKeys [][]byte
+
+	// value 的长度达到这个阈值时单独存储到 value log 文件中，数据文件中只记录 value 的位置，
+	// merge 时不再需要拷贝较大的 value，0 表示不开启
+	ValueLogThreshold int
+
+	// value log 文件的大小
+	ValueLogFileSize int64
+
+	// value log 文件中无效数据的比例达到这个阈值时，ValueLogGC 才会重写这个文件
+	ValueLogGCRatio float32
+
+	// 读取 value 的 LRU 缓存的最大字节数，按照数据记录的位置缓存解码之后的 value，0 表示不开启
+	ValueCacheSize int64
+
+	// 关闭时将内存索引写入快照文件，下次启动时加载快照，只需要从数据文件中加载快照之后写入的数据，
+	// B+ 树索引已经持久化在磁盘上，不需要快照；默认关闭
+	IndexSnapshot bool
+
+	// 后台定期写入索引快照的时间间隔，为 0 时只在关闭时写入
+	IndexSnapshotInterval time.Duration
 }
 
 // IteratorOptions 迭代器配置项
@@ -47,15 +96,42 @@ const (
 
 	// B+Tree 索引, 索引在磁盘上
 	BPlusTree
+
+	// 自适应基数树索引，比 BTree 占用更少的内存
+	ART
+
+	// 按照 key 的哈希值分片的 BTree 索引，写入时只有追加数据文件需要持有互斥锁，不同分片的索引更新可以并发进行
+	ShardedBTree
+)
+
+type CompressionType = uint8
+
+const (
+	// 不压缩
+	NoCompression CompressionType = iota
+
+	// 使用 compress/flate 压缩
+	FlateCompression
+
+	// 使用 compress/gzip 压缩
+	GzipCompression
+
+	// 使用 Options.Compressor 自定义的实现压缩
+	CustomCompression
 )
 
 var DefaultOption = Options{
-	DirPath:       os.TempDir(),
-	DataFileSize:  256 * 1024 * 1024, // 256MB
-	IndexType:     BTree,
-	SyncWrite:     false,
-	BytesPerSync:  0,
-	MMapAtStartup: true,
+	DirPath:         os.TempDir(),
+	DataFileSize:    256 * 1024 * 1024, // 256MB
+	IndexType:       BTree,
+	SyncWrite:       false,
+	BytesPerSync:    0,
+	MMapAtStartup:   true,
+	MergeRatio:      0.5,
+	LoadConcurrency: runtime.NumCPU(),
+
+	ValueLogFileSize: 1024 * 1024 * 1024, // 1GB
+	ValueLogGCRatio:  0.5,
 }
 
 var DefaultIteratorOption = IteratorOptions{
@@ -0,0 +1,70 @@
+package gobitcask
+
+import (
+	"errors"
+	"fmt"
+	"go-bitcask/data"
+	"io"
+	"os"
+)
+
+// RecoveryReport 启动时修复数据文件的报告
+type RecoveryReport struct {
+	Files []*RecoveredFile // 发现损坏数据的文件，没有损坏时为空
+}
+
+// RecoveredFile 单个数据文件的修复信息
+type RecoveredFile struct {
+	FileId         uint32 // 文件 id
+	ValidSize      int64  // 最后一条有效记录的末尾位置
+	DiscardedBytes int64  // 被丢弃的字节数
+	Truncated      bool   // 文件是否已经被截断到 ValidSize
+	Err            error  // 读取时遇到的错误
+}
+
+// RecoveryReport 返回启动时修复数据文件的报告
+func (db *DB) RecoveryReport() *RecoveryReport {
+	return db.recovery
+}
+
+// isCorruptedRecord 判断错误是否是由于记录损坏或者没有完整写入导致的
+func isCorruptedRecord(err error) bool {
+	return errors.Is(err, data.ErrInvalidCRC) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// recoverDataFile 处理读取数据文件时遇到的损坏数据
+// 活跃文件末尾的损坏数据是写入过程中崩溃导致的，直接截断；
+// 写满的数据文件中出现损坏数据时，只有配置了 TolerateCorruption 才会丢弃损坏位置之后的数据继续启动，
+// 这些文件保持不变，merge 时只读取损坏位置之前的数据
+func (db *DB) recoverDataFile(dataFile *data.DataFile, isActiveFile bool, result *loadResult) error {
+	// 密钥错误时数据本身没有损坏，不能丢弃
+	if errors.Is(result.err, ErrEncryptionKeyRequired) || errors.Is(result.err, ErrInvalidEncryptionKey) {
+		return fmt.Errorf("%w: file %d at offset %d", result.err, dataFile.FileId, result.offset)
+	}
+	if !isCorruptedRecord(result.err) || !isActiveFile && !db.options.TolerateCorruption {
+		return fmt.Errorf("%w: file %d at offset %d, %v",
+			ErrDataFileCorrupted, dataFile.FileId, result.offset, result.err)
+	}
+
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	recovered := &RecoveredFile{
+		FileId:         dataFile.FileId,
+		ValidSize:      result.offset,
+		DiscardedBytes: fileSize - result.offset,
+		Err:            result.err,
+	}
+	if isActiveFile {
+		fileName := data.GetDatafleName(db.options.DirPath, dataFile.FileId)
+		if err := os.Truncate(fileName, result.offset); err != nil {
+			return err
+		}
+		recovered.Truncated = true
+	} else {
+		db.corrupted[dataFile.FileId] = result.offset
+	}
+	db.recovery.Files = append(db.recovery.Files, recovered)
+	return nil
+}
@@ -0,0 +1,119 @@
+package gobitcask
+
+import (
+	"errors"
+	"go-bitcask/data"
+	"go-bitcask/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Open_TornWrite(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-torn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 模拟写入过程中崩溃，活跃文件末尾只有一部分记录
+	fileName := data.GetDatafleName(dir, 0)
+	info, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	validSize := info.Size()
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(utils.GetTestKey(100), nonTransactionSeqNo),
+		Value: utils.RandomValue(24),
+	})
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(encRecord[:len(encRecord)/2])
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	report := db2.RecoveryReport()
+	assert.Equal(t, 1, len(report.Files))
+	assert.Equal(t, uint32(0), report.Files[0].FileId)
+	assert.Equal(t, validSize, report.Files[0].ValidSize)
+	assert.Equal(t, int64(len(encRecord)/2), report.Files[0].DiscardedBytes)
+	assert.True(t, report.Files[0].Truncated)
+	info, err = os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, validSize, info.Size())
+
+	// 截断之后可以继续写入
+	assert.Nil(t, db2.Put(utils.GetTestKey(100), []byte("value")))
+	assert.Nil(t, db2.Close())
+
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db3.RecoveryReport().Files))
+	assert.Equal(t, 101, len(db3.ListKeys()))
+	val, err := db3.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
+
+func TestDB_Open_CorruptedSealedFile(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-corrupted")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 损坏第一个数据文件中间的数据
+	fileName := data.GetDatafleName(dir, 0)
+	buf, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	buf[len(buf)/2] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, buf, 0644))
+	assert.Nil(t, os.Remove(data.GetHintFileName(dir, 0)))
+
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDataFileCorrupted))
+
+	opts.TolerateCorruption = true
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	report := db2.RecoveryReport()
+	assert.Equal(t, 1, len(report.Files))
+	assert.Equal(t, uint32(0), report.Files[0].FileId)
+	assert.False(t, report.Files[0].Truncated)
+	assert.True(t, report.Files[0].ValidSize < int64(len(buf)))
+
+	// 损坏位置之后的数据被丢弃，其余数据正常读取
+	keys := db2.ListKeys()
+	assert.True(t, len(keys) > 0 && len(keys) < 500)
+	val, err := db2.Get(utils.GetTestKey(499))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// merge 跳过损坏位置之后的数据，之后不会再遇到损坏的文件
+	assert.Nil(t, db2.Merge())
+	assert.Equal(t, len(keys), len(db2.ListKeys()))
+	assert.Nil(t, db2.Close())
+	opts.TolerateCorruption = false
+	db2, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db2.RecoveryReport().Files))
+	assert.Equal(t, len(keys), len(db2.ListKeys()))
+	assert.Nil(t, db2.Merge())
+}
@@ -0,0 +1,191 @@
+package redis
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const (
+	maxMetadataSize   = 1 + binary.MaxVarintLen64*2 + binary.MaxVarintLen32
+	extraListMetaSize = binary.MaxVarintLen64 * 2
+
+	// 列表的起始下标，从中间开始向两端扩展
+	initialListMark = math.MaxUint64 / 2
+)
+
+// 有序集合中子 key 的类型标识
+const (
+	zsetMemberTag byte = 'm' // member -> score
+	zsetScoreTag  byte = 's' // score + member，用于按分数排序
+)
+
+// metadata 复杂数据结构的元数据
+type metadata struct {
+	dataType redisDataType // 数据类型
+	version  int64         // 版本号，删除后重新创建时旧的子 key 自动失效
+	size     uint32        // 数据量
+	head     uint64        // List 专用
+	tail     uint64        // List 专用
+}
+
+// encode 编码元数据
+//
+//	+----------+------------+-----------+-----------+-----------+
+//	|   type   |  version   |    size   |   head    |    tail   |
+//	+----------+------------+-----------+-----------+-----------+
+//	    1字节     变长（最大10） 变长（最大5）  List 专用    List 专用
+func (md *metadata) encode() []byte {
+	var size = maxMetadataSize
+	if md.dataType == List {
+		size += extraListMetaSize
+	}
+	buf := make([]byte, size)
+
+	buf[0] = md.dataType
+	var index = 1
+	index += binary.PutVarint(buf[index:], md.version)
+	index += binary.PutVarint(buf[index:], int64(md.size))
+	if md.dataType == List {
+		index += binary.PutUvarint(buf[index:], md.head)
+		index += binary.PutUvarint(buf[index:], md.tail)
+	}
+	return buf[:index]
+}
+
+// decodeMetadata 解码元数据
+func decodeMetadata(buf []byte) (*metadata, error) {
+	if len(buf) == 0 {
+		return nil, ErrInvalidValue
+	}
+	dataType := buf[0]
+
+	var index = 1
+	version, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, ErrInvalidValue
+	}
+	index += n
+	size, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, ErrInvalidValue
+	}
+	index += n
+
+	var head, tail uint64
+	if dataType == List {
+		if head, n = binary.Uvarint(buf[index:]); n <= 0 {
+			return nil, ErrInvalidValue
+		}
+		index += n
+		if tail, n = binary.Uvarint(buf[index:]); n <= 0 {
+			return nil, ErrInvalidValue
+		}
+	}
+
+	return &metadata{
+		dataType: dataType,
+		version:  version,
+		size:     uint32(size),
+		head:     head,
+		tail:     tail,
+	}, nil
+}
+
+// subKeyPrefix 子 key 的公共前缀：key 长度 + key + version
+// 带上 key 的长度，避免不同 key 的子 key 之间互相成为前缀
+func subKeyPrefix(key []byte, version int64, extra int) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+len(key)+8, binary.MaxVarintLen32+len(key)+8+extra)
+	var index = binary.PutUvarint(buf, uint64(len(key)))
+	index += copy(buf[index:], key)
+	binary.BigEndian.PutUint64(buf[index:], uint64(version))
+	return buf[:index+8]
+}
+
+// hashInternalKey Hash 中 field 对应的实际 key
+type hashInternalKey struct {
+	key     []byte
+	version int64
+	field   []byte
+}
+
+func (hk *hashInternalKey) encode() []byte {
+	return append(subKeyPrefix(hk.key, hk.version, len(hk.field)), hk.field...)
+}
+
+// setInternalKey Set 中 member 对应的实际 key
+type setInternalKey struct {
+	key     []byte
+	version int64
+	member  []byte
+}
+
+func (sk *setInternalKey) encode() []byte {
+	return append(subKeyPrefix(sk.key, sk.version, len(sk.member)), sk.member...)
+}
+
+// listInternalKey List 中每个元素对应的实际 key
+type listInternalKey struct {
+	key     []byte
+	version int64
+	index   uint64
+}
+
+func (lk *listInternalKey) encode() []byte {
+	buf := subKeyPrefix(lk.key, lk.version, 8)
+	return binary.BigEndian.AppendUint64(buf, lk.index)
+}
+
+// zsetInternalKey ZSet 中 member 对应的实际 key
+type zsetInternalKey struct {
+	key     []byte
+	version int64
+	member  []byte
+	score   float64
+}
+
+// encodeWithMember 编码 member -> score 的 key
+func (zk *zsetInternalKey) encodeWithMember() []byte {
+	buf := subKeyPrefix(zk.key, zk.version, 1+len(zk.member))
+	buf = append(buf, zsetMemberTag)
+	return append(buf, zk.member...)
+}
+
+// encodeWithScore 编码 score + member 的 key，按照 score 有序
+func (zk *zsetInternalKey) encodeWithScore() []byte {
+	buf := subKeyPrefix(zk.key, zk.version, 1+8+len(zk.member))
+	buf = append(buf, zsetScoreTag)
+	buf = binary.BigEndian.AppendUint64(buf, sortableFloat64(zk.score))
+	return append(buf, zk.member...)
+}
+
+// sortableFloat64 将 float64 编码为按字节序比较与数值大小一致的 uint64
+func sortableFloat64(f float64) uint64 {
+	// -0 与 0 相等，统一编码为 +0，否则 -0 的符号位会使它排在所有负数之前
+	if f == 0 {
+		f = 0
+	}
+	bits := math.Float64bits(f)
+	if f >= 0 {
+		return bits ^ (1 << 63)
+	}
+	return ^bits
+}
+
+// encodeScore 编码 score
+func encodeScore(score float64) []byte {
+	return binary.BigEndian.AppendUint64(nil, math.Float64bits(score))
+}
+
+// decodeScore 解码 score
+func decodeScore(buf []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(buf))
+}
+
+// decodeSortableFloat64 解码 sortableFloat64 编码的数据
+func decodeSortableFloat64(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		return math.Float64frombits(bits ^ (1 << 63))
+	}
+	return math.Float64frombits(^bits)
+}
@@ -0,0 +1,667 @@
+package redis
+
+import (
+	"errors"
+	gobitcask "go-bitcask"
+	"sync"
+	"time"
+)
+
+var (
+	ErrWrongTypeOperation = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrInvalidValue       = errors.New("ERR the stored value cannot be decoded")
+)
+
+type redisDataType = byte
+
+// Redis 数据类型枚举
+const (
+	String redisDataType = iota
+	Hash
+	Set
+	List
+	ZSet
+)
+
+// RedisDataStructure 基于 bitcask 存储引擎实现的 Redis 数据结构服务
+type RedisDataStructure struct {
+	db *gobitcask.DB
+	// 读-改-写 元数据的操作需要串行执行，写入本身由 WriteBatch 保证原子性
+	mu *sync.Mutex
+}
+
+// NewRedisDataStructure 初始化 Redis 数据结构服务
+func NewRedisDataStructure(options gobitcask.Options) (*RedisDataStructure, error) {
+	db, err := gobitcask.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisDataStructure{db: db, mu: new(sync.Mutex)}, nil
+}
+
+// Close 关闭底层存储引擎
+func (rds *RedisDataStructure) Close() error {
+	return rds.db.Close()
+}
+
+// ================================ String 数据结构 ================================
+
+// Set 写入字符串，ttl 为 0 表示永不过期
+// key 原来是 Hash、Set、List、ZSet 时，旧的子 key 一起删除
+func (rds *RedisDataStructure) Set(key []byte, ttl time.Duration, value []byte) error {
+	if value == nil {
+		return nil
+	}
+
+	// 编码 value : type + payload
+	encValue := make([]byte, 1+len(value))
+	encValue[0] = String
+	copy(encValue[1:], value)
+
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	subKeys, err := rds.findSubKeys(key)
+	if err != nil {
+		return err
+	}
+	if len(subKeys) == 0 {
+		if ttl > 0 {
+			return rds.db.PutWithTTL(key, encValue, ttl)
+		}
+		return rds.db.Put(key, encValue)
+	}
+
+	wb := rds.db.NewWriteBtach(gobitcask.DefaultWriteBatchOptions)
+	if ttl > 0 {
+		err = wb.PutWithTTL(key, encValue, ttl)
+	} else {
+		err = wb.Put(key, encValue)
+	}
+	if err != nil {
+		return err
+	}
+	return rds.commitWithSubKeys(wb, subKeys)
+}
+
+// Get 读取字符串
+func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
+	encValue, err := rds.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(encValue) == 0 {
+		return nil, ErrInvalidValue
+	}
+	if encValue[0] != String {
+		return nil, ErrWrongTypeOperation
+	}
+	return encValue[1:], nil
+}
+
+// ================================ Hash 数据结构 ================================
+
+// HSet 写入 Hash 中的 field，返回 field 是否是新增的
+func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return false, err
+	}
+
+	hk := &hashInternalKey{key: key, version: meta.version, field: field}
+	encKey := hk.encode()
+
+	// 查找 field 是否已经存在
+	var exist = true
+	if _, err = rds.db.Get(encKey); err == gobitcask.ErrKeyNotFound {
+		exist = false
+	} else if err != nil {
+		return false, err
+	}
+
+	// 元数据和数据原子写入
+	wb := rds.db.NewWriteBtach(gobitcask.DefaultWriteBatchOptions)
+	if !exist {
+		meta.size++
+		_ = wb.Put(key, meta.encode())
+	}
+	_ = wb.Put(encKey, value)
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return !exist, nil
+}
+
+// HGet 读取 Hash 中的 field
+func (rds *RedisDataStructure) HGet(key, field []byte) ([]byte, error) {
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return nil, err
+	}
+	if meta.size == 0 {
+		return nil, gobitcask.ErrKeyNotFound
+	}
+
+	hk := &hashInternalKey{key: key, version: meta.version, field: field}
+	return rds.db.Get(hk.encode())
+}
+
+// HDel 删除 Hash 中的 field，返回 field 是否存在
+func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return false, err
+	}
+	if meta.size == 0 {
+		return false, nil
+	}
+
+	hk := &hashInternalKey{key: key, version: meta.version, field: field}
+	encKey := hk.encode()
+	if _, err = rds.db.Get(encKey); err == gobitcask.ErrKeyNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	wb := rds.db.NewWriteBtach(gobitcask.DefaultWriteBatchOptions)
+	meta.size--
+	_ = wb.Put(key, meta.encode())
+	_ = wb.Delete(encKey)
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// HLen 返回 Hash 中 field 的数量
+func (rds *RedisDataStructure) HLen(key []byte) (uint32, error) {
+	return rds.size(key, Hash)
+}
+
+// HGetAll 返回 Hash 中所有的 field 和 value
+func (rds *RedisDataStructure) HGetAll(key []byte) (map[string][]byte, error) {
+	meta, err := rds.findMetadata(key, Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]byte, meta.size)
+	if meta.size == 0 {
+		return result, nil
+	}
+	prefix := subKeyPrefix(key, meta.version, 0)
+	err = rds.scanPrefix(prefix, func(subKey, value []byte) bool {
+		result[string(subKey[len(prefix):])] = value
+		return true
+	})
+	return result, err
+}
+
+// ================================ Set 数据结构 ================================
+
+// SAdd 向 Set 中添加 member，返回 member 是否是新增的
+func (rds *RedisDataStructure) SAdd(key, member []byte) (bool, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return false, err
+	}
+
+	sk := &setInternalKey{key: key, version: meta.version, member: member}
+	encKey := sk.encode()
+	if _, err = rds.db.Get(encKey); err == nil {
+		return false, nil
+	} else if err != gobitcask.ErrKeyNotFound {
+		return false, err
+	}
+
+	wb := rds.db.NewWriteBtach(gobitcask.DefaultWriteBatchOptions)
+	meta.size++
+	_ = wb.Put(key, meta.encode())
+	_ = wb.Put(encKey, nil)
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SIsMember 判断 member 是否在 Set 中
+func (rds *RedisDataStructure) SIsMember(key, member []byte) (bool, error) {
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return false, err
+	}
+	if meta.size == 0 {
+		return false, nil
+	}
+
+	sk := &setInternalKey{key: key, version: meta.version, member: member}
+	_, err = rds.db.Get(sk.encode())
+	if err == gobitcask.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SRem 从 Set 中删除 member，返回 member 是否存在
+func (rds *RedisDataStructure) SRem(key, member []byte) (bool, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return false, err
+	}
+	if meta.size == 0 {
+		return false, nil
+	}
+
+	sk := &setInternalKey{key: key, version: meta.version, member: member}
+	encKey := sk.encode()
+	if _, err = rds.db.Get(encKey); err == gobitcask.ErrKeyNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	wb := rds.db.NewWriteBtach(gobitcask.DefaultWriteBatchOptions)
+	meta.size--
+	_ = wb.Put(key, meta.encode())
+	_ = wb.Delete(encKey)
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SCard 返回 Set 中 member 的数量
+func (rds *RedisDataStructure) SCard(key []byte) (uint32, error) {
+	return rds.size(key, Set)
+}
+
+// SMembers 返回 Set 中所有的 member
+func (rds *RedisDataStructure) SMembers(key []byte) ([][]byte, error) {
+	meta, err := rds.findMetadata(key, Set)
+	if err != nil {
+		return nil, err
+	}
+
+	var members [][]byte
+	if meta.size == 0 {
+		return members, nil
+	}
+	prefix := subKeyPrefix(key, meta.version, 0)
+	err = rds.scanPrefix(prefix, func(subKey, _ []byte) bool {
+		members = append(members, subKey[len(prefix):])
+		return true
+	})
+	return members, err
+}
+
+// ================================ List 数据结构 ================================
+
+// LPush 从左侧插入元素，返回 List 的长度
+func (rds *RedisDataStructure) LPush(key, element []byte) (uint32, error) {
+	return rds.pushInner(key, element, true)
+}
+
+// RPush 从右侧插入元素，返回 List 的长度
+func (rds *RedisDataStructure) RPush(key, element []byte) (uint32, error) {
+	return rds.pushInner(key, element, false)
+}
+
+// LPop 从左侧弹出元素
+func (rds *RedisDataStructure) LPop(key []byte) ([]byte, error) {
+	return rds.popInner(key, true)
+}
+
+// RPop 从右侧弹出元素
+func (rds *RedisDataStructure) RPop(key []byte) ([]byte, error) {
+	return rds.popInner(key, false)
+}
+
+// LLen 返回 List 的长度
+func (rds *RedisDataStructure) LLen(key []byte) (uint32, error) {
+	return rds.size(key, List)
+}
+
+func (rds *RedisDataStructure) pushInner(key, element []byte, isLeft bool) (uint32, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return 0, err
+	}
+
+	lk := &listInternalKey{key: key, version: meta.version}
+	if isLeft {
+		lk.index = meta.head - 1
+		meta.head--
+	} else {
+		lk.index = meta.tail
+		meta.tail++
+	}
+	meta.size++
+
+	wb := rds.db.NewWriteBtach(gobitcask.DefaultWriteBatchOptions)
+	_ = wb.Put(key, meta.encode())
+	_ = wb.Put(lk.encode(), element)
+	if err = wb.Commit(); err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, List)
+	if err != nil {
+		return nil, err
+	}
+	if meta.size == 0 {
+		return nil, nil
+	}
+
+	lk := &listInternalKey{key: key, version: meta.version}
+	if isLeft {
+		lk.index = meta.head
+		meta.head++
+	} else {
+		lk.index = meta.tail - 1
+		meta.tail--
+	}
+	meta.size--
+
+	encKey := lk.encode()
+	element, err := rds.db.Get(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	wb := rds.db.NewWriteBtach(gobitcask.DefaultWriteBatchOptions)
+	_ = wb.Put(key, meta.encode())
+	_ = wb.Delete(encKey)
+	if err = wb.Commit(); err != nil {
+		return nil, err
+	}
+	return element, nil
+}
+
+// ================================ ZSet 数据结构 ================================
+
+// ZSetMember 有序集合中的元素
+type ZSetMember struct {
+	Member []byte
+	Score  float64
+}
+
+// ZAdd 向有序集合中添加 member，已存在则更新 score，返回 member 是否是新增的
+func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, ZSet)
+	if err != nil {
+		return false, err
+	}
+
+	zk := &zsetInternalKey{key: key, version: meta.version, member: member, score: score}
+	memberKey := zk.encodeWithMember()
+
+	// 查看 member 是否已经存在
+	var exist = true
+	oldScore, err := rds.db.Get(memberKey)
+	if err == gobitcask.ErrKeyNotFound {
+		exist = false
+	} else if err != nil {
+		return false, err
+	}
+	if exist && decodeScore(oldScore) == score {
+		return false, nil
+	}
+
+	wb := rds.db.NewWriteBtach(gobitcask.DefaultWriteBatchOptions)
+	if exist {
+		// 删除旧的 score 索引
+		oldKey := &zsetInternalKey{key: key, version: meta.version, member: member, score: decodeScore(oldScore)}
+		_ = wb.Delete(oldKey.encodeWithScore())
+	} else {
+		meta.size++
+		_ = wb.Put(key, meta.encode())
+	}
+	_ = wb.Put(memberKey, encodeScore(score))
+	_ = wb.Put(zk.encodeWithScore(), nil)
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return !exist, nil
+}
+
+// ZScore 返回 member 的 score
+func (rds *RedisDataStructure) ZScore(key []byte, member []byte) (float64, error) {
+	meta, err := rds.findMetadata(key, ZSet)
+	if err != nil {
+		return -1, err
+	}
+	if meta.size == 0 {
+		return -1, gobitcask.ErrKeyNotFound
+	}
+
+	zk := &zsetInternalKey{key: key, version: meta.version, member: member}
+	value, err := rds.db.Get(zk.encodeWithMember())
+	if err != nil {
+		return -1, err
+	}
+	return decodeScore(value), nil
+}
+
+// ZRem 从有序集合中删除 member，返回 member 是否存在
+func (rds *RedisDataStructure) ZRem(key []byte, member []byte) (bool, error) {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	meta, err := rds.findMetadata(key, ZSet)
+	if err != nil {
+		return false, err
+	}
+	if meta.size == 0 {
+		return false, nil
+	}
+
+	zk := &zsetInternalKey{key: key, version: meta.version, member: member}
+	memberKey := zk.encodeWithMember()
+	value, err := rds.db.Get(memberKey)
+	if err == gobitcask.ErrKeyNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	zk.score = decodeScore(value)
+
+	wb := rds.db.NewWriteBtach(gobitcask.DefaultWriteBatchOptions)
+	meta.size--
+	_ = wb.Put(key, meta.encode())
+	_ = wb.Delete(memberKey)
+	_ = wb.Delete(zk.encodeWithScore())
+	if err = wb.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ZCard 返回有序集合中 member 的数量
+func (rds *RedisDataStructure) ZCard(key []byte) (uint32, error) {
+	return rds.size(key, ZSet)
+}
+
+// ZRangeByScore 按照 score 从小到大返回 [min, max] 区间内的 member
+func (rds *RedisDataStructure) ZRangeByScore(key []byte, min, max float64) ([]*ZSetMember, error) {
+	meta, err := rds.findMetadata(key, ZSet)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []*ZSetMember
+	if meta.size == 0 || min > max {
+		return members, nil
+	}
+
+	prefix := append(subKeyPrefix(key, meta.version, 1), zsetScoreTag)
+	minKey := &zsetInternalKey{key: key, version: meta.version, score: min}
+
+	opts := gobitcask.DefaultIteratorOption
+	opts.Prefix = prefix
+	iter := rds.db.NewIterator(opts)
+	defer iter.Close()
+	for iter.Seek(minKey.encodeWithScore()); iter.Valid(); iter.Next() {
+		subKey := iter.Key()[len(prefix):]
+		zk := &zsetInternalKey{score: decodeSortableFloat64(subKey[:8]), member: subKey[8:]}
+		if zk.score > max {
+			break
+		}
+		members = append(members, &ZSetMember{Member: zk.member, Score: zk.score})
+	}
+	return members, nil
+}
+
+// ================================ 通用操作 ================================
+
+// Del 删除 key，Hash、Set、List、ZSet 中的子 key 也一起删除，占用的空间可以被 merge 回收
+func (rds *RedisDataStructure) Del(key []byte) error {
+	rds.mu.Lock()
+	defer rds.mu.Unlock()
+
+	subKeys, err := rds.findSubKeys(key)
+	if err != nil {
+		return err
+	}
+	if len(subKeys) == 0 {
+		return rds.db.Delete(key)
+	}
+
+	wb := rds.db.NewWriteBtach(gobitcask.DefaultWriteBatchOptions)
+	_ = wb.Delete(key)
+	return rds.commitWithSubKeys(wb, subKeys)
+}
+
+// findSubKeys 找出 key 对应的 Hash、Set、List、ZSet 中所有的子 key，key 不存在或者是字符串时返回空
+// 遍历期间不修改数据
+func (rds *RedisDataStructure) findSubKeys(key []byte) ([][]byte, error) {
+	encValue, err := rds.db.Get(key)
+	if err == gobitcask.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(encValue) == 0 || encValue[0] == String {
+		return nil, nil
+	}
+	meta, err := decodeMetadata(encValue)
+	if err != nil {
+		return nil, err
+	}
+
+	var subKeys [][]byte
+	opts := gobitcask.DefaultIteratorOption
+	opts.Prefix = subKeyPrefix(key, meta.version, 0)
+	iter := rds.db.NewIterator(opts)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		subKeys = append(subKeys, append([]byte(nil), iter.Key()...))
+	}
+	return subKeys, nil
+}
+
+// commitWithSubKeys 提交 wb 中对元数据的修改，并分批删除旧的子 key
+// 元数据和第一批子 key 一起提交，之后即使没有全部删除，剩余的子 key 也不会再被访问到
+func (rds *RedisDataStructure) commitWithSubKeys(wb *gobitcask.WriteBatch, subKeys [][]byte) error {
+	var pending uint = 1
+	for _, subKey := range subKeys {
+		if pending >= gobitcask.DefaultWriteBatchOptions.MaxBatchNum {
+			if err := wb.Commit(); err != nil {
+				return err
+			}
+			pending = 0
+		}
+		_ = wb.Delete(subKey)
+		pending++
+	}
+	return wb.Commit()
+}
+
+// Type 返回 key 对应的数据类型
+func (rds *RedisDataStructure) Type(key []byte) (redisDataType, error) {
+	encValue, err := rds.db.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(encValue) == 0 {
+		return 0, ErrWrongTypeOperation
+	}
+	return encValue[0], nil
+}
+
+// findMetadata 查找元数据，不存在时初始化一个新的元数据
+func (rds *RedisDataStructure) findMetadata(key []byte, dataType redisDataType) (*metadata, error) {
+	metaBuf, err := rds.db.Get(key)
+	if err != nil && err != gobitcask.ErrKeyNotFound {
+		return nil, err
+	}
+
+	if err == gobitcask.ErrKeyNotFound {
+		meta := &metadata{
+			dataType: dataType,
+			version:  time.Now().UnixNano(),
+		}
+		if dataType == List {
+			meta.head = initialListMark
+			meta.tail = initialListMark
+		}
+		return meta, nil
+	}
+
+	if len(metaBuf) > 0 && metaBuf[0] != dataType {
+		return nil, ErrWrongTypeOperation
+	}
+	return decodeMetadata(metaBuf)
+}
+
+// size 返回复杂数据结构中的数据量
+func (rds *RedisDataStructure) size(key []byte, dataType redisDataType) (uint32, error) {
+	meta, err := rds.findMetadata(key, dataType)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+// scanPrefix 遍历指定前缀的所有子 key
+func (rds *RedisDataStructure) scanPrefix(prefix []byte, fn func(subKey, value []byte) bool) error {
+	opts := gobitcask.DefaultIteratorOption
+	opts.Prefix = prefix
+	iter := rds.db.NewIterator(opts)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		value, err := iter.Value()
+		if err != nil {
+			return err
+		}
+		if !fn(iter.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
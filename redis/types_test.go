@@ -0,0 +1,274 @@
+package redis
+
+import (
+	gobitcask "go-bitcask"
+	"go-bitcask/utils"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openRedisDataStructure(t *testing.T, name string) *RedisDataStructure {
+	opts := gobitcask.DefaultOption
+	dir, _ := os.MkdirTemp("", name)
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return rds
+}
+
+func TestRedisDataStructure_Get(t *testing.T) {
+	rds := openRedisDataStructure(t, "bitcask-go-redis-get")
+
+	err := rds.Set(utils.GetTestKey(1), 0, utils.RandomValue(100))
+	assert.Nil(t, err)
+	err = rds.Set(utils.GetTestKey(2), 50*time.Millisecond, utils.RandomValue(100))
+	assert.Nil(t, err)
+
+	val1, err := rds.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = rds.Get(utils.GetTestKey(2))
+	assert.Equal(t, gobitcask.ErrKeyNotFound, err)
+
+	typ, err := rds.Type(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, String, typ)
+
+	err = rds.Del(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = rds.Get(utils.GetTestKey(1))
+	assert.Equal(t, gobitcask.ErrKeyNotFound, err)
+}
+
+func TestRedisDataStructure_Hash(t *testing.T) {
+	rds := openRedisDataStructure(t, "bitcask-go-redis-hash")
+
+	ok1, err := rds.HSet(utils.GetTestKey(1), []byte("field1"), []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok1)
+	ok2, err := rds.HSet(utils.GetTestKey(1), []byte("field1"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok2)
+	ok3, err := rds.HSet(utils.GetTestKey(1), []byte("field2"), []byte("v3"))
+	assert.Nil(t, err)
+	assert.True(t, ok3)
+
+	val, err := rds.HGet(utils.GetTestKey(1), []byte("field1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	size, err := rds.HLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), size)
+
+	all, err := rds.HGetAll(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"field1": []byte("v2"), "field2": []byte("v3")}, all)
+
+	del1, err := rds.HDel(utils.GetTestKey(1), []byte("field1"))
+	assert.Nil(t, err)
+	assert.True(t, del1)
+	del2, err := rds.HDel(utils.GetTestKey(1), []byte("field-unknown"))
+	assert.Nil(t, err)
+	assert.False(t, del2)
+	_, err = rds.HGet(utils.GetTestKey(1), []byte("field1"))
+	assert.Equal(t, gobitcask.ErrKeyNotFound, err)
+
+	// 删除之后重新创建，旧的 field 不可见
+	err = rds.Del(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = rds.HSet(utils.GetTestKey(1), []byte("field3"), []byte("v4"))
+	assert.Nil(t, err)
+	_, err = rds.HGet(utils.GetTestKey(1), []byte("field2"))
+	assert.Equal(t, gobitcask.ErrKeyNotFound, err)
+
+	// 类型不匹配
+	_, err = rds.SAdd(utils.GetTestKey(1), []byte("member"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestRedisDataStructure_Set(t *testing.T) {
+	rds := openRedisDataStructure(t, "bitcask-go-redis-set")
+
+	ok1, err := rds.SAdd(utils.GetTestKey(1), []byte("m1"))
+	assert.Nil(t, err)
+	assert.True(t, ok1)
+	ok2, err := rds.SAdd(utils.GetTestKey(1), []byte("m1"))
+	assert.Nil(t, err)
+	assert.False(t, ok2)
+	_, err = rds.SAdd(utils.GetTestKey(1), []byte("m2"))
+	assert.Nil(t, err)
+
+	isMember, err := rds.SIsMember(utils.GetTestKey(1), []byte("m2"))
+	assert.Nil(t, err)
+	assert.True(t, isMember)
+	isMember, err = rds.SIsMember(utils.GetTestKey(1), []byte("m3"))
+	assert.Nil(t, err)
+	assert.False(t, isMember)
+
+	members, err := rds.SMembers(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("m1"), []byte("m2")}, members)
+
+	rem, err := rds.SRem(utils.GetTestKey(1), []byte("m1"))
+	assert.Nil(t, err)
+	assert.True(t, rem)
+	card, err := rds.SCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), card)
+}
+
+func TestRedisDataStructure_List(t *testing.T) {
+	rds := openRedisDataStructure(t, "bitcask-go-redis-list")
+
+	size, err := rds.LPush(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), size)
+	_, err = rds.LPush(utils.GetTestKey(1), []byte("b"))
+	assert.Nil(t, err)
+	size, err = rds.RPush(utils.GetTestKey(1), []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), size)
+
+	// b a c
+	val1, err := rds.LPop(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val1)
+	val2, err := rds.RPop(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val2)
+	val3, err := rds.RPop(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val3)
+	val4, err := rds.LPop(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, val4)
+
+	length, err := rds.LLen(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), length)
+}
+
+func TestRedisDataStructure_ZSet(t *testing.T) {
+	rds := openRedisDataStructure(t, "bitcask-go-redis-zset")
+
+	ok1, err := rds.ZAdd(utils.GetTestKey(1), 113, []byte("m1"))
+	assert.Nil(t, err)
+	assert.True(t, ok1)
+	ok2, err := rds.ZAdd(utils.GetTestKey(1), 333, []byte("m1"))
+	assert.Nil(t, err)
+	assert.False(t, ok2)
+	_, err = rds.ZAdd(utils.GetTestKey(1), -5.5, []byte("m2"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd(utils.GetTestKey(1), 10, []byte("m3"))
+	assert.Nil(t, err)
+
+	score, err := rds.ZScore(utils.GetTestKey(1), []byte("m1"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(333), score)
+
+	members, err := rds.ZRangeByScore(utils.GetTestKey(1), -10, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, []*ZSetMember{
+		{Member: []byte("m2"), Score: -5.5},
+		{Member: []byte("m3"), Score: 10},
+	}, members)
+
+	rem, err := rds.ZRem(utils.GetTestKey(1), []byte("m3"))
+	assert.Nil(t, err)
+	assert.True(t, rem)
+	card, err := rds.ZCard(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), card)
+
+	members, err = rds.ZRangeByScore(utils.GetTestKey(1), -10, 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(members))
+	assert.Equal(t, []byte("m1"), members[1].Member)
+
+	// -0 与 0 相等，排在所有负数之后
+	_, err = rds.ZAdd(utils.GetTestKey(2), -1, []byte("m1"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd(utils.GetTestKey(2), math.Copysign(0, -1), []byte("m2"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd(utils.GetTestKey(2), 1, []byte("m3"))
+	assert.Nil(t, err)
+	members, err = rds.ZRangeByScore(utils.GetTestKey(2), -10, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []*ZSetMember{
+		{Member: []byte("m1"), Score: -1},
+		{Member: []byte("m2"), Score: 0},
+		{Member: []byte("m3"), Score: 1},
+	}, members)
+	assert.False(t, math.IsNaN(members[1].Score))
+	members, err = rds.ZRangeByScore(utils.GetTestKey(2), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(members))
+	assert.Equal(t, []byte("m2"), members[0].Member)
+}
+
+func TestRedisDataStructure_Del(t *testing.T) {
+	rds := openRedisDataStructure(t, "bitcask-go-redis-del")
+
+	_, err := rds.HSet(utils.GetTestKey(1), []byte("field1"), []byte("v1"))
+	assert.Nil(t, err)
+	_, err = rds.SAdd(utils.GetTestKey(2), []byte("m1"))
+	assert.Nil(t, err)
+	_, err = rds.RPush(utils.GetTestKey(3), []byte("e1"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd(utils.GetTestKey(4), 1, []byte("m1"))
+	assert.Nil(t, err)
+	assert.Nil(t, rds.Set(utils.GetTestKey(5), 0, []byte("v")))
+
+	// 读取子 key 时不会因为空的 value 而 panic
+	sk := &setInternalKey{key: utils.GetTestKey(2), version: 0, member: []byte("m1")}
+	meta, err := rds.findMetadata(utils.GetTestKey(2), Set)
+	assert.Nil(t, err)
+	sk.version = meta.version
+	_, err = rds.Get(sk.encode())
+	assert.Equal(t, ErrInvalidValue, err)
+
+	// 删除之后所有的子 key 也被删除
+	for i := 1; i <= 5; i++ {
+		assert.Nil(t, rds.Del(utils.GetTestKey(i)))
+	}
+	assert.Equal(t, 0, len(rds.db.ListKeys()))
+
+	card, err := rds.SCard(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), card)
+}
+
+func TestRedisDataStructure_SetOverwrite(t *testing.T) {
+	rds := openRedisDataStructure(t, "bitcask-go-redis-set-overwrite")
+
+	_, err := rds.HSet(utils.GetTestKey(1), []byte("field1"), []byte("v1"))
+	assert.Nil(t, err)
+	_, err = rds.SAdd(utils.GetTestKey(2), []byte("m1"))
+	assert.Nil(t, err)
+	_, err = rds.RPush(utils.GetTestKey(3), []byte("e1"))
+	assert.Nil(t, err)
+	_, err = rds.ZAdd(utils.GetTestKey(4), 1, []byte("m1"))
+	assert.Nil(t, err)
+
+	// 覆盖为字符串之后，旧的子 key 全部被删除
+	for i := 1; i <= 4; i++ {
+		assert.Nil(t, rds.Set(utils.GetTestKey(i), 0, []byte("v")))
+		value, err := rds.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), value)
+	}
+	assert.Equal(t, 4, len(rds.db.ListKeys()))
+
+	_, err = rds.HGet(utils.GetTestKey(1), []byte("field1"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
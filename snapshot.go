@@ -0,0 +1,110 @@
+package gobitcask
+
+import (
+	"go-bitcask/index"
+	"time"
+)
+
+// Snapshot 数据库在某一时刻的只读视图
+// 快照存活期间，Get、NewIterator、Fold 看到的都是创建快照时的数据，
+// 快照引用的数据文件不会被 merge 回收，使用完毕后需要调用 Release 释放，
+// Release 不能和快照上进行中的读操作并发调用
+type Snapshot struct {
+	db       *DB
+	index    index.Indexer // 创建快照时刻的内存索引视图
+	seqNo    uint64        // 创建快照时的事务序列号
+	readTime int64         // 创建快照的时刻，用于判断数据是否过期
+	released bool          // 是否已经释放，由 db.mu 保护
+}
+
+// NewSnapshot 创建一个固定在当前事务序列号的快照
+func (db *DB) NewSnapshot() *Snapshot {
+	// 持有写锁，保证不会看到提交了一半的 WriteBatch
+	db.lock()
+	defer db.unlock()
+
+	snap := &Snapshot{
+		db:       db,
+		index:    db.index.Snapshot(),
+		seqNo:    db.seqNo,
+		readTime: time.Now().UnixNano(),
+	}
+	db.snapshots[snap] = struct{}{}
+	return snap
+}
+
+// SeqNo 返回快照对应的事务序列号
+func (s *Snapshot) SeqNo() uint64 {
+	return s.seqNo
+}
+
+// Get 根据 Key 读取快照中的数据
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(s.readTime) {
+		return nil, ErrKeyNotFound
+	}
+	return s.db.getValueByPosition(logRecordPos)
+}
+
+// NewIterator 初始化快照上的迭代器
+// 迭代器需要在快照释放之前关闭
+func (s *Snapshot) NewIterator(opts IteratorOptions) *Iterator {
+	return &Iterator{
+		indexIter: s.index.Iterator(opts.Reverse),
+		db:        s.db,
+		options:   opts,
+		readTime:  s.readTime,
+	}
+}
+
+// Fold 获取快照中所有的数据, 并执行用户指定操作
+// 与 DB.Fold 不同，遍历期间不会阻塞写操作
+func (s *Snapshot) Fold(fn func(key []byte, value []byte) bool) error {
+	s.db.mu.RLock()
+	released := s.released
+	s.db.mu.RUnlock()
+	if released {
+		return ErrSnapshotReleased
+	}
+
+	iterator := s.NewIterator(DefaultIteratorOption)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		value, err := iterator.Value()
+		if err != nil {
+			return err
+		}
+		if !fn(iterator.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Release 释放快照，释放后 merge 才能回收其引用的数据文件
+func (s *Snapshot) Release() error {
+	s.db.lock()
+	defer s.db.unlock()
+	return s.release()
+}
+
+// release 释放快照持有的资源
+// 在使用此方法前必须持有数据库的互斥锁
+func (s *Snapshot) release() error {
+	if s.released {
+		return nil
+	}
+	s.released = true
+	delete(s.db.snapshots, s)
+	return s.index.Close()
+}
@@ -0,0 +1,127 @@
+package gobitcask
+
+import (
+	"go-bitcask/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_NewSnapshot(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	snap := db.NewSnapshot()
+	assert.Equal(t, db.seqNo, snap.SeqNo())
+
+	// 创建快照之后的修改对快照不可见
+	err = db.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(100), utils.RandomValue(10))
+	assert.Nil(t, err)
+	wb := db.NewWriteBtach(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(3), []byte("batch value"))
+	assert.Nil(t, wb.Commit())
+
+	value1, err := snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), value1)
+	value2, err := snap.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(2), value2)
+	_, err = snap.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 数据库能读到最新的数据
+	value3, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), value3)
+
+	// 快照上的迭代器和 Fold
+	it := snap.NewIterator(DefaultIteratorOption)
+	var count int
+	for it.Rewind(); it.Valid(); it.Next() {
+		value, err := it.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, it.Key(), value)
+		count++
+	}
+	it.Close()
+	assert.Equal(t, 10, count)
+
+	count = 0
+	err = snap.Fold(func(key, value []byte) bool {
+		assert.Equal(t, key, value)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, count)
+
+	// 快照存活时不能 merge
+	err = db.Merge()
+	assert.Equal(t, ErrSnapshotIsAlive, err)
+
+	// 释放之后不能再读取
+	err = snap.Release()
+	assert.Nil(t, err)
+	_, err = snap.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrSnapshotReleased, err)
+	assert.Equal(t, 0, len(db.snapshots))
+}
+
+func TestDB_NewSnapshot_BPlusTree(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("old value"))
+	assert.Nil(t, err)
+
+	snap := db.NewSnapshot()
+	err = db.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+	// 持有快照的 goroutine 继续大量写入，B+ 树文件增长需要重新映射，不能被快照阻塞
+	for i := 100; i < 5100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+
+	value1, err := snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old value"), value1)
+	_, err = snap.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	var keys [][]byte
+	err = snap.Fold(func(key, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{utils.GetTestKey(1)}, keys)
+
+	// 未释放的快照在关闭数据库时会被释放
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db.snapshots))
+}
@@ -0,0 +1,481 @@
+package gobitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"go-bitcask/data"
+	"io"
+	"sync"
+	"time"
+)
+
+// 流式写入时每段数据的最大长度，不超过这个长度的 value 直接整体写入
+const streamChunkSize = 1024 * 1024
+
+// streamManifest 流式写入的 value 的清单
+//
+//	+-------------+-------------+-------------+------------------------------------+
+//	|  value 长度  |  每段的长度  |   段的数量   |  每段的位置（fid, offset, size）...  |
+//	+-------------+-------------+-------------+------------------------------------+
+//	     变长          变长          变长                       变长
+type streamManifest struct {
+	size      int64                // value 的总长度
+	chunkSize int64                // 除最后一段之外每段数据的长度
+	chunks    []*data.LogRecordPos // 每段数据在数据文件中的位置
+}
+
+func encodeStreamManifest(manifest *streamManifest) []byte {
+	buf := make([]byte, binary.MaxVarintLen64*3+len(manifest.chunks)*(binary.MaxVarintLen32*2+binary.MaxVarintLen64))
+	var index = 0
+	index += binary.PutVarint(buf[index:], manifest.size)
+	index += binary.PutVarint(buf[index:], manifest.chunkSize)
+	index += binary.PutVarint(buf[index:], int64(len(manifest.chunks)))
+	for _, pos := range manifest.chunks {
+		index += binary.PutVarint(buf[index:], int64(pos.Fid))
+		index += binary.PutVarint(buf[index:], pos.Offset)
+		index += binary.PutVarint(buf[index:], int64(pos.Size))
+	}
+	return buf[:index]
+}
+
+func decodeStreamManifest(buf []byte) (*streamManifest, error) {
+	var index = 0
+	readVarint := func() (int64, error) {
+		v, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return 0, ErrInvalidStreamManifest
+		}
+		index += n
+		return v, nil
+	}
+
+	manifest := &streamManifest{}
+	var err error
+	if manifest.size, err = readVarint(); err != nil {
+		return nil, err
+	}
+	if manifest.chunkSize, err = readVarint(); err != nil {
+		return nil, err
+	}
+	count, err := readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if manifest.chunkSize <= 0 || count < 0 || count > manifest.size/manifest.chunkSize+1 {
+		return nil, ErrInvalidStreamManifest
+	}
+	manifest.chunks = make([]*data.LogRecordPos, count)
+	for i := range manifest.chunks {
+		var fid, offset, size int64
+		if fid, err = readVarint(); err != nil {
+			return nil, err
+		}
+		if offset, err = readVarint(); err != nil {
+			return nil, err
+		}
+		if size, err = readVarint(); err != nil {
+			return nil, err
+		}
+		manifest.chunks[i] = &data.LogRecordPos{Fid: uint32(fid), Offset: offset, Size: uint32(size)}
+	}
+	return manifest, nil
+}
+
+// PutReader 从 r 中读取 size 字节的数据作为 key 的 value 写入，不需要将 value 整体读入内存
+// 较大的 value 按段写入数据文件，最后写入记录每段位置的清单，清单写入之前 value 对读取不可见；
+// 写入期间 merge 会返回 ErrStreamIsWriting，merge 进行期间的写入会等待 merge 完成
+func (db *DB) PutReader(key []byte, r io.Reader, size int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if size < 0 {
+		return ErrInvalidValueSize
+	}
+	if size <= streamChunkSize {
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return err
+		}
+		return db.Put(key, value)
+	}
+
+	// 清单引用的数据文件不能在写入期间被 merge 替换
+	db.streamMu.RLock()
+	defer db.streamMu.RUnlock()
+
+	manifest := &streamManifest{size: size, chunkSize: streamChunkSize}
+	logRecordKey := logRecordKeyWithSeq(key, nonTransactionSeqNo)
+	buf := make([]byte, streamChunkSize)
+	for remaining := size; remaining > 0; {
+		n := int64(len(buf))
+		if remaining < n {
+			n = remaining
+		}
+		// 从 r 中读取数据时不持有锁，不影响其他的读写
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			db.abandonStreamChunks(manifest.chunks)
+			return err
+		}
+		db.lock()
+		pos, err := db.appendLogRecord(&data.LogRecord{Key: logRecordKey, Value: buf[:n], Type: data.LogRecordChunk})
+		db.unlock()
+		if err != nil {
+			db.abandonStreamChunks(manifest.chunks)
+			return err
+		}
+		manifest.chunks = append(manifest.chunks, pos)
+		remaining -= n
+	}
+
+	db.lock()
+	defer db.unlock()
+	pos, err := db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKey,
+		Value: encodeStreamManifest(manifest),
+		Type:  data.LogRecordManifest,
+	})
+	if err != nil {
+		for _, chunk := range manifest.chunks {
+			db.addReclaimable(chunk)
+		}
+		return err
+	}
+	db.markModified(key)
+	return db.updateIndex(func(idx indexWriter) {
+		db.addStaleReclaimable(idx.Put(key, pos))
+	})
+}
+
+// abandonStreamChunks 写入失败时已经写入的每段数据不会被清单引用，计入可回收空间
+func (db *DB) abandonStreamChunks(chunks []*data.LogRecordPos) {
+	db.lock()
+	defer db.unlock()
+	for _, chunk := range chunks {
+		db.addReclaimable(chunk)
+	}
+}
+
+// addStaleReclaimable 记录被覆盖或删除的 pos 对应的数据已经失效，
+// 流式写入的 value 的每段数据不在索引中，清单失效时一并计入可回收空间
+// 在使用此方法前必须持有互斥锁
+func (db *DB) addStaleReclaimable(pos *data.LogRecordPos) {
+	if pos == nil {
+		return
+	}
+	db.addReclaimable(pos)
+	for _, chunk := range db.staleStreamChunks(pos) {
+		db.addReclaimable(chunk)
+	}
+}
+
+// staleStreamChunks 返回 pos 位置的清单引用的每段数据的位置，不是清单时返回空
+// 只统计可回收空间，读取失败时忽略
+// 在使用此方法前必须持有锁
+func (db *DB) staleStreamChunks(pos *data.LogRecordPos) []*data.LogRecordPos {
+	dataFile := db.getDataFile(pos.Fid)
+	if dataFile == nil {
+		return nil
+	}
+	// 大多数记录不是清单，只读取 header 判断类型
+	if value, err := dataFile.ReadLogRecordValue(pos.Offset); err != nil || value.Type != data.LogRecordManifest {
+		return nil
+	}
+	logRecord, err := db.readLogRecord(pos)
+	if err != nil {
+		return nil
+	}
+	if err := db.decompressLogRecord(logRecord); err != nil {
+		return nil
+	}
+	manifest, err := decodeStreamManifest(logRecord.Value)
+	if err != nil {
+		return nil
+	}
+	return manifest.chunks
+}
+
+// readStreamValue 读取清单中的每段数据，拼接为完整的 value
+// 在使用此方法前必须持有锁
+func (db *DB) readStreamValue(manifest *streamManifest) ([]byte, error) {
+	value := make([]byte, 0, manifest.size)
+	for _, pos := range manifest.chunks {
+		chunk, err := db.readStreamChunk(pos)
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, chunk...)
+	}
+	return value, nil
+}
+
+// readStreamChunk 读取 pos 位置的一段数据
+// 在使用此方法前必须持有锁
+func (db *DB) readStreamChunk(pos *data.LogRecordPos) ([]byte, error) {
+	logRecord, err := db.readLogRecord(pos)
+	if err != nil {
+		return nil, err
+	}
+	if logRecord.Type != data.LogRecordChunk {
+		return nil, ErrInvalidStreamManifest
+	}
+	if err := db.decompressLogRecord(logRecord); err != nil {
+		return nil, err
+	}
+	return logRecord.Value, nil
+}
+
+// ValueReader 读取 value 的 Reader，实现了 io.ReadSeekCloser 和 io.ReaderAt
+// 流式写入的 value 每次只从数据文件中读取其中一段，使用完之后必须调用 Close
+type ValueReader struct {
+	db        *DB
+	mu        sync.Mutex
+	manifest  *streamManifest // 流式写入的 value 的清单，为空时 value 已经整体读取或者直接从文件中读取
+	file      *data.DataFile  // 直接读取 value 的数据文件
+	recordOff int64           // value 所在的记录在文件中的位置
+	valueOff  int64           // value 在文件中的位置
+	size      int64           // value 的总长度
+	chunk     []byte          // 最近读取的一段数据
+	index     int             // chunk 在清单中的下标
+	offset    int64           // Read 读取的位置
+	tracked   bool            // 是否计入了数据库的迭代器数量
+	closed    bool
+}
+
+// GetReader 返回读取 key 对应的 value 的 Reader
+// 较大的 value 在读取时才从数据文件中读取需要的部分，没有压缩和加密的 value 直接从记录所在的位置读取；
+// Reader 关闭之前，merge 和 value log GC 不会删除它读取的数据文件
+func (db *DB) GetReader(key []byte) (*ValueReader, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	db.lock()
+	reader, err := db.getReader(key)
+	db.unlock()
+	if err != nil || reader.file == nil {
+		return reader, err
+	}
+
+	// 直接读取记录中的 value 之前分段校验整条记录，校验时不持有锁
+	if err := reader.file.VerifyLogRecord(reader.recordOff); err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// getReader 根据 value 的存储方式构造 Reader
+// 在使用此方法前必须持有互斥锁
+func (db *DB) getReader(key []byte) (*ValueReader, error) {
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	dataFile := db.getDataFile(pos.Fid)
+	if dataFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	// 只读取 header，较大的 value 不读入内存
+	value, err := dataFile.ReadLogRecordValue(pos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case value.Type == data.LogRecordManifest:
+		logRecord, err := db.readLogRecord(pos)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.decompressLogRecord(logRecord); err != nil {
+			return nil, err
+		}
+		manifest, err := decodeStreamManifest(logRecord.Value)
+		if err != nil {
+			return nil, err
+		}
+		// 和迭代器一样，读取完成之前不能替换数据文件
+		db.iterators++
+		return &ValueReader{db: db, manifest: manifest, size: manifest.size, index: -1, tracked: true}, nil
+	case value.ValuePointer && !value.Encrypted:
+		// value 存储在 value log 文件中，记录中只有 value 的位置
+		logRecord, err := db.readLogRecord(pos)
+		if err != nil {
+			return nil, err
+		}
+		vpos := data.DecodeLogRecordPos(logRecord.Value)
+		vlogFile := db.oldVlogs[vpos.Fid]
+		if db.activeVlog != nil && db.activeVlog.FileId == vpos.Fid {
+			vlogFile = db.activeVlog
+		}
+		if vlogFile == nil {
+			return nil, ErrDataFileNotFound
+		}
+		vlogValue, err := vlogFile.ReadLogRecordValue(vpos.Offset)
+		if err != nil {
+			return nil, err
+		}
+		if reader := db.newInlineReader(vlogFile, vpos.Offset, vlogValue); reader != nil {
+			return reader, nil
+		}
+	default:
+		if reader := db.newInlineReader(dataFile, pos.Offset, value); reader != nil {
+			return reader, nil
+		}
+	}
+
+	// 较小的 value 或者需要解压、解密的 value 整体读取
+	buf, err := db.getValueByPosition(pos)
+	if err != nil {
+		return nil, err
+	}
+	return &ValueReader{db: db, size: int64(len(buf)), chunk: buf}, nil
+}
+
+// newInlineReader 构造直接从数据文件中读取 value 的 Reader，value 较小或者不能直接读取时返回空
+// 在使用此方法前必须持有互斥锁
+func (db *DB) newInlineReader(file *data.DataFile, recordOff int64, value *data.LogRecordValue) *ValueReader {
+	if value.Size <= streamChunkSize || value.Compression != NoCompression || value.Encrypted ||
+		value.ValuePointer || value.Type != data.LogRecordNormal {
+		return nil
+	}
+	db.iterators++
+	return &ValueReader{
+		db:        db,
+		size:      value.Size,
+		file:      file,
+		recordOff: recordOff,
+		valueOff:  value.Offset,
+		tracked:   true,
+	}
+}
+
+// Size 返回 value 的总长度
+func (r *ValueReader) Size() int64 {
+	return r.size
+}
+
+// ReadAt 从 value 的 off 位置开始读取数据
+func (r *ValueReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.readAt(p, off)
+}
+
+func (r *ValueReader) readAt(p []byte, off int64) (int, error) {
+	if r.closed {
+		return 0, ErrReaderClosed
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if r.file != nil {
+		return r.readFileAt(p, off)
+	}
+	var n int
+	for n < len(p) && off < r.size {
+		chunk, chunkOff, err := r.chunkAt(off)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], chunk[off-chunkOff:])
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readFileAt 直接从数据文件中读取 value 的 off 位置开始的数据
+func (r *ValueReader) readFileAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	var err error
+	if remaining := r.size - off; int64(len(p)) > remaining {
+		p, err = p[:remaining], io.EOF
+	}
+	n, readErr := r.file.IoManager.Read(p, r.valueOff+off)
+	if readErr != nil {
+		return n, readErr
+	}
+	return n, err
+}
+
+// chunkAt 返回包含 off 位置的一段数据以及这段数据的起始位置
+func (r *ValueReader) chunkAt(off int64) ([]byte, int64, error) {
+	if r.manifest == nil {
+		return r.chunk, 0, nil
+	}
+	index := int(off / r.manifest.chunkSize)
+	if index != r.index {
+		if index >= len(r.manifest.chunks) {
+			return nil, 0, ErrInvalidStreamManifest
+		}
+		r.db.mu.RLock()
+		chunk, err := r.db.readStreamChunk(r.manifest.chunks[index])
+		r.db.mu.RUnlock()
+		if err != nil {
+			return nil, 0, err
+		}
+		r.chunk, r.index = chunk, index
+	}
+	chunkOff := int64(index) * r.manifest.chunkSize
+	if off-chunkOff >= int64(len(r.chunk)) {
+		return nil, 0, ErrInvalidStreamManifest
+	}
+	return r.chunk, chunkOff, nil
+}
+
+// Read 从当前位置读取数据
+func (r *ValueReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.offset >= r.size && !r.closed {
+		return 0, io.EOF
+	}
+	n, err := r.readAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek 设置下一次 Read 的位置
+func (r *ValueReader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close 关闭 Reader
+func (r *ValueReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.chunk = nil
+	r.file = nil
+	if r.tracked {
+		r.db.lock()
+		r.db.releaseIterator()
+		r.db.unlock()
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package gobitcask
+
+import (
+	"bytes"
+	"go-bitcask/utils"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_PutReader(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream")
+	opts.DirPath = dir
+	opts.DataFileSize = 2 * 1024 * 1024
+	opts.Compression = FlateCompression
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := bytes.Repeat(utils.RandomValue(1000), 3500)
+	assert.Nil(t, db.PutReader([]byte("blob"), bytes.NewReader(value), int64(len(value))))
+	assert.Nil(t, db.PutReader([]byte("small"), bytes.NewReader([]byte("value")), 5))
+
+	// 数据不足时写入失败，value 不可见
+	err = db.PutReader([]byte("short"), bytes.NewReader(value[:streamChunkSize+1]), int64(len(value)))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = db.Get([]byte("short"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, ErrInvalidValueSize, db.PutReader([]byte("blob"), bytes.NewReader(nil), -1))
+
+	check := func(db *DB) {
+		reader, err := db.GetReader([]byte("blob"))
+		assert.Nil(t, err)
+		defer reader.Close()
+		assert.Equal(t, int64(len(value)), reader.Size())
+		buf, err := io.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.Equal(t, value, buf)
+
+		// 跨越两段数据读取
+		part := make([]byte, 100)
+		n, err := reader.ReadAt(part, streamChunkSize-50)
+		assert.Nil(t, err)
+		assert.Equal(t, 100, n)
+		assert.Equal(t, value[streamChunkSize-50:streamChunkSize+50], part)
+		n, err = reader.ReadAt(part, int64(len(value))-10)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, 10, n)
+
+		_, err = reader.Seek(-20, io.SeekEnd)
+		assert.Nil(t, err)
+		buf, err = io.ReadAll(reader)
+		assert.Nil(t, err)
+		assert.Equal(t, value[len(value)-20:], buf)
+
+		val, err := db.Get([]byte("blob"))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+
+		smallReader, err := db.GetReader([]byte("small"))
+		assert.Nil(t, err)
+		buf, err = io.ReadAll(smallReader)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), buf)
+		assert.Nil(t, smallReader.Close())
+		_, err = smallReader.Read(buf)
+		assert.Equal(t, ErrReaderClosed, err)
+
+		assert.Equal(t, 2, len(db.ListKeys()))
+	}
+	check(db)
+
+	// 重新打开以及 merge 之后都可以读取
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	assert.Nil(t, db.Merge())
+	check(db)
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
+
+func TestDB_PutReader_Merge(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream-merge")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+
+	// 流式写入期间不能 merge
+	value := utils.RandomValue(3 * streamChunkSize)
+	pr, pw := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- db.PutReader([]byte("blob"), pr, int64(len(value)))
+	}()
+	_, err = pw.Write(value[:streamChunkSize+10])
+	assert.Nil(t, err)
+	assert.Equal(t, ErrStreamIsWriting, db.Merge())
+	_, err = pw.Write(value[streamChunkSize+10:])
+	assert.Nil(t, err)
+	assert.Nil(t, <-done)
+	assert.Nil(t, db.Merge())
+
+	// Reader 关闭之后 merge 才替换旧的数据文件
+	assert.Nil(t, db.Put([]byte("key"), []byte("value2")))
+	reader, err := db.GetReader([]byte("blob"))
+	assert.Nil(t, err)
+	go func() {
+		done <- db.Merge()
+	}()
+	buf, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, value, buf)
+	assert.Nil(t, reader.Close())
+	assert.Nil(t, <-done)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_PutReader_Reclaimable(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := utils.RandomValue(3*streamChunkSize + 100)
+	assert.Nil(t, db.PutReader([]byte("blob"), bytes.NewReader(value), int64(len(value))))
+	assert.Equal(t, int64(0), db.reclaimableSize())
+
+	// 覆盖写之后清单和每段数据都可以回收
+	assert.Nil(t, db.Put([]byte("blob"), []byte("small")))
+	assert.True(t, db.reclaimableSize() > int64(len(value)))
+
+	assert.Nil(t, db.PutReader([]byte("blob2"), bytes.NewReader(value), int64(len(value))))
+	before := db.reclaimableSize()
+	assert.Nil(t, db.Delete([]byte("blob2")))
+	assert.True(t, db.reclaimableSize()-before > int64(len(value)))
+
+	// 重新启动之后重放数据文件得到相同的结果
+	size := db.reclaimableSize()
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, size, db.reclaimableSize())
+}
+
+func TestDB_GetReader_Inline(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := utils.RandomValue(2 * streamChunkSize)
+	assert.Nil(t, db.Put([]byte("large"), value))
+
+	reader, err := db.GetReader([]byte("large"))
+	assert.Nil(t, err)
+	// 没有压缩和加密的 value 直接从数据文件中读取
+	assert.NotNil(t, reader.file)
+	assert.Nil(t, reader.chunk)
+	assert.Equal(t, int64(len(value)), reader.Size())
+	part := make([]byte, 100)
+	n, err := reader.ReadAt(part, streamChunkSize-50)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, value[streamChunkSize-50:streamChunkSize+50], part)
+	n, err = reader.ReadAt(part, int64(len(value))-10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 10, n)
+	buf, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, value, buf)
+
+	// Reader 关闭之前不能替换数据文件
+	assert.Equal(t, 1, db.iterators)
+	assert.Nil(t, reader.Close())
+	assert.Equal(t, 0, db.iterators)
+	_, err = reader.Read(part)
+	assert.Equal(t, ErrReaderClosed, err)
+
+	// value 存储在 value log 文件中时同样直接读取
+	opts2 := DefaultOption
+	dir2, _ := os.MkdirTemp("", "bitcask-go-stream")
+	opts2.DirPath = dir2
+	opts2.ValueLogThreshold = 1024
+	db2, err := Open(opts2)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.Put([]byte("large"), value))
+	reader, err = db2.GetReader([]byte("large"))
+	assert.Nil(t, err)
+	assert.NotNil(t, reader.file)
+	buf, err = io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, value, buf)
+	assert.Nil(t, reader.Close())
+}
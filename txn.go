@@ -0,0 +1,191 @@
+package gobitcask
+
+import (
+	"go-bitcask/data"
+	"sync"
+	"time"
+)
+
+// Txn 乐观读写事务
+// 事务中的写操作会暂存在内存中，Commit 时原子写入；
+// 如果事务读取过的 key 在事务开始之后被其他写操作修改，Commit 会返回 ErrTxnConflict
+type Txn struct {
+	mu            *sync.Mutex
+	db            *DB
+	pendingWrites map[string]*data.LogRecord // 暂存事务写入的数据
+	readSet       map[string]struct{}        // 事务读取过的 key
+	startVersion  uint64                     // 事务开始时的写入版本号，之后修改的 key 版本号更大
+	closed        bool
+}
+
+// Begin 开启一个读写事务
+func (db *DB) Begin() *Txn {
+	db.lock()
+	defer db.unlock()
+
+	txn := &Txn{
+		mu:            new(sync.Mutex),
+		db:            db,
+		pendingWrites: make(map[string]*data.LogRecord),
+		readSet:       make(map[string]struct{}),
+		startVersion:  db.version,
+	}
+	db.txns[txn] = struct{}{}
+	return txn
+}
+
+// Get 读取数据，能够读到事务自身暂存的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return nil, ErrTxnClosed
+	}
+
+	// 优先读取事务自身的写入
+	if record := txn.pendingWrites[string(key)]; record != nil {
+		if record.Type == data.LogRecordDelete || record.Expire > 0 && record.Expire <= time.Now().UnixNano() {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	txn.readSet[string(key)] = struct{}{}
+	return txn.db.Get(key)
+}
+
+// Put 在事务中写入数据
+func (txn *Txn) Put(key, value []byte) error {
+	return txn.put(key, value, 0)
+}
+
+// PutWithTTL 在事务中写入数据，数据在 ttl 之后自动过期
+func (txn *Txn) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return txn.put(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+func (txn *Txn) put(key, value []byte, expire int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: value, Expire: expire}
+	return nil
+}
+
+// Delete 在事务中删除数据
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	// 数据不存在则只需要丢弃暂存的写入
+	if pos := txn.db.index.Get(key); pos == nil {
+		delete(txn.pendingWrites, string(key))
+		return nil
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Type: data.LogRecordDelete}
+	return nil
+}
+
+// Commit 校验读取过的 key 是否冲突，并原子地提交事务中的写入
+// 无论提交成功与否，事务都会结束
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	txn.db.lock()
+	defer txn.db.unlock()
+
+	// 读取过的 key 在事务开始之后被其他写操作修改过，说明发生了冲突
+	// 结束事务时会清理不再需要的版本号，需要在结束之前检查
+	conflict := false
+	for key := range txn.readSet {
+		if txn.db.keyVersions[key] > txn.startVersion {
+			conflict = true
+			break
+		}
+	}
+	txn.finish()
+	if conflict {
+		return ErrTxnConflict
+	}
+
+	if len(txn.pendingWrites) == 0 {
+		return nil
+	}
+	return txn.db.commitRecords(txn.pendingWrites, false)
+}
+
+// Rollback 放弃事务中暂存的写入
+func (txn *Txn) Rollback() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.closed {
+		return
+	}
+
+	txn.db.lock()
+	defer txn.db.unlock()
+	txn.finish()
+}
+
+// finish 结束事务，不再参与冲突检测
+// 在使用此方法前必须持有数据库的互斥锁
+func (txn *Txn) finish() {
+	txn.closed = true
+	delete(txn.db.txns, txn)
+	txn.db.pruneKeyVersions()
+}
+
+// markModified 记录用户写入的 key 的版本号，用于进行中的事务做冲突检测
+// 只在更新索引的写入中调用，merge、value log GC 等内部的重写不会引起冲突
+// 在使用此方法前必须持有互斥锁
+func (db *DB) markModified(key []byte) {
+	if len(db.txns) == 0 {
+		return
+	}
+	db.version++
+	db.keyVersions[string(key)] = db.version
+}
+
+// pruneKeyVersions 删除所有进行中的事务都不再需要的版本号
+// 版本号不大于最早的事务开始时的版本号的 key 不会引起冲突
+// 在使用此方法前必须持有互斥锁
+func (db *DB) pruneKeyVersions() {
+	if len(db.txns) == 0 {
+		db.keyVersions = make(map[string]uint64)
+		return
+	}
+	minVersion := db.version
+	for txn := range db.txns {
+		if txn.startVersion < minVersion {
+			minVersion = txn.startVersion
+		}
+	}
+	for key, version := range db.keyVersions {
+		if version <= minVersion {
+			delete(db.keyVersions, key)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package gobitcask
+
+import (
+	"go-bitcask/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Txn(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("100"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("200"))
+	assert.Nil(t, err)
+
+	txn := db.Begin()
+	value1, err := txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("100"), value1)
+
+	// 读到事务自身的写入
+	err = txn.Put(utils.GetTestKey(1), []byte("101"))
+	assert.Nil(t, err)
+	err = txn.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	value2, err := txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("101"), value2)
+	_, err = txn.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 提交之前对其他读者不可见
+	value3, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("100"), value3)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	value4, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("101"), value4)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 事务结束之后不能再使用
+	err = txn.Put(utils.GetTestKey(3), []byte("300"))
+	assert.Equal(t, ErrTxnClosed, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnClosed, err)
+	assert.Equal(t, 0, len(db.txns))
+
+	// 重启之后事务写入的数据依然有效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db2)
+	value5, err := db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("101"), value5)
+	_, err = db2.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_Txn_Conflict(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-conflict")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("100"))
+	assert.Nil(t, err)
+
+	// 读取过的 key 被其他写入修改
+	txn1 := db.Begin()
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn1.Put(utils.GetTestKey(2), []byte("200"))
+	assert.Nil(t, err)
+
+	err = db.Put(utils.GetTestKey(1), []byte("101"))
+	assert.Nil(t, err)
+	err = txn1.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 两个事务读写同一个 key，后提交的失败
+	txn2 := db.Begin()
+	txn3 := db.Begin()
+	_, err = txn2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn3.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_ = txn2.Put(utils.GetTestKey(1), []byte("102"))
+	_ = txn3.Put(utils.GetTestKey(1), []byte("103"))
+	assert.Nil(t, txn2.Commit())
+	assert.Equal(t, ErrTxnConflict, txn3.Commit())
+
+	value, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("102"), value)
+
+	// 只写不读的 key 被修改不算冲突
+	txn4 := db.Begin()
+	_ = txn4.Put(utils.GetTestKey(1), []byte("104"))
+	wb := db.NewWriteBtach(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(1), []byte("105"))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, txn4.Commit())
+
+	// 回滚之后数据不会写入
+	txn5 := db.Begin()
+	_ = txn5.Put(utils.GetTestKey(6), []byte("600"))
+	txn5.Rollback()
+	_, err = db.Get(utils.GetTestKey(6))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 0, len(db.txns))
+}
+
+func TestDB_Txn_InternalRewrite(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-rewrite")
+	opts.DirPath = dir
+	opts.ValueLogThreshold = 1024
+	opts.ValueLogFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(4096)))
+	}
+	for i := 0; i < 200; i++ {
+		if i%20 != 0 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		}
+	}
+
+	// value log GC 重写有效的 value，不是用户的写入，不会引起冲突
+	txn := db.Begin()
+	_, err = txn.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put(utils.GetTestKey(1000), []byte("value")))
+	pos := db.index.Get(utils.GetTestKey(100))
+	assert.Nil(t, db.ValueLogGC())
+	assert.NotEqual(t, pos.Offset, db.index.Get(utils.GetTestKey(100)).Offset)
+	assert.Equal(t, 0, len(db.keyVersions))
+	assert.Nil(t, txn.Commit())
+
+	// 所有事务结束之后不再保留版本号
+	txn1 := db.Begin()
+	txn2 := db.Begin()
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("1")))
+	assert.Nil(t, db.Put(utils.GetTestKey(2), []byte("2")))
+	assert.Equal(t, 2, len(db.keyVersions))
+	txn1.Rollback()
+	assert.Equal(t, 2, len(db.keyVersions))
+	txn3 := db.Begin()
+	assert.Nil(t, db.Put(utils.GetTestKey(3), []byte("3")))
+	txn2.Rollback()
+	// 只有 txn3 开始之后的修改仍然需要保留
+	assert.Equal(t, 1, len(db.keyVersions))
+	txn3.Rollback()
+	assert.Equal(t, 0, len(db.keyVersions))
+}
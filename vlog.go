@@ -0,0 +1,359 @@
+package gobitcask
+
+import (
+	"errors"
+	"go-bitcask/data"
+	"go-bitcask/fio"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loadValueLogFiles 打开数据目录中所有的 value log 文件
+// 启动之后不再向已有的 value log 文件追加写入，避免文件末尾没有完整写入的数据出现在文件中间
+func (db *DB) loadValueLogFiles() error {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.ValueLogFileSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.ValueLogFileSuffix))
+		if err != nil {
+			return ErrDataDirectoryCorrupted
+		}
+		vlogFile, err := data.OpenValueLogFile(db.options.DirPath, uint32(fileId), fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		vlogFile.Cipher = db.cipher
+		db.oldVlogs[uint32(fileId)] = vlogFile
+	}
+	return nil
+}
+
+// closeValueLogFiles 关闭所有的 value log 文件
+func (db *DB) closeValueLogFiles() error {
+	if db.activeVlog != nil {
+		if err := db.activeVlog.Close(); err != nil {
+			return err
+		}
+		db.activeVlog = nil
+	}
+	for fid, vlogFile := range db.oldVlogs {
+		if err := vlogFile.Close(); err != nil {
+			return err
+		}
+		delete(db.oldVlogs, fid)
+	}
+	return nil
+}
+
+// valueLogFileNum 返回 value log 文件的数量
+// 在使用此方法前必须持有锁
+func (db *DB) valueLogFileNum() uint {
+	n := uint(len(db.oldVlogs))
+	if db.activeVlog != nil {
+		n++
+	}
+	return n
+}
+
+// valueLogSize 返回所有 value log 文件的大小
+// 在使用此方法前必须持有锁
+func (db *DB) valueLogSize() (int64, error) {
+	var total int64
+	for _, vlogFile := range db.valueLogFiles() {
+		size, err := vlogFile.IoManager.Size()
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// valueLogFiles 按照文件 id 从小到大返回所有的 value log 文件
+// 在使用此方法前必须持有锁
+func (db *DB) valueLogFiles() []*data.DataFile {
+	vlogFiles := make([]*data.DataFile, 0, len(db.oldVlogs)+1)
+	for _, vlogFile := range db.oldVlogs {
+		vlogFiles = append(vlogFiles, vlogFile)
+	}
+	sort.Slice(vlogFiles, func(i, j int) bool {
+		return vlogFiles[i].FileId < vlogFiles[j].FileId
+	})
+	if db.activeVlog != nil {
+		vlogFiles = append(vlogFiles, db.activeVlog)
+	}
+	return vlogFiles
+}
+
+// sealValueLog 当前的 value log 文件转换为旧的文件，之后的写入会打开新的文件
+// 在使用此方法前必须持有互斥锁
+func (db *DB) sealValueLog() error {
+	if db.activeVlog == nil {
+		return nil
+	}
+	if err := db.activeVlog.Sync(); err != nil {
+		return err
+	}
+	db.oldVlogs[db.activeVlog.FileId] = db.activeVlog
+	db.activeVlog = nil
+	return nil
+}
+
+// separateValue 将达到阈值的 value 写入 value log 文件，返回只记录 value 位置的记录
+// 在使用此方法前必须持有互斥锁
+func (db *DB) separateValue(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	if db.options.ValueLogThreshold <= 0 || logRecord.Type != data.LogRecordNormal ||
+		logRecord.ValuePointer || len(logRecord.Value) < db.options.ValueLogThreshold {
+		return logRecord, nil
+	}
+	vpos, err := db.appendValueLog(logRecord)
+	if err != nil {
+		return nil, err
+	}
+	return &data.LogRecord{
+		Key:          logRecord.Key,
+		Value:        data.EncodeLogRecordPos(vpos),
+		Type:         logRecord.Type,
+		Expire:       logRecord.Expire,
+		ValuePointer: true,
+	}, nil
+}
+
+// appendValueLog 追加写入记录到 value log 文件，记录中保留 key 用于 GC 时判断 value 是否有效
+// 在使用此方法前必须持有互斥锁
+func (db *DB) appendValueLog(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	logRecord, err := db.compressLogRecord(logRecord)
+	if err != nil {
+		return nil, err
+	}
+	if db.cipher != nil {
+		logRecord = db.cipher.Seal(logRecord)
+	}
+	encRecord, size := data.EncodeLogRecord(logRecord)
+
+	// 写满之后打开新的 value log 文件
+	if db.activeVlog != nil && db.options.ValueLogFileSize > 0 &&
+		db.activeVlog.WriteOff+size > db.options.ValueLogFileSize {
+		if err := db.sealValueLog(); err != nil {
+			return nil, err
+		}
+	}
+	if db.activeVlog == nil {
+		var fileId uint32
+		for fid := range db.oldVlogs {
+			if fid >= fileId {
+				fileId = fid + 1
+			}
+		}
+		vlogFile, err := data.OpenValueLogFile(db.options.DirPath, fileId, fio.StandardFIO)
+		if err != nil {
+			return nil, err
+		}
+		vlogFile.Cipher = db.cipher
+		db.activeVlog = vlogFile
+	}
+
+	writeOff := db.activeVlog.WriteOff
+	if err := db.activeVlog.Write(encRecord); err != nil {
+		return nil, err
+	}
+	// value 需要先于引用它的记录持久化
+	if db.options.SyncWrite {
+		if err := db.activeVlog.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	return &data.LogRecordPos{Fid: db.activeVlog.FileId, Offset: writeOff, Size: uint32(size)}, nil
+}
+
+// readValueLog 读取 value log 文件中 vpos 位置的记录
+func (db *DB) readValueLog(vpos *data.LogRecordPos) (*data.LogRecord, error) {
+	vlogFile := db.oldVlogs[vpos.Fid]
+	if db.activeVlog != nil && db.activeVlog.FileId == vpos.Fid {
+		vlogFile = db.activeVlog
+	}
+	if vlogFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	logRecord, _, err := vlogFile.ReadLogRecord(vpos.Offset)
+	return logRecord, err
+}
+
+// ValueLogGC 回收 value log 文件中无效的 value
+// 无效数据的比例达到 ValueLogGCRatio 的文件会被重写，其中有效的 value 写入新的 value log 文件，
+// 并在数据文件中追加指向新位置的记录，之后删除旧的文件，与 merge 不能同时进行
+func (db *DB) ValueLogGC() error {
+	db.lock()
+	if db.isMerging {
+		db.unlock()
+		return ErrMergeIsProgress
+	}
+	// 快照中的旧记录仍然可能引用需要删除的文件
+	if len(db.snapshots) > 0 {
+		db.unlock()
+		return ErrSnapshotIsAlive
+	}
+	// 当前的 value log 文件同样参与 GC，重写的 value 写入新的文件
+	if err := db.sealValueLog(); err != nil {
+		db.unlock()
+		return err
+	}
+	vlogFiles := db.valueLogFiles()
+	db.isMerging = true
+	db.unlock()
+
+	defer func() {
+		db.lock()
+		db.isMerging = false
+		db.unlock()
+	}()
+
+	for _, vlogFile := range vlogFiles {
+		ratio, err := db.valueLogDiscardRatio(vlogFile)
+		if err != nil {
+			return err
+		}
+		if ratio < db.options.ValueLogGCRatio {
+			continue
+		}
+		if err := db.rewriteValueLog(vlogFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanValueLog 遍历 value log 文件中的所有记录
+// 只有文件末尾会出现没有完整写入的数据，遇到损坏的记录时结束遍历
+func scanValueLog(vlogFile *data.DataFile, fn func(logRecord *data.LogRecord, offset int64, size int64) error) error {
+	var offset = vlogFile.HeaderSize()
+	for {
+		logRecord, size, err := vlogFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF || errors.Is(err, data.ErrInvalidCRC) {
+				return nil
+			}
+			return err
+		}
+		if err := fn(logRecord, offset, size); err != nil {
+			return err
+		}
+		offset += size
+	}
+}
+
+// liveValueRecord 判断 value log 中 vpos 位置的 value 是否仍然被 key 的最新记录引用，是则返回这条记录
+// 在使用此方法前必须持有锁
+func (db *DB) liveValueRecord(key []byte, vpos *data.LogRecordPos, now int64) (*data.LogRecord, error) {
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired(now) {
+		return nil, nil
+	}
+	logRecord, err := db.readLogRecord(pos)
+	if err != nil {
+		return nil, err
+	}
+	if logRecord.Type != data.LogRecordNormal || !logRecord.ValuePointer {
+		return nil, nil
+	}
+	if ptr := data.DecodeLogRecordPos(logRecord.Value); ptr.Fid != vpos.Fid || ptr.Offset != vpos.Offset {
+		return nil, nil
+	}
+	return logRecord, nil
+}
+
+// valueLogDiscardRatio 计算 value log 文件中无效数据的比例
+func (db *DB) valueLogDiscardRatio(vlogFile *data.DataFile) (float32, error) {
+	fileSize, err := vlogFile.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	total := fileSize - vlogFile.HeaderSize()
+	if total <= 0 {
+		return 1, nil
+	}
+
+	var live int64
+	now := time.Now().UnixNano()
+	err = scanValueLog(vlogFile, func(logRecord *data.LogRecord, offset int64, size int64) error {
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		record, err := db.liveValueRecord(realKey, &data.LogRecordPos{Fid: vlogFile.FileId, Offset: offset}, now)
+		if record != nil {
+			live += size
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return float32(total-live) / float32(total), nil
+}
+
+// rewriteValueLog 重写 value log 文件中有效的 value，之后删除这个文件
+func (db *DB) rewriteValueLog(vlogFile *data.DataFile) error {
+	now := time.Now().UnixNano()
+	err := scanValueLog(vlogFile, func(logRecord *data.LogRecord, offset int64, _ int64) error {
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		db.lock()
+		defer db.unlock()
+		// 判断和更新需要在同一个临界区内完成，避免覆盖 GC 期间写入的新数据
+		record, err := db.liveValueRecord(realKey, &data.LogRecordPos{Fid: vlogFile.FileId, Offset: offset}, now)
+		if err != nil || record == nil {
+			return err
+		}
+		logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+		vpos, err := db.appendValueLog(logRecord)
+		if err != nil {
+			return err
+		}
+		pos, err := db.appendLogRecord(&data.LogRecord{
+			Key:          logRecord.Key,
+			Value:        data.EncodeLogRecordPos(vpos),
+			Expire:       record.Expire,
+			ValuePointer: true,
+		})
+		if err != nil {
+			return err
+		}
+		return db.updateIndex(func(idx indexWriter) {
+			db.addReclaimable(idx.Put(realKey, pos))
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	db.lock()
+	defer db.unlock()
+	// 重写之后的数据持久化之后才能删除旧的文件
+	if db.activeVlog != nil {
+		if err := db.activeVlog.Sync(); err != nil {
+			return err
+		}
+	}
+	if db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+	}
+	// 存在快照或者未关闭的迭代器时保留旧的文件，下次 GC 时再删除
+	if len(db.snapshots) > 0 || db.iterators > 0 {
+		return nil
+	}
+	if err := vlogFile.Close(); err != nil {
+		return err
+	}
+	delete(db.oldVlogs, vlogFile.FileId)
+	return os.Remove(data.GetValueLogFileName(db.options.DirPath, vlogFile.FileId))
+}
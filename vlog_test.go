@@ -0,0 +1,138 @@
+package gobitcask
+
+import (
+	"bytes"
+	"go-bitcask/data"
+	"go-bitcask/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// filesSize 统计数据目录中指定后缀的文件大小
+func filesSize(t *testing.T, dir, suffix string) int64 {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	var size int64
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), suffix) {
+			info, err := os.Stat(filepath.Join(dir, entry.Name()))
+			assert.Nil(t, err)
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+func TestDB_ValueLog(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-vlog")
+	opts.DirPath = dir
+	opts.ValueLogThreshold = 1024
+	opts.ValueLogFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	large := utils.RandomValue(4096)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), large))
+		assert.Nil(t, db.Put([]byte("meta-"+string(utils.GetTestKey(i))), []byte("small")))
+	}
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.ValueLogFileNum > 1)
+	// 数据文件中只有 value 的位置
+	assert.True(t, filesSize(t, dir, data.DataFileNameSuffix) < 100*1024)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+	val, err = db.Get([]byte("meta-" + string(utils.GetTestKey(1))))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("small"), val)
+
+	// merge 不会拷贝 value log 中的数据
+	vlogSize := filesSize(t, dir, data.ValueLogFileSuffix)
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, vlogSize, filesSize(t, dir, data.ValueLogFileSuffix))
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+
+	// 重新打开之后可以读取
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, large, val)
+	}
+}
+
+func TestDB_ValueLogGC(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-vlog-gc")
+	opts.DirPath = dir
+	opts.ValueLogThreshold = 1024
+	opts.ValueLogFileSize = 64 * 1024
+	opts.Compression = FlateCompression
+	opts.EncryptionKey = bytes.Repeat([]byte("k"), 16)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	value := bytes.Repeat([]byte("value"), 1000)
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(4096)))
+	}
+	// 覆盖写和删除大部分数据
+	for i := 0; i < 180; i++ {
+		if i%2 == 0 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		} else {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("small")))
+		}
+	}
+	assert.Nil(t, db.Put(utils.GetTestKey(199), value))
+
+	// 存在快照时不能 GC
+	snap := db.NewSnapshot()
+	assert.Equal(t, ErrSnapshotIsAlive, db.ValueLogGC())
+	assert.Nil(t, snap.Release())
+
+	before := filesSize(t, dir, data.ValueLogFileSuffix)
+	assert.Nil(t, db.ValueLogGC())
+	after := filesSize(t, dir, data.ValueLogFileSuffix)
+	assert.True(t, after < before/2)
+
+	check := func(db *DB) {
+		for i := 0; i < 180; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			if i%2 == 0 {
+				assert.Equal(t, ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("small"), val)
+			}
+		}
+		for i := 180; i < 199; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, len(utils.RandomValue(4096)), len(val))
+		}
+		val, err := db.Get(utils.GetTestKey(199))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	check(db)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
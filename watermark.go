@@ -0,0 +1,106 @@
+package gobitcask
+
+import (
+	"errors"
+	"go-bitcask/data"
+	"go-bitcask/index"
+)
+
+// indexWriter 写入数据时更新索引使用的方法
+type indexWriter interface {
+	Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos
+	Get(key []byte) *data.LogRecordPos
+	Delete(key []byte) bool
+}
+
+// updateIndex 执行 fn 中对索引的修改
+// B+ 树索引的修改和水位在同一个事务中提交，崩溃之后水位与索引保持一致，fn 中只能通过 idx 访问索引
+// 在使用此方法前必须持有互斥锁
+func (db *DB) updateIndex(fn func(idx indexWriter)) error {
+	bpt, ok := db.index.(*index.BPlusTree)
+	if !ok {
+		fn(db.index)
+		return nil
+	}
+	var dirty map[uint32]int64
+	if err := bpt.Update(func(tx *index.BPlusTreeTx) *index.Watermark {
+		fn(tx)
+		if db.activeFile == nil {
+			return nil
+		}
+		// 只记录发生变化的数据文件中可回收的字节数
+		dirty = make(map[uint32]int64, len(db.dirtyFids))
+		for fid := range db.dirtyFids {
+			dirty[fid] = db.reclaimable[fid]
+		}
+		return &index.Watermark{
+			Fid:         db.activeFile.FileId,
+			Offset:      db.activeFile.WriteOff,
+			SeqNo:       db.seqNo,
+			Reclaimable: dirty,
+		}
+	}); err != nil {
+		return err
+	}
+	for fid := range dirty {
+		delete(db.dirtyFids, fid)
+	}
+	return nil
+}
+
+// saveWatermark 在 B+ 树索引中记录已经应用到索引的数据位置、事务序列号以及所有数据文件可回收的字节数
+// 在加载索引、merge 以及关闭时调用，写入数据时水位由 updateIndex 与索引一起记录；
+// 没有持久化的数据丢失时水位会失效，启动时重新构建索引
+// 在使用此方法前必须持有互斥锁，此时所有写入数据文件的记录都已经更新到索引中
+func (db *DB) saveWatermark() error {
+	bpt, ok := db.index.(*index.BPlusTree)
+	if !ok || db.activeFile == nil {
+		return nil
+	}
+	if err := bpt.SetWatermark(&index.Watermark{
+		Fid:         db.activeFile.FileId,
+		Offset:      db.activeFile.WriteOff,
+		SeqNo:       db.seqNo,
+		Reclaimable: db.reclaimable,
+	}); err != nil {
+		return err
+	}
+	clear(db.dirtyFids)
+	return nil
+}
+
+// clearWatermark 删除 B+ 树索引中的水位，数据文件被替换之后水位不再有效，下次启动时重新构建索引
+func (db *DB) clearWatermark() error {
+	if bpt, ok := db.index.(*index.BPlusTree); ok {
+		return bpt.SetWatermark(nil)
+	}
+	return nil
+}
+
+// loadBPlusTreeIndex 加载 B+ 树索引
+// 索引中的数据已经持久化，只需要从数据文件中重放水位之后写入的数据；
+// 水位不存在或者无效时清空索引，从 hint 文件和数据文件中重新构建
+func (db *DB) loadBPlusTreeIndex() error {
+	bpt := db.index.(*index.BPlusTree)
+	wm, err := bpt.Watermark()
+	if err != nil && !errors.Is(err, index.ErrInvalidWatermark) {
+		return err
+	}
+
+	if wm != nil {
+		pos := &data.LogRecordPos{Fid: wm.Fid, Offset: wm.Offset}
+		if db.coversPosition(pos) {
+			db.seqNo = wm.SeqNo
+			db.reclaimable = wm.Reclaimable
+			return db.loadIndexFromDataFile(pos)
+		}
+	}
+
+	if err := bpt.Reset(); err != nil {
+		return err
+	}
+	if err := db.loadIndexFromHintFile(); err != nil {
+		return err
+	}
+	return db.loadIndexFromDataFile(nil)
+}
@@ -0,0 +1,160 @@
+package gobitcask
+
+import (
+	"go-bitcask/index"
+	"go-bitcask/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_BPlusTree_Watermark(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-watermark")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Sync())
+	wm, err := db.index.(*index.BPlusTree).Watermark()
+	assert.Nil(t, err)
+	assert.NotNil(t, wm)
+
+	// 水位之后写入的数据
+	for i := 500; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	wb := db.NewWriteBtach(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(2000), []byte("batch")))
+	assert.Nil(t, wb.Commit())
+	seqNo := db.seqNo
+	assert.Nil(t, db.Close())
+
+	// 模拟写入数据文件之后、更新 B+ 树索引之前崩溃：索引中没有水位之后的数据
+	bpt := index.NewBPlusTree(dir, false)
+	for i := 500; i < 1000; i++ {
+		bpt.Delete(utils.GetTestKey(i))
+	}
+	bpt.Delete(utils.GetTestKey(2000))
+	bpt.Put(utils.GetTestKey(0), bpt.Get(utils.GetTestKey(1)))
+	assert.Nil(t, bpt.SetWatermark(wm))
+	assert.Nil(t, bpt.Close())
+
+	// 启动时重放水位之后的数据，同时恢复事务序列号
+	opts.MMapAtStartup = true
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	assert.Equal(t, seqNo, db.seqNo)
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch"), val)
+	val, err = db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 重放之后可以继续写入，写入的位置是正确的
+	assert.Nil(t, db.Put(utils.GetTestKey(3000), []byte("after")))
+	val, err = db.Get(utils.GetTestKey(3000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after"), val)
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(3000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after"), val)
+}
+
+func TestDB_BPlusTree_Watermark_Missing(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-watermark")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 没有水位时清空索引中残留的数据，从数据文件中重新构建
+	bpt := index.NewBPlusTree(dir, false)
+	bpt.Put(utils.GetTestKey(1), bpt.Get(utils.GetTestKey(500)))
+	assert.Nil(t, bpt.SetWatermark(nil))
+	assert.Nil(t, bpt.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// merge 之后水位指向新的位置
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
+
+func TestDB_BPlusTree_Watermark_Reclaimable(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "bitcask-go-watermark")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%100), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.True(t, stat.ReclaimableSize > 0)
+
+	// 每次写入都会在同一个事务中记录水位，只更新发生变化的数据文件
+	wm, err := db.index.(*index.BPlusTree).Watermark()
+	assert.Nil(t, err)
+	assert.Equal(t, db.activeFile.WriteOff, wm.Offset)
+	assert.Equal(t, db.reclaimable, wm.Reclaimable)
+	assert.Equal(t, stat.ReclaimableSize, db.reclaimableSize())
+	assert.Equal(t, 0, len(db.dirtyFids))
+
+	// 模拟水位落后于索引，重放的数据不能重复计入可回收空间
+	assert.Nil(t, db.Put(utils.GetTestKey(1000), utils.RandomValue(64)))
+	assert.Nil(t, db.Close())
+	bpt := index.NewBPlusTree(dir, false)
+	assert.Nil(t, bpt.SetWatermark(wm))
+	assert.Nil(t, bpt.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	stat2, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, stat.ReclaimableSize, stat2.ReclaimableSize)
+	assert.Equal(t, uint(100), stat2.KeyNum)
+}